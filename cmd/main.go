@@ -1,35 +1,553 @@
 package main
 
 import (
-	"caching-proxy/internal/argparser"
-	"caching-proxy/internal/cache/filecache"
-	"caching-proxy/internal/proxy"
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/ig-rudenko/caching-proxy/internal/admin"
+	"github.com/ig-rudenko/caching-proxy/internal/argparser"
+	"github.com/ig-rudenko/caching-proxy/internal/audit"
+	"github.com/ig-rudenko/caching-proxy/internal/cli"
+	"github.com/ig-rudenko/caching-proxy/internal/config"
+	"github.com/ig-rudenko/caching-proxy/internal/metrics"
+	"github.com/ig-rudenko/caching-proxy/internal/middleware"
+	"github.com/ig-rudenko/caching-proxy/internal/server"
+	"github.com/ig-rudenko/caching-proxy/internal/service"
+	"github.com/ig-rudenko/caching-proxy/internal/signals"
+	"github.com/ig-rudenko/caching-proxy/internal/systemd"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/filecache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/hot"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/memory"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/remote"
+	"github.com/ig-rudenko/caching-proxy/pkg/geoip"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// "service install|uninstall|start|stop|run" manages the proxy as a Windows service
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		if err := service.Command(os.Args[2:], run); err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+		return
+	}
+
+	// Management commands such as "inspect" work on the cache folder or a running proxy
+	if len(os.Args) > 1 {
+		if command, ok := cli.Lookup(os.Args[1]); ok {
+			// Errors go to standard error, so they never mix with JSON results on standard output
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Stop gracefully on SIGTERM or SIGINT, e.g. during a rolling update; a second signal stops at once
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	run(ctx)
+}
+
+// run starts the proxy configured by the command-line arguments and serves it until the context is done,
+// then shuts it down gracefully
+func run(ctx context.Context) {
 	// Create a new ArgParser instance to handle command-line arguments
 	arg := argparser.New()
 	// Parse command-line arguments and set the corresponding fields in ArgParser
 	arg.Parse()
 
+	// Record purges, mode toggles and admin API calls when an audit log is configured
+	var auditLog *audit.Log
+	if arg.AuditLog != "" {
+		var err error
+		if auditLog, err = audit.Open(arg.AuditLog); err != nil {
+			log.Fatalf("Error opening audit log: %s\n", err)
+		}
+		defer auditLog.Close()
+	}
+
 	// Create a new Cache instance with the specified timeout and cache folder from ArgParser
-	cache := filecache.New(arg.CacheTimeout, arg.CacheFolder)
+	cache := newCache(arg)
 
 	// If the --clear-cache flag was set, clear all cached data and exit the program
 	if arg.ClearCache {
-		cache.ClearAll()
+		if err := cache.ClearAll(); err != nil {
+			log.Fatalf("Error clearing the cache: %s\n", err)
+		}
+		auditLog.RecordOperator(audit.SourceCLI, "cache cleared")
+		_ = auditLog.Close()
 		os.Exit(0)
 	}
 
-	// Start the cache cleanup process in a separate goroutine
+	// Keep expired entries on disk while they can still be served as stale copies or refreshed conditionally
+	cache.SetGracePeriod(max(arg.ServeStaleOnError, arg.RevalidateWindow))
+	// In offline and read-only modes the cache is a snapshot that is never modified
+	cache.SetKeepExpired(arg.Offline || arg.ReadOnlyCache || arg.RecordReplay == proxy.ModeReplay)
+
+	// Start the cache cleanup process in a separate goroutine; it also runs with --no-cache,
+	// since caching can be switched on at runtime
 	cache.RunCleanUp()
 
+	// Configure the proxy from the command-line arguments and the configuration file
+	opts := []proxy.Option{
+		// Set the value mixed into every cache key
+		proxy.WithCacheNamespace(arg.CacheNamespace),
+		// Set whether paths with and without a trailing slash share a cache entry
+		proxy.WithFoldTrailingSlash(arg.FoldTrailingSlash),
+		// Set whether every request host is a separate cache tenant
+		proxy.WithTenantByHost(arg.TenantByHost),
+		// Set the path prefix the proxy is mounted under
+		proxy.WithPathPrefix(arg.PathPrefix, arg.PathPrefixTarget),
+		// Set the query parameters left out of cache keys
+		proxy.WithIgnoredQueryParams(arg.IgnoreQueryParams),
+		// Set the languages cached as separate variants
+		proxy.WithLanguageVariants(arg.LanguageVariants),
+		// Set the proxies whose forwarding headers are trusted
+		proxy.WithTrustedProxies(arg.TrustedProxies),
+		// Set the Host header sent to the origin
+		proxy.WithHostHeader(arg.HostHeader),
+		// Set which cookies do not prevent a response from being cached
+		proxy.WithCacheableCookies(arg.CacheableCookies),
+		// Set the TTL of responses without their own, and how long expired copies may be served if the origin fails
+		proxy.WithDefaultTTL(arg.CacheTimeout),
+		proxy.WithServeStaleOnError(arg.ServeStaleOnError),
+		// Set how long a Retry-After of the origin is honored at most
+		proxy.WithMaxRetryAfter(arg.MaxRetryAfter),
+		// Set the age after which cached responses are refreshed in the background
+		proxy.WithSoftTTL(arg.SoftTTL),
+		// Set the eagerness of probabilistic refresh shortly before expiry
+		proxy.WithEarlyRefresh(arg.EarlyRefresh),
+		// Set the origin header overriding the TTL of a response
+		proxy.WithTTLHeader(arg.TTLHeader),
+		// Set the TTL of cached 404, 410 and optionally 5xx responses
+		proxy.WithNegativeCaching(arg.NegativeCacheTTL, arg.NegativeCache5xx),
+		// Set the rules deciding caching by response Content-Type
+		proxy.WithContentTypeRules(arg.Config.ContentTypes),
+		// Set the clients allowed to bypass the cache with no-cache
+		proxy.WithNoCacheClients(arg.NoCacheClients),
+		// Set the request headers added to the cache key per path
+		proxy.WithVaryRules(arg.Config.Vary),
+		// Set the paths whose POST responses are cached
+		proxy.WithPostCacheRules(arg.Config.PostCache),
+		// Set the named routes labeling the metrics
+		proxy.WithRoutes(arg.Config.Routes),
+		// Set the settings overridden per tenant
+		proxy.WithTenantRules(arg.Config.Tenants),
+		// Set the paths whose cached responses never expire
+		proxy.WithPinned(arg.Config.Pinned),
+		// Set the record/replay mode
+		proxy.WithRecordReplay(arg.RecordReplay),
+		// Set the origin used when the primary one fails, and the time limit for origin requests
+		proxy.WithFallbackOrigin(arg.FallbackOrigin),
+		proxy.WithOriginTimeout(arg.OriginTimeout),
+		// Set how many origin redirects are followed rather than passed to clients
+		proxy.WithFollowRedirects(arg.FollowRedirects),
+		// Set the tuning of the connections to the origin
+		proxy.WithOriginPool(arg.OriginPool),
+		// Set how long permanent redirects passed through are cached
+		proxy.WithRedirectTTL(arg.RedirectCacheTTL),
+		// Set the delay before a hedged request is sent to a slow origin
+		proxy.WithHedgeDelay(arg.HedgeDelay),
+		// Set the secondary origin receiving a copy of the traffic
+		proxy.WithShadow(arg.ShadowOrigin, arg.ShadowPercent),
+		// Set the other instances of the cluster sharing the cache
+		proxy.WithPeers(arg.PeerSelf, arg.Peers),
+		// Set the body size above which responses stream through, and whether they are cached in the background
+		proxy.WithStreamThreshold(int64(arg.StreamThresholdMB)<<20, arg.StreamCache),
+		// Set the standby instances the stored entries are pushed to
+		proxy.WithReplicas(replicaCaches(arg.Replicas, arg.ReplicationToken, arg.CacheTimeout)),
+		// Set the header rewrite and CORS rules from the configuration file
+		proxy.WithHeaderRules(arg.Config.Headers),
+		proxy.WithCORSRules(arg.Config.CORS),
+		// Set the credentials added to the origin requests
+		proxy.WithOriginCredentials(arg.Config.OriginAuth),
+		// Set how many cache hits are counted per logged one
+		proxy.WithHitLogSampling(arg.LogSampleHits),
+	}
+	// Generate unique cache per user based on User-Agent and cookies
+	if arg.UniqueByUser {
+		opts = append(opts, proxy.WithUniqueByUser())
+	}
+	// Rewrite origin host links in response bodies to the proxy host
+	if arg.RewriteBodyHost {
+		opts = append(opts, proxy.WithRewriteBodyHost())
+	}
+	// Cache responses with Set-Cookie regardless of the cookie names
+	if arg.CacheSetCookie {
+		opts = append(opts, proxy.WithCacheSetCookie())
+	}
+	// Answer only from the cache, never store new responses, or run as a plain reverse proxy
+	if arg.Offline {
+		opts = append(opts, proxy.WithOffline())
+	}
+	if arg.ReadOnlyCache {
+		opts = append(opts, proxy.WithReadOnlyCache())
+	}
+	if arg.NoCache {
+		opts = append(opts, proxy.WithNoCache())
+	}
+	// Set how origin addresses are resolved
+	if arg.DNSCacheTTL > 0 {
+		opts = append(opts, proxy.WithDNSCacheTTL(arg.DNSCacheTTL))
+	}
+	if len(arg.OriginResolve) > 0 {
+		opts = append(opts, proxy.WithOriginResolve(arg.OriginResolve))
+	}
+	if len(arg.OriginDNS) > 0 {
+		opts = append(opts, proxy.WithOriginDNSServers(arg.OriginDNS))
+	}
+
+	// Locate clients with the GeoIP database
+	if arg.GeoIPDatabase != "" {
+		db, err := geoip.Open(arg.GeoIPDatabase)
+		if err != nil {
+			log.Fatalf("Error loading GeoIP database: %s\n", err)
+		}
+		opts = append(opts, proxy.WithGeoIP(db, arg.GeoVary))
+	}
+
+	// Load the error page templates from the configuration file
+	if len(arg.Config.ErrorPages) > 0 {
+		pages, err := proxy.LoadErrorPages(arg.Config.ErrorPages)
+		if err != nil {
+			log.Fatalf("Error loading error pages: %s\n", err)
+		}
+		opts = append(opts, proxy.WithErrorPages(pages))
+	}
+
+	// Start the hook script from the configuration file
+	if arg.Config.Hook != nil {
+		hook, err := proxy.StartHook(*arg.Config.Hook)
+		if err != nil {
+			log.Fatalf("Error starting hook: %s\n", err)
+		}
+		opts = append(opts, proxy.WithHook(hook))
+	}
+
 	// Create a new Proxy instance with the cache and origin URL from ArgParser
-	p := proxy.New(cache, arg.Origin)
-	// Set whether to generate unique cache per user based on User-Agent and cookies
-	p.SetUniqueByUser(arg.UniqueByUser)
+	p := proxy.New(cache, arg.Origin, opts...)
+
+	// Use the listeners from the configuration file, or a single one built from --host and --port
+	listeners := arg.Config.Listeners
+	if arg.Port != 0 {
+		listeners = append(listeners, config.Listener{
+			Address: net.JoinHostPort(arg.Host, strconv.Itoa(arg.Port)),
+			Handler: config.HandlerProxy,
+		})
+	}
+
+	// Publish the origin histograms with the other metrics served at /debug/vars by the admin API
+	expvar.Publish("proxy_origin", expvar.Func(func() any { return p.OriginMetrics() }))
+	expvar.Publish("proxy_routes", expvar.Func(func() any { return p.RouteStats() }))
+	expvar.Publish("proxy_tenants", expvar.Func(func() any { return p.TenantStats() }))
+	expvar.Publish("proxy_origin_health", expvar.Func(func() any { return p.OriginHealth() }))
+
+	// Alert when the hit ratio drops or origin errors rise past their thresholds
+	if arg.AlertHitRatio > 0 || arg.AlertErrorRate > 0 {
+		alerts := metrics.NewAlerts(p.Stats, metrics.Thresholds{
+			MinHitRatio:  arg.AlertHitRatio / 100,
+			MaxErrorRate: arg.AlertErrorRate / 100,
+			Window:       arg.AlertWindow,
+			Webhook:      arg.AlertWebhook,
+		})
+		go alerts.Run(ctx)
+	}
+
+	// Keep the cluster peers up to date from DNS
+	if arg.PeersDNS != "" {
+		go proxy.NewPeerDiscovery(p, arg.PeerSelf, arg.PeersDNS, arg.PeersDNSInterval).Run(ctx)
+	}
+
+	// Publish the cache capacity, refreshed in the background from counters the cache keeps up to date
+	var capacity *metrics.Capacity
+	if reporter, ok := cacheUsage(cache); ok {
+		capacity = metrics.NewCapacity(reporter)
+		go capacity.Run(ctx)
+		expvar.Publish("cache_capacity", expvar.Func(func() any { return capacity.Gauges() }))
+	}
+
+	// Push the metrics for environments without a scraping Prometheus
+	if arg.MetricsPush != nil {
+		pusher := metrics.NewPusher(func() metrics.Snapshot {
+			s := metrics.Snapshot{Stats: p.Stats(), Health: p.OriginHealth(), Routes: p.RouteStats(), Tenants: p.TenantStats(), Origin: p.OriginMetrics()}
+			if capacity != nil {
+				gauges := capacity.Gauges()
+				s.Capacity = &gauges
+			}
+			return s
+		}, arg.MetricsPush, arg.MetricsPushFormat, arg.MetricsPushEvery)
+		go pusher.Run(ctx)
+	}
+
+	// The admin API reports the proxy ready when it can store responses and reach the origin
+	adm := admin.New(cache, p)
+	if arg.ReplicationToken != "" {
+		adm.EnableReplication(arg.ReplicationToken)
+	}
+	if disk, ok := diskCache(cache); ok {
+		adm.AddReadinessCheck("cache", disk.CheckWritable)
+		if arg.TenantByHost {
+			expvar.Publish("cache_tenants", expvar.Func(func() any { return disk.TenantUsage() }))
+		}
+	}
+	adm.AddReadinessCheck("origin", p.CheckOrigin)
+	// Persist the counters, so the stats command can read them once the proxy is stopped
+	if arg.StatsFile != "" {
+		go adm.PersistReports(ctx, arg.StatsFile)
+	}
+	// End the event streams of the admin API on termination, so the graceful shutdown does not wait for them
+	context.AfterFunc(ctx, adm.Close)
+
+	// Stream the entries the cache removes on its own with the rest of the cache activity
+	if notifier, ok := removalNotifier(cache); ok {
+		notifier.SetOnRemoval(func(key, reason string) {
+			p.Publish(proxy.Event{Type: proxy.EventEvict, Key: key, Reason: reason})
+		})
+	}
+
+	// Dump runtime stats to the log on SIGUSR1, for instances without an admin listener
+	go dumpStatsOnSignal(cache, p)
+	// Switch between caching and pass-through on SIGUSR2, e.g. when stale content is suspected
+	go toggleCacheOnSignal(p, auditLog)
+
+	// Handlers that can be served on a listener
+	handlers := map[string]http.Handler{
+		config.HandlerProxy: p,
+		config.HandlerAdmin: auditLog.Middleware(adm),
+	}
+
+	// Create a server for each listener wrapped with its own middleware set
+	group := server.NewGroup()
+	for _, l := range listeners {
+		handler, err := middleware.Chain(handlers[l.Handler], l.Middlewares)
+		if err != nil {
+			log.Fatalf("Error configuring listener %s: %s\n", l.Address, err)
+		}
+		group.Add(server.New(l, handler))
+	}
+
+	// Open all listeners, then tell systemd the proxy is ready, if it runs as a notify service
+	log.Printf("Starting caching proxy server, forwarding requests to %s\n", arg.Origin.String())
+	if err := group.Listen(); err != nil {
+		log.Fatalln("Error starting server:", err)
+	}
+	if err := systemd.Notify(systemd.Ready); err != nil {
+		log.Printf("Error notifying systemd: %s\n", err)
+	}
+
+	// Write the PID file once the proxy accepts connections, so init scripts can signal it
+	if arg.PIDFile != "" {
+		if err := writePIDFile(arg.PIDFile); err != nil {
+			log.Fatalf("Error writing PID file: %s\n", err)
+		}
+		defer os.Remove(arg.PIDFile)
+	}
+
+	// Serve all listeners and block until one of them fails or a termination signal arrives
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- group.Serve()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			log.Fatalln("Error starting server:", err)
+		}
+	case <-ctx.Done():
+		shutdown(group, p, arg.ShutdownTimeout)
+		if arg.StatsFile != "" {
+			if err := adm.WriteReport(arg.StatsFile); err != nil {
+				log.Printf("Error writing stats file: %s\n", err)
+			}
+		}
+	}
+}
+
+// shutdown stops accepting connections, then waits for in-flight requests and the cache writes
+// they started, giving up after the timeout
+func shutdown(group *server.Group, p *proxy.Proxy, timeout time.Duration) {
+	log.Printf("Shutting down, waiting up to %s for in-flight requests\n", timeout)
+	_ = systemd.Notify(systemd.Stopping)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := group.Shutdown(ctx); err != nil {
+		log.Printf("Error draining requests: %s\n", err)
+	}
+	if err := p.FlushCacheWrites(ctx); err != nil {
+		log.Printf("Error flushing cache writes: %s\n", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// cacheBackend is a cache implementation used by the proxy and managed by main
+type cacheBackend interface {
+	proxy.Cache
+	cache.Clearer
+	RunCleanUp()
+	SetGracePeriod(time.Duration)
+	SetKeepExpired(bool)
+}
+
+// replicaCaches reaches the caches of the replicas through their admin APIs, authenticated with the token
+func replicaCaches(replicas []*url.URL, token string, timeout time.Duration) []cache.Cache {
+	caches := make([]cache.Cache, 0, len(replicas))
+	for _, replica := range replicas {
+		c := remote.New(replica.JoinPath("replication"), timeout)
+		c.SetToken(token)
+		caches = append(caches, c)
+	}
+	return caches
+}
 
-	// Start the proxy server on the specified host and port
-	p.Start(arg.Host, arg.Port)
+// newCache creates the cache backend: a local directory origin is cached in memory, anything else on disk
+// or in the cache sidecar, optionally with the most requested entries kept in memory
+func newCache(arg *argparser.ArgParser) cacheBackend {
+	if arg.Origin != nil && arg.Origin.Scheme == "file" {
+		return memory.New(arg.CacheTimeout)
+	}
+
+	var backend cacheBackend
+	if arg.CacheSidecar != nil {
+		backend = remote.New(arg.CacheSidecar, arg.CacheTimeout)
+	} else {
+		var disk *filecache.Cache
+		if arg.CacheShared {
+			disk = filecache.NewShared(arg.CacheTimeout, arg.CacheFolder)
+		} else {
+			disk = filecache.New(arg.CacheTimeout, arg.CacheFolder)
+		}
+		if arg.PreloadMB > 0 {
+			disk.Preload(int64(arg.PreloadMB) << 20)
+		}
+		if arg.TenantQuotaMB > 0 || len(arg.Config.TenantQuotas) > 0 {
+			quotas := make(map[string]int64, len(arg.Config.TenantQuotas))
+			for tenant, mb := range arg.Config.TenantQuotas {
+				quotas[tenant] = int64(mb) << 20
+			}
+			disk.SetTenantQuotas(int64(arg.TenantQuotaMB)<<20, quotas)
+		}
+		backend = disk
+	}
+
+	if arg.HotKeys > 0 {
+		return hot.New(backend, arg.HotKeys, arg.CacheTimeout)
+	}
+	return backend
+}
+
+// diskCache returns the disk cache behind the cache backend, if it is stored on disk
+func diskCache(c cacheBackend) (*filecache.Cache, bool) {
+	if h, ok := c.(*hot.Cache); ok {
+		c = h.Backend
+	}
+	disk, ok := c.(*filecache.Cache)
+	return disk, ok
+}
+
+// writePIDFile writes the process ID to the file, refusing to overwrite the file of another running proxy
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processRunning(pid) {
+			return fmt.Errorf("%s belongs to running process %d", path, pid)
+		}
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// processRunning reports whether a process with the ID exists; on Windows finding it already opens it
+func processRunning(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// dumpStatsOnSignal logs a snapshot of the runtime stats every time the stats signal arrives
+func dumpStatsOnSignal(cache cacheBackend, p *proxy.Proxy) {
+	sig := make(chan os.Signal, 1)
+	signals.NotifyStats(sig)
+
+	for range sig {
+		s := p.Stats()
+		health := p.OriginHealth()
+		entries := "unknown"
+		if n, ok := cacheEntries(cache); ok {
+			entries = strconv.Itoa(n)
+		}
+		log.Printf("Stats: hits=%d misses=%d bypasses=%d stale=%d hit_ratio=%.1f%% entries=%s goroutines=%d pending_cache_writes=%d "+
+			"origin_requests=%d origin_errors=%d origin_consecutive_failures=%d last_origin_error=%s last_origin_answer=%s\n",
+			s.Hits, s.Misses, s.Bypasses, s.Stale, s.HitRatio()*100, entries, runtime.NumGoroutine(), s.PendingWrites,
+			s.OriginRequests, s.OriginErrors, health.ConsecutiveFailures, formatTime(s.LastOriginError), formatTime(s.LastOriginAnswer))
+	}
+}
+
+// toggleCacheOnSignal switches the proxy between caching and pass-through every time the toggle signal arrives
+func toggleCacheOnSignal(p *proxy.Proxy, auditLog *audit.Log) {
+	sig := make(chan os.Signal, 1)
+	signals.NotifyToggleCache(sig)
+
+	for range sig {
+		p.SetNoCache(!p.NoCache())
+		if p.NoCache() {
+			log.Println("Caching disabled by signal, passing every request through")
+			auditLog.RecordOperator(audit.SourceSignal, "caching disabled")
+		} else {
+			log.Println("Caching enabled by signal")
+			auditLog.RecordOperator(audit.SourceSignal, "caching enabled")
+		}
+	}
+}
+
+// removalNotifier returns the cache as a removal notifier, if it can report the entries it removes on its own
+func removalNotifier(c cacheBackend) (cache.RemovalNotifier, bool) {
+	notifier, ok := c.(cache.RemovalNotifier)
+	return notifier, ok
+}
+
+// cacheUsage returns the cache as a usage reporter, if it can report its usage
+func cacheUsage(c cacheBackend) (cache.UsageReporter, bool) {
+	reporter, ok := c.(cache.UsageReporter)
+	return reporter, ok
+}
+
+// cacheEntries returns the number of entries in the cache, if the cache can tell
+func cacheEntries(c cacheBackend) (int, bool) {
+	if h, ok := c.(*hot.Cache); ok {
+		c = h.Backend
+	}
+	counter, ok := c.(interface{ Len() int })
+	if !ok {
+		return 0, false
+	}
+	return counter.Len(), true
+}
+
+// formatTime formats a time for the log, the zero time as "never"
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
 }
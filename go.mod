@@ -1,3 +1,3 @@
-module caching-proxy
+module github.com/ig-rudenko/caching-proxy
 
 go 1.23
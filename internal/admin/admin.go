@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/remote"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// Cache is the subset of cache operations available through the admin API
+type Cache interface {
+	cache.Cache
+	cache.Clearer
+}
+
+// Proxy is the subset of proxy settings that can be changed through the admin API
+type Proxy interface {
+	HitLogSampling() int
+	SetHitLogSampling(n int)
+	NoCache() bool
+	SetNoCache(is bool)
+	Stats() proxy.Stats
+	OriginHealth() proxy.OriginHealth
+	Subscribe(buffer int) (<-chan proxy.Event, func())
+	Publish(e proxy.Event)
+	Purge(tenant, target string) (int, error)
+	TopURLs(n int) []proxy.URLHits
+	TenantStats() []proxy.TenantStats
+}
+
+// Admin serves management endpoints for a running proxy
+type Admin struct {
+	cache     Cache            // Cache managed through the admin API
+	proxy     Proxy            // Proxy configured through the admin API
+	mux       *http.ServeMux   // Router for admin endpoints
+	checks    []readinessCheck // Checks deciding whether the proxy is ready for traffic
+	done      chan struct{}    // Closed to end the event streams
+	closeOnce sync.Once
+}
+
+// New creates a new Admin instance for the given cache and proxy
+func New(cache Cache, proxy Proxy) *Admin {
+	a := &Admin{cache: cache, proxy: proxy, mux: http.NewServeMux(), done: make(chan struct{})}
+	a.mux.HandleFunc("POST /cache/clear", a.handleClearCache)
+	a.mux.HandleFunc("POST /cache/purge", a.handlePurge)
+	a.mux.HandleFunc("POST /cache/gc", a.handleCollectGarbage)
+	a.mux.HandleFunc("GET /cache/status", a.handleCacheStatus)
+	a.mux.HandleFunc("POST /cache/enable", a.handleSetCaching(true))
+	a.mux.HandleFunc("POST /cache/disable", a.handleSetCaching(false))
+	a.mux.HandleFunc("GET /healthz", a.handleHealth)
+	a.mux.HandleFunc("GET /readyz", a.handleReady)
+	a.mux.Handle("GET /debug/vars", expvar.Handler())
+	a.mux.HandleFunc("GET /log/sampling", a.handleGetLogSampling)
+	a.mux.HandleFunc("PUT /log/sampling", a.handleSetLogSampling)
+	a.mux.HandleFunc("GET /events", a.handleEvents)
+	a.mux.HandleFunc("GET /stats", a.handleStats)
+	return a
+}
+
+// EnableReplication serves the cache over the sidecar protocol under /replication/ to the primaries presenting
+// the token, so they can push the entries they store; without it the endpoint does not exist
+func (a *Admin) EnableReplication(token string) {
+	a.mux.Handle("/replication/", http.StripPrefix("/replication", remote.NewHandler(a.cache, token)))
+}
+
+// ServeHTTP dispatches admin requests to the matching endpoint
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// handleClearCache removes all cached entries
+func (a *Admin) handleClearCache(w http.ResponseWriter, r *http.Request) {
+	if err := a.cache.ClearAll(); err != nil {
+		log.Printf("Error clearing the cache: %s\n", err)
+		http.Error(w, "Failed to clear the cache: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.publishPurge(r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge removes the cached entries of the URL, or of the URLs matching the pattern, given in the url parameter;
+// the tenant parameter limits the purge to the entries of one tenant
+func (a *Admin) handlePurge(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("url")
+	if target == "" {
+		http.Error(w, "url parameter is required", http.StatusBadRequest)
+		return
+	}
+	tenant := r.URL.Query().Get("tenant")
+
+	purged, err := a.proxy.Purge(tenant, target)
+	switch {
+	case errors.Is(err, proxy.ErrPurgeUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.proxy.Publish(proxy.Event{Type: proxy.EventPurge, Method: r.Method, URL: target})
+	log.Printf("Purged %d cached entries of %s through the admin API\n", purged, target)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+// handleCollectGarbage removes the expired entries at once and reports what was reclaimed
+func (a *Admin) handleCollectGarbage(w http.ResponseWriter, _ *http.Request) {
+	collector, ok := a.cache.(cache.Collector)
+	if !ok {
+		http.Error(w, "the cache does not support garbage collection", http.StatusNotImplemented)
+		return
+	}
+	collection := collector.CollectGarbage()
+	log.Printf("Garbage collection through the admin API removed %d entries and %d temporary files, %d bytes\n",
+		collection.Entries, collection.TempFiles, collection.Bytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&collection)
+}
+
+// handleCacheStatus reports whether the proxy caches or passes every request through
+func (a *Admin) handleCacheStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"enabled": !a.proxy.NoCache()})
+}
+
+// handleStats reports the proxy counters, the health of the origin, the cache usage and the most hit URLs,
+// 10 of them unless the top parameter asks for more
+func (a *Admin) handleStats(w http.ResponseWriter, r *http.Request) {
+	top := 10
+	if value := r.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 1000 {
+			http.Error(w, "top must be a number between 0 and 1000", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.Report(top))
+}
+
+// handleSetCaching switches the proxy between caching and pass-through without a restart
+func (a *Admin) handleSetCaching(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		a.proxy.SetNoCache(!enabled)
+		state := "disabled"
+		if enabled {
+			state = "enabled"
+		}
+		log.Printf("Caching %s through the admin API\n", state)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetLogSampling reports how many cache hits are counted per logged one
+func (a *Admin) handleGetLogSampling(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"hits": a.proxy.HitLogSampling()})
+}
+
+// handleSetLogSampling changes how many cache hits are counted per logged one, from the hits query parameter
+func (a *Admin) handleSetLogSampling(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("hits"))
+	if err != nil || n < 1 {
+		http.Error(w, "hits must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	a.proxy.SetHitLogSampling(n)
+	w.WriteHeader(http.StatusNoContent)
+}
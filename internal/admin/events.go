@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+const (
+	eventBuffer       = 256              // Events buffered per stream before newer ones are dropped
+	eventStreamPing   = 15 * time.Second // Interval of the comments keeping idle streams open through proxies
+	eventStreamHeader = "text/event-stream"
+)
+
+// Close ends the event streams, so a graceful shutdown does not wait for their clients to leave
+func (a *Admin) Close() {
+	a.closeOnce.Do(func() { close(a.done) })
+}
+
+// handleEvents streams the cache activity of the proxy as server-sent events, one JSON object per event,
+// optionally limited to the comma-separated types of the types query parameter, e.g. ?types=miss,evict
+func (a *Admin) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream lasts as long as the client stays, whatever the write timeout of the listener
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	events, unsubscribe := a.proxy.Subscribe(eventBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", eventStreamHeader)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.done:
+			return
+		case <-ping.C:
+			_, _ = fmt.Fprint(w, ": ping\n\n")
+		case e := <-events:
+			if types != nil && !slices.Contains(types, e.Type) {
+				continue
+			}
+			data, err := json.Marshal(&e)
+			if err != nil {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// publishPurge reports a purge done through the admin API to the event streams
+func (a *Admin) publishPurge(r *http.Request) {
+	a.proxy.Publish(proxy.Event{Type: proxy.EventPurge, Method: r.Method, URL: r.URL.Path})
+}
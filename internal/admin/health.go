@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout limits how long all readiness checks may take together
+const readinessTimeout = 5 * time.Second
+
+// Check reports whether a dependency of the proxy is usable, returning nil if it is
+type Check func(ctx context.Context) error
+
+// readinessCheck is a named readiness check
+type readinessCheck struct {
+	name  string
+	check Check
+}
+
+// AddReadinessCheck adds a check that must pass for /readyz to report the proxy ready
+func (a *Admin) AddReadinessCheck(name string, check Check) {
+	a.checks = append(a.checks, readinessCheck{name, check})
+}
+
+// handleHealth reports that the process is alive and serving requests
+func (a *Admin) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// handleReady runs the readiness checks, answering 503 if any of them fails, with the result of each one
+func (a *Admin) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make([]string, 0, len(a.checks))
+	for _, c := range a.checks {
+		if err := c.check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results = append(results, fmt.Sprintf("%s: %s", c.name, err))
+			continue
+		}
+		results = append(results, c.name+": ok")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, result := range results {
+		_, _ = fmt.Fprintln(w, result)
+	}
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// persistInterval is how often the report is written to the stats file
+const persistInterval = time.Minute
+
+// persistedURLs is the number of most hit URLs kept in the stats file
+const persistedURLs = 100
+
+// Report is a snapshot of the proxy counters, served by GET /stats and written to the stats file
+type Report struct {
+	Time    time.Time           `json:"time"`              // Time the snapshot was taken
+	Stats   proxy.Stats         `json:"stats"`             // Proxy counters
+	Origin  proxy.OriginHealth  `json:"origin"`            // Health of the origin
+	Cache   *cache.Usage        `json:"cache,omitempty"`   // Entries and bytes stored, if the cache can tell
+	TopURLs []proxy.URLHits     `json:"top_urls"`          // URLs with the most cache hits, the most hit first
+	Tenants []proxy.TenantStats `json:"tenants,omitempty"` // Answer counters by tenant, if tenants are kept apart
+}
+
+// Report returns a snapshot of the proxy counters with up to top most hit URLs
+func (a *Admin) Report(top int) Report {
+	report := Report{
+		Time:    time.Now(),
+		Stats:   a.proxy.Stats(),
+		Origin:  a.proxy.OriginHealth(),
+		TopURLs: a.proxy.TopURLs(top),
+		Tenants: a.proxy.TenantStats(),
+	}
+	if reporter, ok := a.cache.(cache.UsageReporter); ok {
+		usage := reporter.Usage()
+		report.Cache = &usage
+	}
+	return report
+}
+
+// PersistReports writes the report to the file every minute until the context is done,
+// so the counters can still be read once the proxy is stopped
+func (a *Admin) PersistReports(ctx context.Context, path string) {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.WriteReport(path); err != nil {
+				log.Printf("Error writing stats file: %s\n", err)
+			}
+		}
+	}
+}
+
+// WriteReport writes the report to the file, replacing it atomically
+func (a *Admin) WriteReport(path string) error {
+	data, err := json.MarshalIndent(a.Report(persistedURLs), "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -3,20 +3,91 @@ package argparser
 import (
 	"flag"
 	"fmt"
+	"github.com/ig-rudenko/caching-proxy/internal/config"
+	"github.com/ig-rudenko/caching-proxy/internal/metrics"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+	"net"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // ArgParser manages command-line arguments for configuring the caching proxy server
 type ArgParser struct {
-	Host         string        // Host address where the proxy server will listen
-	Port         int           // Port number where the proxy server will listen
-	Origin       *url.URL      // URL of the origin server to which requests will be forwarded
-	UniqueByUser bool          // Whether to generate unique cache keys per user based on User-Agent and cookies
-	CacheTimeout time.Duration // Duration to keep cached responses before they expire
-	ClearCache   bool          // Flag to indicate if the cache should be cleared
-	CacheFolder  string        // Directory to store cached data
+	Host              string            // Host address where the proxy server will listen
+	Port              int               // Port number where the proxy server will listen
+	Origin            *url.URL          // URL of the origin server to which requests will be forwarded
+	UniqueByUser      bool              // Whether to generate unique cache keys per user based on User-Agent and cookies
+	CacheTimeout      time.Duration     // Duration to keep cached responses before they expire
+	ClearCache        bool              // Flag to indicate if the cache should be cleared
+	CacheNamespace    string            // Value mixed into every cache key
+	FoldTrailingSlash bool              // Whether paths with and without a trailing slash share a cache entry
+	TenantByHost      bool              // Whether every request host is a separate cache tenant
+	PathPrefix        string            // Path prefix the proxy is mounted under, replaced before forwarding and keying
+	PathPrefixTarget  string            // Path replacing the prefix, empty to strip it
+	IgnoreQueryParams []string          // Query parameters left out of cache keys but forwarded to the origin
+	CacheFolder       string            // Directory to store cached data
+	CacheSidecar      *url.URL          // Sidecar service storing the cache instead of the cache folder
+	HotKeys           int               // Number of the most requested entries kept in memory
+	PreloadMB         int               // Megabytes of the most recent cache files loaded into memory at startup
+	StreamThresholdMB int               // Megabytes of body above which responses stream through uncached, zero for no limit
+	StreamCache       bool              // Whether responses above the stream threshold are cached from a second fetch
+	TenantQuotaMB     int               // Megabytes every tenant may keep in the cache folder, zero for no limit
+	CacheShared       bool              // Whether several hosts use the cache folder, e.g. over NFS
+	TrustedProxies    []*net.IPNet      // Networks whose forwarding headers are honored
+	HostHeader        string            // Host header sent to the origin: "preserve" or a fixed value
+	RewriteBodyHost   bool              // Whether to replace the origin host in HTML and JSON bodies with the proxy host
+	CacheSetCookie    bool              // Whether to cache responses that set cookies
+	CacheableCookies  []string          // Cookie names that do not prevent a response from being cached
+	NegativeCacheTTL  time.Duration     // Duration to keep cached 404 and 410 responses, zero disables caching them
+	NegativeCache5xx  bool              // Whether 5xx responses are cached with the negative TTL
+	NoCacheClients    []*net.IPNet      // Clients allowed to force a refetch with Cache-Control or Pragma no-cache
+	ServeStaleOnError time.Duration     // How long after expiration a cached copy may be served when the origin fails
+	RevalidateWindow  time.Duration     // How long expired entries are kept to be refreshed with a conditional request
+	MaxRetryAfter     time.Duration     // Longest Retry-After of the origin honored, zero to ignore it
+	SoftTTL           time.Duration     // Age after which cached responses are refreshed in the background
+	EarlyRefresh      float64           // Eagerness of probabilistic refresh before expiry, zero disables it
+	TTLHeader         string            // Origin response header overriding the TTL of that response
+	Offline           bool              // Whether to answer only from the cache without contacting the origin
+	ReadOnlyCache     bool              // Whether to serve existing cache entries without storing new ones
+	RecordReplay      string            // Record/replay mode: "record", "replay" or empty
+	NoCache           bool              // Whether to run as a plain reverse proxy without caching
+	ShadowOrigin      *url.URL          // Secondary origin receiving a copy of a share of requests
+	ShadowPercent     float64           // Percentage of requests mirrored to the shadow origin
+	FallbackOrigin    *url.URL          // Secondary origin used when the primary one fails
+	Peers             []*url.URL        // Proxy URLs of the other instances of the cluster sharing the cache
+	PeerSelf          *url.URL          // Proxy URL of this instance as the peers list it
+	PeersDNS          string            // DNS name the peers are discovered from, SRV records if it starts with "_"
+	PeersDNSInterval  time.Duration     // Interval of the peer lookups
+	Replicas          []*url.URL        // Admin API URLs of the standby instances stored entries are pushed to
+	ReplicationToken  string            // Shared secret authenticating the pushes of a primary to its replicas
+	OriginTimeout     time.Duration     // Time limit for origin requests
+	FollowRedirects   int               // Number of origin redirects followed by the proxy, zero to pass them through
+	RedirectCacheTTL  time.Duration     // Duration to cache permanent redirects passed through, zero to leave them uncached
+	DNSCacheTTL       time.Duration     // How long resolved origin addresses are reused
+	OriginResolve     map[string]string // Fixed origin addresses by "host:port"
+	OriginDNS         []string          // DNS servers resolving the origin hosts, as "ip:port"
+	OriginPool        proxy.OriginPool  // Tuning of the connections to the origin
+	HedgeDelay        time.Duration     // Delay before a hedged origin request is sent, proxy.HedgeAuto for adaptive
+	ShutdownTimeout   time.Duration     // How long in-flight requests and cache writes are awaited on termination
+	PIDFile           string            // File the process ID is written to while the proxy runs
+	LogSampleHits     int               // Log one cache hit in this many
+	LanguageVariants  []string          // Primary languages with their own cache variant
+	GeoIPDatabase     string            // Path to the MaxMind database locating clients
+	GeoVary           bool              // Whether the client country selects a cache variant
+	AlertHitRatio     float64           // Hit ratio percentage below which an alert fires, zero disables it
+	AlertErrorRate    float64           // Origin error rate percentage above which an alert fires, zero disables it
+	AlertWindow       time.Duration     // Period the alert rates are computed over
+	AlertWebhook      *url.URL          // Receives alerts as JSON POST requests
+	MetricsPush       *url.URL          // Endpoint the metrics are pushed to
+	MetricsPushFormat string            // Format of the pushed metrics: "influx" or "openmetrics"
+	MetricsPushEvery  time.Duration     // Interval between metrics pushes
+	AuditLog          string            // File purges, mode toggles and admin API calls are appended to
+	StatsFile         string            // File the counters are persisted to for the stats command
+	ConfigFile        string            // Path to the JSON configuration file
+	Config            *config.Config    // Settings loaded from the configuration file
 }
 
 // New creates a new ArgParser instance
@@ -29,15 +100,112 @@ func (a *ArgParser) Parse() {
 	// Define flags for port, origin, and help
 	var origin string
 	flag.IntVar(&a.Port, "port", 0, "Port on which the caching proxy server will run.")
-	flag.StringVar(&origin, "origin", "", "URL of the server to which the requests will be forwarded.")
+	flag.StringVar(&origin, "origin", "", "URL of the server to which the requests will be forwarded, optionally with a path prefix (e.g., https://example.com/api), or file:///path to serve a local directory.")
 
 	flag.BoolVar(&a.ClearCache, "clear-cache", false, "Clear the cache of the proxy server.")
 
 	flag.StringVar(&a.Host, "host", "0.0.0.0", "Host on which the caching proxy server will run. (default: 0.0.0.0)")
+	flag.BoolVar(&a.FoldTrailingSlash, "fold-trailing-slash", false, "Cache paths with and without a trailing slash as one entry. (default: false)")
+	flag.BoolVar(&a.TenantByHost, "tenant-by-host", false, "Keep the cache entries of every request host apart, purged and counted per tenant. (default: false)")
+	var pathPrefix string
+	flag.StringVar(&pathPrefix, "path-prefix", "", "Path prefix the proxy is mounted under (e.g., /app), stripped from request paths, or remapped with /app=/v2; other paths get 404. (default: none)")
+	var ignoreQueryParams string
+	flag.StringVar(&ignoreQueryParams, "ignore-query-params", "", "Comma-separated query parameters (e.g., utm_*,fbclid) left out of the cache key but forwarded to the origin. (default: none)")
 	flag.BoolVar(&a.UniqueByUser, "unique", false, "Generate unique cache per user (based on User-Agent or cookies). (default: false)")
 	flag.DurationVar(&a.CacheTimeout, "cache-timeout", 0, "Duration to keep cached responses before expiration (e.g., 10s, 5m, 1h). (default: none)")
 
 	flag.StringVar(&a.CacheFolder, "cache-folder", "./cache", "Directory to cache proxy server in. (default: \"./cache\")")
+	flag.StringVar(&a.CacheNamespace, "cache-namespace", "", "Value mixed into every cache key (e.g., v12); changing it invalidates the whole cache. (default: none)")
+
+	var cacheSidecar string
+	flag.StringVar(&cacheSidecar, "cache-sidecar", "", "Base URL of a sidecar service storing the cache instead of the cache folder. (default: none)")
+	flag.IntVar(&a.HotKeys, "hot-keys", 0, "Number of the most requested cache entries kept in memory. (default: 0, disabled)")
+	flag.IntVar(&a.PreloadMB, "preload", 0, "Megabytes of the most recently stored cache files loaded into memory at startup. (default: 0, disabled)")
+	flag.IntVar(&a.StreamThresholdMB, "stream-threshold", 0, "Megabytes of body above which origin responses are streamed to clients without being buffered or cached. (default: 0, no limit)")
+	flag.BoolVar(&a.StreamCache, "stream-cache", false, "Cache responses above --stream-threshold from a second origin fetch written straight to the cache folder. (default: false)")
+	flag.BoolVar(&a.CacheShared, "cache-shared", false, "The cache folder is shared by several hosts, e.g. mounted over NFS: their entries are seen and writes are locked per key. (default: false)")
+	flag.IntVar(&a.TenantQuotaMB, "tenant-quota", 0, "Megabytes every tenant may keep in the cache folder before its own oldest entries are evicted, requires --tenant-by-host. (default: 0, no limit)")
+	flag.StringVar(&a.AuditLog, "audit-log", "", "File every purge, mode toggle and admin API call is appended to as JSON lines. (default: none)")
+	flag.StringVar(&a.StatsFile, "stats-file", "", "File the counters and most hit URLs are written to every minute and on shutdown, read by the stats command. (default: none)")
+	flag.StringVar(&a.ConfigFile, "config", "", "Path to the JSON configuration file. (default: none)")
+
+	flag.StringVar(&a.HostHeader, "host-header", "", "Host header sent to the origin: \"preserve\" keeps the client's Host, any other value overrides it. (default: origin host)")
+	flag.BoolVar(&a.RewriteBodyHost, "rewrite-body-host", false, "Replace origin host links in HTML and JSON responses with the proxy host. (default: false)")
+	flag.BoolVar(&a.CacheSetCookie, "cache-set-cookie", false, "Cache responses containing Set-Cookie. (default: false)")
+
+	var cacheableCookies string
+	flag.StringVar(&cacheableCookies, "cacheable-cookies", "", "Comma-separated cookie names that do not prevent caching. (default: none)")
+	flag.DurationVar(&a.NegativeCacheTTL, "negative-cache-ttl", 0, "Duration to cache 404 and 410 responses (e.g., 10s, 1m). (default: not cached)")
+	flag.BoolVar(&a.NegativeCache5xx, "negative-cache-5xx", false, "Also cache 5xx responses for the negative cache TTL. (default: false)")
+	flag.DurationVar(&a.RevalidateWindow, "revalidate-window", 0, "Keep expired entries this long, so those with an ETag or Last-Modified are refreshed with a conditional request instead of refetched (e.g., 1h). (default: until the next cleanup)")
+	flag.DurationVar(&a.MaxRetryAfter, "max-retry-after", proxy.DefaultMaxRetryAfter, "Longest Retry-After of a 429 or 503 origin response honored: the route is not sent to the origin until then, but answered stale or with the error; 0 ignores Retry-After. (default: 5m)")
+	flag.DurationVar(&a.ServeStaleOnError, "serve-stale-on-error", 0, "Serve expired cached copies up to this long after expiration when the origin fails (e.g., 1h). (default: none)")
+	flag.DurationVar(&a.SoftTTL, "soft-ttl", 0, "Age after which cached responses are served but refreshed in the background (e.g., 30s). (default: none)")
+	flag.Float64Var(&a.EarlyRefresh, "early-refresh", 0, "Eagerness of probabilistic background refresh shortly before expiry, 1 is typical. (default: 0, disabled)")
+	flag.StringVar(&a.TTLHeader, "ttl-header", "X-Proxy-Cache-TTL", "Origin response header overriding the TTL of that response, in seconds; empty disables it. (default: X-Proxy-Cache-TTL)")
+	flag.BoolVar(&a.Offline, "offline", false, "Answer only from the cache, never contacting the origin; missing entries get 404. (default: false)")
+	flag.BoolVar(&a.ReadOnlyCache, "read-only-cache", false, "Serve existing cache entries but never write or remove any. (default: false)")
+	record := flag.Bool("record", false, "Record every origin interaction, keyed by method, URL and body, as a test fixture. (default: false)")
+	replay := flag.Bool("replay", false, "Serve only recorded fixtures, never contacting the origin. (default: false)")
+	flag.BoolVar(&a.NoCache, "no-cache", false, "Run as a plain reverse proxy without any cache reads or writes. (default: false)")
+	var fallbackOrigin string
+	flag.StringVar(&fallbackOrigin, "fallback-origin", "", "URL of a secondary origin used when the primary one fails or times out. (default: none)")
+	flag.DurationVar(&a.OriginTimeout, "origin-timeout", 0, "Time limit for origin requests (e.g., 10s). (default: none)")
+	flag.DurationVar(&a.RedirectCacheTTL, "redirect-cache-ttl", 0, "Duration to cache 301 and 308 redirects passed through from the origin (e.g., 1h), replaying Location through the proxy. (default: not cached)")
+	flag.IntVar(&a.FollowRedirects, "follow-redirects", proxy.DefaultFollowRedirects, "Number of origin redirects followed, caching the final response under the requested URL; 0 passes redirects to clients untouched. (default: 10)")
+	flag.IntVar(&a.LogSampleHits, "log-sample-hits", 1, "Log only one cache hit in this many; misses and errors are always logged. (default: 1, every hit)")
+	flag.StringVar(&a.PIDFile, "pidfile", "", "File the process ID is written to while the proxy runs, for init scripts. (default: none)")
+	flag.DurationVar(&a.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests and cache writes are awaited on SIGTERM or SIGINT. (default: 30s)")
+	flag.DurationVar(&a.DNSCacheTTL, "dns-cache-ttl", 0, "How long resolved origin addresses are reused (e.g., 1m). (default: none)")
+
+	var originResolve string
+	flag.StringVar(&originResolve, "origin-resolve", "", "Comma-separated host:port:address entries pinning origin hosts to fixed addresses. (default: none)")
+	flag.IntVar(&a.OriginPool.MaxIdleConns, "origin-max-idle-conns", 0, "Idle connections kept open to the origin. (default: 100 in total, 2 per host)")
+	flag.IntVar(&a.OriginPool.MaxConnsPerHost, "origin-max-conns-per-host", 0, "Maximum connections to an origin host, requests beyond it wait. (default: no limit)")
+	flag.DurationVar(&a.OriginPool.IdleTimeout, "origin-idle-timeout", 0, "How long an idle origin connection is kept open (e.g., 30s). (default: 90s)")
+	flag.BoolVar(&a.OriginPool.DisableCompression, "origin-disable-compression", false, "Do not ask the origin for gzip on the wire for clients accepting no coding. (default: false)")
+	var originDNS string
+	flag.StringVar(&originDNS, "origin-dns", "", "Comma-separated DNS servers (e.g., 10.0.0.53,10.0.1.53:5353) resolving origin hosts instead of the system resolver, tried in order. (default: none)")
+
+	var hedgeDelay string
+	flag.StringVar(&hedgeDelay, "hedge-delay", "", "Send a second identical GET/HEAD to the origin if it has not answered within this delay, or \"auto\" for its p95 latency. (default: none)")
+
+	var shadowOrigin string
+	flag.StringVar(&shadowOrigin, "shadow-origin", "", "URL of a secondary origin receiving a copy of requests; its responses are discarded. (default: none)")
+	flag.Float64Var(&a.ShadowPercent, "shadow-percent", 100, "Percentage of requests mirrored to the shadow origin. (default: 100)")
+
+	var peers, peerSelf string
+	flag.StringVar(&peers, "peers", "", "Comma-separated proxy URLs of the other instances of a cluster; misses are filled from the instance owning the key by consistent hashing. (default: none)")
+	flag.StringVar(&peerSelf, "peer-self", "", "Proxy URL of this instance as the peers list it, required with --peers. (default: none)")
+	flag.StringVar(&a.PeersDNS, "peers-dns", "", "DNS name the cluster peers are discovered from instead of --peers: SRV records if it starts with _, otherwise the addresses of a name such as a headless service, optionally with :port. (default: none)")
+	flag.DurationVar(&a.PeersDNSInterval, "peers-dns-interval", 30*time.Second, "Interval of the peer lookups of --peers-dns. (default: 30s)")
+
+	var replicas string
+	flag.StringVar(&replicas, "replicas", "", "Comma-separated admin API URLs of standby instances every newly cached response is pushed to, requires --replication-token. (default: none)")
+	flag.StringVar(&a.ReplicationToken, "replication-token", "", "Shared secret a primary presents to push entries to its replicas; on a replica it enables /replication/ on the admin API. (default: none)")
+
+	var languageVariants string
+	flag.StringVar(&languageVariants, "vary-language", "", "Comma-separated primary languages (e.g., en,de) cached separately by Accept-Language. (default: none)")
+
+	flag.StringVar(&a.GeoIPDatabase, "geoip-db", "", "Path to a MaxMind .mmdb database; the client country and region are sent to the origin as X-Geo-Country and X-Geo-Region. (default: none)")
+	flag.BoolVar(&a.GeoVary, "geo-vary", false, "Cache responses separately per client country, requires --geoip-db. (default: false)")
+
+	var noCacheClients string
+	flag.StringVar(&noCacheClients, "no-cache-clients", "", "Comma-separated IPs or CIDRs, or \"all\", allowed to force a refetch with Cache-Control: no-cache. (default: none)")
+
+	var trustedProxies string
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Comma-separated IPs or CIDRs whose forwarding headers are honored. (default: none)")
+
+	flag.Float64Var(&a.AlertHitRatio, "alert-hit-ratio", 0, "Alert when the hit ratio over the alert window drops below this percentage. (default: 0, disabled)")
+	flag.Float64Var(&a.AlertErrorRate, "alert-error-rate", 0, "Alert when the share of failed origin requests over the alert window exceeds this percentage. (default: 0, disabled)")
+	flag.DurationVar(&a.AlertWindow, "alert-window", 5*time.Minute, "Period the alert rates are computed over. (default: 5m)")
+	var alertWebhook string
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "URL receiving alerts as JSON POST requests, in addition to the log. (default: none)")
+
+	var metricsPush string
+	flag.StringVar(&metricsPush, "metrics-push", "", "URL the metrics are periodically POSTed to, e.g. an InfluxDB write endpoint or a Pushgateway. (default: none)")
+	flag.StringVar(&a.MetricsPushFormat, "metrics-push-format", "influx", "Format of the pushed metrics: influx (line protocol) or openmetrics. (default: influx)")
+	flag.DurationVar(&a.MetricsPushEvery, "metrics-push-interval", 10*time.Second, "Interval between metrics pushes. (default: 10s)")
 
 	// Define flags for displaying help
 	help := flag.Bool("help", false, "Show help message.")
@@ -46,6 +214,8 @@ func (a *ArgParser) Parse() {
 	// Parse command-line arguments
 	flag.Parse()
 
+	// An empty configuration stands in until the file is loaded, so it is never nil, even when clearing the cache
+	a.Config = &config.Config{}
 	if a.ClearCache {
 		// If --clear-cache flag is set, exit after clearing the cache
 		return
@@ -57,45 +227,414 @@ func (a *ArgParser) Parse() {
 		os.Exit(0)
 	}
 
-	// Validate required arguments
-	if a.Port == 0 || origin == "" {
+	// Record and replay are mutually exclusive
+	if *record && *replay {
+		fmt.Println("Error: --record and --replay cannot be used together.")
+		printUsage()
+		os.Exit(1)
+	}
+	if *record {
+		a.RecordReplay = proxy.ModeRecord
+	} else if *replay {
+		a.RecordReplay = proxy.ModeReplay
+	}
+
+	// Load the configuration file, or keep the empty configuration
+	if a.ConfigFile != "" {
+		cfg, err := config.Load(a.ConfigFile)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		a.Config = cfg
+	}
+
+	// Validate required arguments, the port is optional when listeners are configured
+	if (a.Port == 0 && len(a.Config.Listeners) == 0) || origin == "" {
 		fmt.Println("Error: Missing required arguments.")
 		printUsage()
 		os.Exit(1)
 	}
 
 	// Validate port number
-	if !isValidPort(&a.Port) {
+	if a.Port != 0 && !isValidPort(&a.Port) {
 		fmt.Printf("Error: Invalid port number %d. Port must be between 1 and 65535.\n", a.Port)
 		printUsage()
 		os.Exit(1)
 	}
 
-	// Validate origin URL
-	validOriginURL, ok := getValidOriginURL(&origin)
-	if !ok {
-		fmt.Printf("Error: Invalid origin URL '%s'. Only protocol (http, https) and domain are allowed, no path, query, or fragment.\n", origin)
+	// Validate origin URL, a file:// origin must point to an existing directory
+	var validOriginURL *url.URL
+	var ok bool
+	if strings.HasPrefix(origin, "file://") {
+		validOriginURL, ok = getValidFileOriginURL(&origin)
+		if !ok {
+			fmt.Printf("Error: Invalid origin URL '%s'. A file origin must point to an existing directory.\n", origin)
+			printUsage()
+			os.Exit(1)
+		}
+	} else if validOriginURL, ok = getValidOriginURL(&origin); !ok {
+		fmt.Printf("Error: Invalid origin URL '%s'. Only http or https URLs with an optional path prefix are allowed, no query or fragment.\n", origin)
 		printUsage()
 		os.Exit(1)
 	}
 
 	// Set the validated origin URL
 	a.Origin = validOriginURL
+
+	// Validate the path prefix and its optional replacement
+	if pathPrefix != "" {
+		prefix, target, _ := strings.Cut(pathPrefix, "=")
+		if !isValidPathPrefix(prefix) || target != "" && !isValidPathPrefix(target) {
+			fmt.Printf("Error: Invalid path prefix '%s'. Use /prefix to strip it or /prefix=/target to remap it.\n", pathPrefix)
+			printUsage()
+			os.Exit(1)
+		}
+		a.PathPrefix, a.PathPrefixTarget = prefix, target
+	}
+
+	a.CacheableCookies = SplitList(cacheableCookies)
+	a.LanguageVariants = SplitList(languageVariants)
+	a.IgnoreQueryParams = SplitList(ignoreQueryParams)
+
+	// Validate the cache sidecar URL, which may have a path
+	if cacheSidecar != "" {
+		sidecarURL, err := url.Parse(cacheSidecar)
+		if err != nil || (sidecarURL.Scheme != "http" && sidecarURL.Scheme != "https") || sidecarURL.Host == "" {
+			fmt.Printf("Error: Invalid cache sidecar URL '%s'.\n", cacheSidecar)
+			printUsage()
+			os.Exit(1)
+		}
+		a.CacheSidecar = sidecarURL
+	}
+
+	// Validate the fallback origin URL
+	if fallbackOrigin != "" {
+		validFallbackURL, ok := getValidOriginURL(&fallbackOrigin)
+		if !ok {
+			fmt.Printf("Error: Invalid fallback origin URL '%s'.\n", fallbackOrigin)
+			printUsage()
+			os.Exit(1)
+		}
+		a.FallbackOrigin = validFallbackURL
+	}
+
+	// Validate the hedging delay
+	switch hedgeDelay {
+	case "":
+	case "auto":
+		a.HedgeDelay = proxy.HedgeAuto
+	default:
+		delay, err := time.ParseDuration(hedgeDelay)
+		if err != nil || delay <= 0 {
+			fmt.Printf("Error: Invalid hedge delay '%s'. Use a positive duration or \"auto\".\n", hedgeDelay)
+			printUsage()
+			os.Exit(1)
+		}
+		a.HedgeDelay = delay
+	}
+
+	// Validate the shadow origin URL and percentage
+	if shadowOrigin != "" {
+		validShadowURL, ok := getValidOriginURL(&shadowOrigin)
+		if !ok {
+			fmt.Printf("Error: Invalid shadow origin URL '%s'.\n", shadowOrigin)
+			printUsage()
+			os.Exit(1)
+		}
+		a.ShadowOrigin = validShadowURL
+	}
+	if a.ShadowPercent < 0 || a.ShadowPercent > 100 {
+		fmt.Printf("Error: Invalid shadow percentage %g. It must be between 0 and 100.\n", a.ShadowPercent)
+		printUsage()
+		os.Exit(1)
+	}
+
+	// Validate the cluster peers
+	for _, peer := range SplitList(peers) {
+		peerURL, ok := getValidOriginURL(&peer)
+		if !ok {
+			fmt.Printf("Error: Invalid peer URL '%s'.\n", peer)
+			printUsage()
+			os.Exit(1)
+		}
+		a.Peers = append(a.Peers, peerURL)
+	}
+	if len(a.Peers) > 0 && a.PeersDNS != "" {
+		fmt.Printf("Error: --peers and --peers-dns cannot be used together.\n")
+		printUsage()
+		os.Exit(1)
+	}
+	if a.PeersDNS != "" && a.PeersDNSInterval <= 0 {
+		fmt.Printf("Error: Invalid peer lookup interval %s. It must be positive.\n", a.PeersDNSInterval)
+		printUsage()
+		os.Exit(1)
+	}
+	if len(a.Peers) > 0 || a.PeersDNS != "" {
+		selfURL, ok := getValidOriginURL(&peerSelf)
+		if !ok {
+			fmt.Printf("Error: Invalid or missing --peer-self URL '%s'.\n", peerSelf)
+			printUsage()
+			os.Exit(1)
+		}
+		a.PeerSelf = selfURL
+	}
+
+	// Validate the replicas
+	for _, replica := range SplitList(replicas) {
+		replicaURL, ok := getValidOriginURL(&replica)
+		if !ok {
+			fmt.Printf("Error: Invalid replica URL '%s'.\n", replica)
+			printUsage()
+			os.Exit(1)
+		}
+		a.Replicas = append(a.Replicas, replicaURL)
+	}
+	if len(a.Replicas) > 0 && a.ReplicationToken == "" {
+		fmt.Println("Error: --replicas requires --replication-token, the replicas only accept authenticated pushes.")
+		printUsage()
+		os.Exit(1)
+	}
+
+	if a.EarlyRefresh < 0 {
+		fmt.Printf("Error: Invalid early refresh factor %g. It must not be negative.\n", a.EarlyRefresh)
+		printUsage()
+		os.Exit(1)
+	}
+	if a.OriginPool.MaxIdleConns < 0 || a.OriginPool.MaxConnsPerHost < 0 || a.OriginPool.IdleTimeout < 0 {
+		fmt.Println("Error: Invalid origin connection limits. They must not be negative.")
+		printUsage()
+		os.Exit(1)
+	}
+	if a.FollowRedirects < 0 {
+		fmt.Printf("Error: Invalid redirect count %d. It must not be negative.\n", a.FollowRedirects)
+		printUsage()
+		os.Exit(1)
+	}
+	if a.HotKeys < 0 {
+		fmt.Printf("Error: Invalid hot keys count %d. It must not be negative.\n", a.HotKeys)
+		printUsage()
+		os.Exit(1)
+	}
+	if a.CacheShared && a.PreloadMB > 0 {
+		fmt.Println("Error: --preload cannot be used with --cache-shared, the other hosts may replace the preloaded entries.")
+		printUsage()
+		os.Exit(1)
+	}
+	if a.TenantQuotaMB < 0 {
+		fmt.Printf("Error: Invalid tenant quota %d. It must not be negative.\n", a.TenantQuotaMB)
+		printUsage()
+		os.Exit(1)
+	}
+	if (a.TenantQuotaMB > 0 || len(a.Config.TenantQuotas) > 0) && !a.TenantByHost {
+		fmt.Println("Error: tenant quotas require --tenant-by-host.")
+		printUsage()
+		os.Exit(1)
+	}
+	if a.GeoVary && a.GeoIPDatabase == "" {
+		fmt.Println("Error: --geo-vary requires --geoip-db.")
+		printUsage()
+		os.Exit(1)
+	}
+
+	// Validate the alert thresholds and webhook
+	if a.AlertHitRatio < 0 || a.AlertHitRatio > 100 || a.AlertErrorRate < 0 || a.AlertErrorRate > 100 {
+		fmt.Println("Error: Invalid alert threshold. It must be a percentage between 0 and 100.")
+		printUsage()
+		os.Exit(1)
+	}
+	if a.AlertWindow <= 0 {
+		fmt.Printf("Error: Invalid alert window %s. It must be positive.\n", a.AlertWindow)
+		printUsage()
+		os.Exit(1)
+	}
+	if alertWebhook != "" {
+		webhookURL, err := url.Parse(alertWebhook)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+			fmt.Printf("Error: Invalid alert webhook URL '%s'.\n", alertWebhook)
+			printUsage()
+			os.Exit(1)
+		}
+		if a.AlertHitRatio == 0 && a.AlertErrorRate == 0 {
+			fmt.Println("Error: --alert-webhook requires --alert-hit-ratio or --alert-error-rate.")
+			printUsage()
+			os.Exit(1)
+		}
+		a.AlertWebhook = webhookURL
+	}
+
+	// Validate the metrics push endpoint, format and interval
+	if metricsPush != "" {
+		pushURL, err := url.Parse(metricsPush)
+		if err != nil || (pushURL.Scheme != "http" && pushURL.Scheme != "https") || pushURL.Host == "" {
+			fmt.Printf("Error: Invalid metrics push URL '%s'.\n", metricsPush)
+			printUsage()
+			os.Exit(1)
+		}
+		a.MetricsPush = pushURL
+	}
+	if a.MetricsPushFormat != metrics.FormatInflux && a.MetricsPushFormat != metrics.FormatOpenMetrics {
+		fmt.Printf("Error: Invalid metrics push format '%s'. Use \"influx\" or \"openmetrics\".\n", a.MetricsPushFormat)
+		printUsage()
+		os.Exit(1)
+	}
+	if a.MetricsPushEvery <= 0 {
+		fmt.Printf("Error: Invalid metrics push interval %s. It must be positive.\n", a.MetricsPushEvery)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if a.LogSampleHits < 1 {
+		fmt.Printf("Error: Invalid hit log sampling %d. It must be at least 1.\n", a.LogSampleHits)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if a.PreloadMB < 0 {
+		fmt.Printf("Error: Invalid preload size %d. It must not be negative.\n", a.PreloadMB)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if a.StreamThresholdMB < 0 {
+		fmt.Printf("Error: Invalid stream threshold %d. It must not be negative.\n", a.StreamThresholdMB)
+		printUsage()
+		os.Exit(1)
+	}
+	if a.StreamCache && a.StreamThresholdMB == 0 {
+		fmt.Println("Error: --stream-cache requires --stream-threshold.")
+		printUsage()
+		os.Exit(1)
+	}
+
+	// Validate the fixed origin addresses
+	resolve, err := parseResolve(originResolve)
+	if err != nil {
+		fmt.Printf("Error: Invalid origin resolve entry: %s.\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	a.OriginResolve = resolve
+
+	// Validate the origin DNS servers
+	if a.OriginDNS, err = parseDNSServers(originDNS); err != nil {
+		fmt.Printf("Error: Invalid origin DNS server: %s.\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	// Validate trusted proxy networks
+	networks, err := parseNetworks(trustedProxies)
+	if err != nil {
+		fmt.Printf("Error: Invalid trusted proxy: %s\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	a.TrustedProxies = networks
+
+	// Validate the clients allowed to bypass the cache, "all" allows any IPv4 or IPv6 address
+	if noCacheClients == "all" {
+		noCacheClients = "0.0.0.0/0,::/0"
+	}
+	networks, err = parseNetworks(noCacheClients)
+	if err != nil {
+		fmt.Printf("Error: Invalid no-cache client: %s\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	a.NoCacheClients = networks
 }
 
 // printUsage displays the usage instructions for the command-line arguments
 func printUsage() {
 	fmt.Println(`Usage: caching-proxy --port <number> --origin <url> [options]
+       caching-proxy service install|uninstall|start|stop [options]   (Windows only)
+       caching-proxy bench --target <url> [options]
+       caching-proxy gc (--admin <url> | --cache-folder <path>) [options]
+       caching-proxy inspect [options] <url>
+       caching-proxy ls [options]
+       caching-proxy purge --admin <url> [options] <url or pattern>
+       caching-proxy stats [--admin <url>] [--stats-file <path>] [options]
+       caching-proxy top --admin <url> [options]
+       caching-proxy validate-config -c <path> [--probe [--origin <url>]]
+       caching-proxy warm --urls <file> (--proxy <url> | --origin <url>) [options]
 
 Required:
-  --port <number>          Port on which the caching proxy server will run.
-  --origin <url>           URL of the server to which the requests will be forwarded.
+  --port <number>          Port on which the caching proxy server will run (optional when listeners are configured).
+  --origin <url>           URL of the server to which the requests will be forwarded, optionally with a path prefix (e.g., https://example.com/api), or file:///path to serve a local directory.
 
 Options:
   --host <string>          Host on which the caching proxy server will run. (default: 0.0.0.0)
+  --fold-trailing-slash    Cache paths with and without a trailing slash as one entry. (default: false)
+  --tenant-by-host         Keep the cache entries of every request host apart, purged and counted per tenant. (default: false)
+  --path-prefix <path>     Path prefix the proxy is mounted under (e.g., /app), stripped from request paths, or remapped with /app=/v2; other paths get 404. (default: none)
+  --ignore-query-params <list> Comma-separated query parameters (e.g., utm_*,fbclid) left out of the cache key but forwarded to the origin. (default: none)
   --unique                 Generate unique cache per user (based on User-Agent or cookies). (default: false)
+  --vary-language <list>   Comma-separated primary languages (e.g., en,de) cached separately by Accept-Language. (default: none)
+  --geoip-db <path>        Path to a MaxMind .mmdb database; the client country and region are sent to the origin as X-Geo-Country and X-Geo-Region. (default: none)
+  --geo-vary               Cache responses separately per client country, requires --geoip-db. (default: false)
   --cache-timeout <time>   Duration to keep cached responses before expiration (e.g., 10s, 5m, 1h). (default: none)
   --cache-folder <string>  Directory to cache proxy server in. (default: "./cache")
+  --cache-namespace <str>  Value mixed into every cache key (e.g., v12); changing it invalidates the whole cache. (default: none)
+  --cache-sidecar <url>    Base URL of a sidecar service storing the cache instead of the cache folder. (default: none)
+  --hot-keys <int>         Number of the most requested cache entries kept in memory. (default: 0, disabled)
+  --preload <int>          Megabytes of the most recently stored cache files loaded into memory at startup. (default: 0, disabled)
+  --stream-threshold <int> Megabytes of body above which origin responses are streamed to clients without being buffered or cached. (default: 0, no limit)
+  --stream-cache           Cache responses above --stream-threshold from a second origin fetch written straight to the cache folder. (default: false)
+  --cache-shared           The cache folder is shared by several hosts, e.g. mounted over NFS: their entries are seen and writes are locked per key. (default: false)
+  --tenant-quota <int>     Megabytes every tenant may keep in the cache folder before its own oldest entries are evicted, requires --tenant-by-host. (default: 0, no limit)
+  --trusted-proxies <list> Comma-separated IPs or CIDRs whose forwarding headers are honored. (default: none)
+  --host-header <string>   Host header sent to the origin: "preserve" keeps the client's Host, any other value overrides it. (default: origin host)
+  --rewrite-body-host      Replace origin host links in HTML and JSON responses with the proxy host. (default: false)
+  --cache-set-cookie       Cache responses containing Set-Cookie. (default: false)
+  --cacheable-cookies <list> Comma-separated cookie names that do not prevent caching. (default: none)
+  --negative-cache-ttl <time> Duration to cache 404 and 410 responses (e.g., 10s, 1m). (default: not cached)
+  --negative-cache-5xx     Also cache 5xx responses for the negative cache TTL. (default: false)
+  --no-cache-clients <list> Comma-separated IPs or CIDRs, or "all", allowed to force a refetch with Cache-Control: no-cache. (default: none)
+  --serve-stale-on-error <time> Serve expired cached copies up to this long after expiration when the origin fails (e.g., 1h). (default: none)
+  --max-retry-after <time> Longest Retry-After of a 429 or 503 origin response honored: the route is not sent to the origin until then, but answered stale or with the error; 0 ignores Retry-After. (default: 5m)
+  --revalidate-window <time> Keep expired entries this long, so those with an ETag or Last-Modified are refreshed with a conditional request instead of refetched (e.g., 1h). (default: until the next cleanup)
+  --soft-ttl <time>        Age after which cached responses are served but refreshed in the background (e.g., 30s). (default: none)
+  --early-refresh <float>  Eagerness of probabilistic background refresh shortly before expiry, 1 is typical. (default: 0, disabled)
+  --ttl-header <string>    Origin response header overriding the TTL of that response, in seconds; empty disables it. (default: X-Proxy-Cache-TTL)
+  --offline                Answer only from the cache, never contacting the origin; missing entries get 404. (default: false)
+  --read-only-cache        Serve existing cache entries but never write or remove any. (default: false)
+  --record                 Record every origin interaction, keyed by method, URL and body, as a test fixture. (default: false)
+  --replay                 Serve only recorded fixtures, never contacting the origin. (default: false)
+  --no-cache               Run as a plain reverse proxy without any cache reads or writes. (default: false)
+  --fallback-origin <url>  URL of a secondary origin used when the primary one fails or times out. (default: none)
+  --origin-timeout <time>  Time limit for origin requests (e.g., 10s). (default: none)
+  --follow-redirects <n>   Number of origin redirects followed, caching the final response under the requested URL; 0 passes redirects to clients untouched. (default: 10)
+  --redirect-cache-ttl <time> Duration to cache 301 and 308 redirects passed through from the origin (e.g., 1h), replaying Location through the proxy. (default: not cached)
+  --dns-cache-ttl <time>   How long resolved origin addresses are reused (e.g., 1m). (default: none)
+  --origin-resolve <list>  Comma-separated host:port:address entries pinning origin hosts to fixed addresses. (default: none)
+  --origin-max-idle-conns <n> Idle connections kept open to the origin. (default: 100 in total, 2 per host)
+  --origin-max-conns-per-host <n> Maximum connections to an origin host, requests beyond it wait. (default: no limit)
+  --origin-idle-timeout <time> How long an idle origin connection is kept open (e.g., 30s). (default: 90s)
+  --origin-disable-compression Do not ask the origin for gzip on the wire for clients accepting no coding. (default: false)
+  --origin-dns <list>      Comma-separated DNS servers (e.g., 10.0.0.53,10.0.1.53:5353) resolving origin hosts instead of the system resolver, tried in order. (default: none)
+  --hedge-delay <time>     Send a second identical GET/HEAD to the origin if it has not answered within this delay, or "auto" for its p95 latency. (default: none)
+  --shadow-origin <url>    URL of a secondary origin receiving a copy of requests; its responses are discarded. (default: none)
+  --shadow-percent <number> Percentage of requests mirrored to the shadow origin. (default: 100)
+  --peers <list>           Comma-separated proxy URLs of the other instances of a cluster; misses are filled from the instance owning the key by consistent hashing. (default: none)
+  --peer-self <url>        Proxy URL of this instance as the peers list it, required with --peers. (default: none)
+  --peers-dns <name>       DNS name the cluster peers are discovered from instead of --peers: SRV records if it starts with _, otherwise the addresses of a name such as a headless service, optionally with :port. (default: none)
+  --peers-dns-interval <time> Interval of the peer lookups of --peers-dns. (default: 30s)
+  --replicas <list>        Comma-separated admin API URLs of standby instances every newly cached response is pushed to, requires --replication-token. (default: none)
+  --replication-token <secret> Shared secret a primary presents to push entries to its replicas; on a replica it enables /replication/ on the admin API. (default: none)
+  --shutdown-timeout <time> How long in-flight requests and cache writes are awaited on SIGTERM or SIGINT. (default: 30s)
+  --pidfile <path>         File the process ID is written to while the proxy runs, for init scripts. (default: none)
+  --log-sample-hits <int>  Log only one cache hit in this many; misses and errors are always logged. (default: 1, every hit)
+  --alert-hit-ratio <pct>  Alert when the hit ratio over the alert window drops below this percentage. (default: 0, disabled)
+  --alert-error-rate <pct> Alert when the share of failed origin requests over the alert window exceeds this percentage. (default: 0, disabled)
+  --alert-window <time>    Period the alert rates are computed over. (default: 5m)
+  --alert-webhook <url>    URL receiving alerts as JSON POST requests, in addition to the log. (default: none)
+  --metrics-push <url>     URL the metrics are periodically POSTed to, e.g. an InfluxDB write endpoint or a Pushgateway. (default: none)
+  --metrics-push-format <format> Format of the pushed metrics: influx (line protocol) or openmetrics. (default: influx)
+  --metrics-push-interval <time> Interval between metrics pushes. (default: 10s)
+  --audit-log <path>        File every purge, mode toggle and admin API call is appended to as JSON lines. (default: none)
+  --stats-file <path>      File the counters and most hit URLs are written to every minute and on shutdown, read by the stats command. (default: none)
+  --config <path>          Path to the JSON configuration file. (default: none)
   --clear-cache            Clear the cache of the proxy server and exit.
   -h, --help               Show this help message.`)
 }
@@ -105,21 +644,129 @@ func isValidPort(port *int) bool {
 	return *port > 0 && *port <= 65535
 }
 
-// getValidOriginURL validates that the origin URL consists only of protocol and domain, without path, query, or fragment
+// isValidPathPrefix checks that a path prefix is an absolute path other than the root, without a query or fragment
+func isValidPathPrefix(prefix string) bool {
+	return strings.HasPrefix(prefix, "/") && strings.Trim(prefix, "/") != "" && !strings.ContainsAny(prefix, "?#")
+}
+
+// getValidOriginURL validates that the origin URL has an http or https scheme and a host, optionally followed by
+// a path prefix, without query or fragment; a trailing slash of the prefix is dropped
 func getValidOriginURL(origin *string) (*url.URL, bool) {
 	// Parse the origin URL
 	parsedURL, err := url.ParseRequestURI(*origin)
-	if err != nil {
+	if err != nil || strings.Contains(*origin, "#") {
 		return nil, false
 	}
 
-	// Ensure the URL has a valid scheme (http or https), a host, and no path, query, or fragment
+	// Ensure the URL has a valid scheme (http or https), a host, and no query or fragment;
+	// a path is the prefix request paths are appended to
 	if parsedURL.Scheme == "" || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
 		return nil, false
 	}
-	if parsedURL.Host == "" || parsedURL.Path != "" || parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+	if parsedURL.Host == "" || parsedURL.RawQuery != "" || parsedURL.ForceQuery || parsedURL.Fragment != "" {
 		return nil, false
 	}
 
+	// A trailing slash is dropped, request paths bringing their own
+	if trimmed := strings.TrimRight(parsedURL.Path, "/"); trimmed != parsedURL.Path {
+		raw := strings.TrimRight(parsedURL.EscapedPath(), "/")
+		parsedURL.Path, parsedURL.RawPath = trimmed, ""
+		if raw != trimmed {
+			parsedURL.RawPath = raw
+		}
+	}
 	return parsedURL, true
 }
+
+// getValidFileOriginURL validates that a file:// origin URL points to an existing local directory
+func getValidFileOriginURL(origin *string) (*url.URL, bool) {
+	parsedURL, err := url.Parse(*origin)
+	if err != nil || parsedURL.Host != "" || parsedURL.Path == "" || parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return nil, false
+	}
+
+	info, err := os.Stat(parsedURL.Path)
+	if err != nil || !info.IsDir() {
+		return nil, false
+	}
+	return parsedURL, true
+}
+
+// parseNetworks parses a comma-separated list of IP addresses and CIDR networks
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range SplitList(list) {
+		// A single IP address is treated as a network of one host
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("'%s' is not an IP address", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a CIDR network", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseResolve parses comma-separated host:port:address entries into fixed addresses by "host:port"
+func parseResolve(list string) (map[string]string, error) {
+	resolve := make(map[string]string)
+	for _, item := range SplitList(list) {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("'%s' is not in host:port:address form", item)
+		}
+		if _, err := strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("'%s' has an invalid port", item)
+		}
+		// IPv6 addresses may be written in brackets
+		address := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("'%s' is not an IP address", parts[2])
+		}
+		resolve[net.JoinHostPort(parts[0], parts[1])] = address
+	}
+	return resolve, nil
+}
+
+// parseDNSServers parses a comma-separated list of DNS server addresses, given as "ip" or "ip:port",
+// into "ip:port" form with the default DNS port
+func parseDNSServers(list string) ([]string, error) {
+	var servers []string
+	for _, item := range SplitList(list) {
+		host, port, err := net.SplitHostPort(item)
+		if err != nil {
+			host, port = strings.TrimSuffix(strings.TrimPrefix(item, "["), "]"), "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("'%s' is not an IP address", item)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("'%s' has an invalid port", item)
+		}
+		servers = append(servers, net.JoinHostPort(host, port))
+	}
+	return servers, nil
+}
+
+// SplitList splits a comma-separated list, trimming spaces and skipping empty items
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
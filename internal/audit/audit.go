@@ -0,0 +1,106 @@
+// Package audit records administrative operations in an append-only log.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/middleware"
+)
+
+// Sources of audited operations that are not admin API calls
+const (
+	SourceCLI    = "cli"    // Command-line flags
+	SourceSignal = "signal" // Operator signals such as SIGUSR2
+)
+
+// ActorUnverified is the actor of admin API calls: the admin API does not authenticate its callers,
+// so who made a call is only known by its client address
+const ActorUnverified = "unverified"
+
+// Event is a single audited operation: who did what, when and from where
+type Event struct {
+	Time    time.Time `json:"time"`              // When the operation was done
+	Actor   string    `json:"actor"`             // Who: ActorUnverified for an admin API call, or "operator"
+	Claimed string    `json:"claimed,omitempty"` // Basic auth user an admin API call claimed to be, never checked
+	Source  string    `json:"source"`            // From where: the client address of an admin API call, SourceCLI or SourceSignal
+	Action  string    `json:"action"`            // What, e.g. "POST /cache/clear" or "cache disabled"
+	Status  int       `json:"status,omitempty"`  // Response status of an admin API call
+}
+
+// Log appends events to a file as JSON lines, syncing each one to disk before going on.
+// A nil Log records nothing, so callers do not have to check whether auditing is enabled.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the audit log at the path for appending, creating it readable by the owner only
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: file}, nil
+}
+
+// Record appends the event, setting its time if it has none; failures are logged, since the operation already happened
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(&e)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit log: %s\n", err)
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		log.Printf("Error syncing audit log: %s\n", err)
+	}
+}
+
+// RecordOperator records an operation done by the operator of the machine, e.g. through a signal
+func (l *Log) RecordOperator(source, action string) {
+	l.Record(Event{Actor: "operator", Source: source, Action: action})
+}
+
+// Middleware records every call to the wrapped handler with its client, method, URL and response status.
+// Nothing authenticates the callers, so a basic auth user is only recorded as the unverified claim of the client.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := middleware.NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+
+		claim, _, _ := r.BasicAuth()
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+		l.Record(Event{Actor: ActorUnverified, Claimed: claim, Source: source, Action: r.Method + " " + r.URL.RequestURI(), Status: sw.Status})
+	})
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// bench generates load through a proxy and reports its hit ratio, latency and throughput
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy bench --target <url> [options]")
+		fmt.Println("Requests the target URL, or the URLs listed with --urls resolved against it, in a loop for the")
+		fmt.Println("duration, then reports the hit ratio, latency percentiles and throughput seen through the proxy.")
+		fs.PrintDefaults()
+	}
+	var headers headerFlags
+	target := fs.String("target", "", "URL requested through the proxy, or its base URL with --urls.")
+	urlsFile := fs.String("urls", "", "File listing the paths to request in turn, one per line; - reads standard input.")
+	duration := fs.Duration("duration", 30*time.Second, "How long the load is generated.")
+	concurrency := fs.Int("concurrency", 10, "Number of requests in flight.")
+	rate := fs.String("rate", "", "Maximum request rate, e.g. 500/s; unlimited when empty.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout of each request.")
+	fs.Var(&headers, "H", "Request header as \"Name: value\", repeatable.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || *target == "" || *duration <= 0 || *concurrency < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	base, err := url.Parse(*target)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return fmt.Errorf("invalid target URL '%s'", *target)
+	}
+	interval, err := parseRate(*rate)
+	if err != nil {
+		return err
+	}
+
+	// Every request is built up front, so building them does not weigh on the measured latencies
+	paths := []string{base.RequestURI()}
+	if *urlsFile != "" {
+		if paths, err = readURLs(*urlsFile); err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no URLs in %s", *urlsFile)
+		}
+	}
+	requests := make([]*http.Request, len(paths))
+	for i, p := range paths {
+		r, err := newRequest(http.MethodGet, p, headers)
+		if err != nil {
+			return err
+		}
+		r.URL = base.ResolveReference(&url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery})
+		requests[i] = r
+	}
+
+	// Ctrl-C ends the run early, still reporting what was measured
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+	// Bodies are counted as sent by the proxy, and only -H asks for a compressed variant
+	transport.DisableCompression = true
+	client := &http.Client{Transport: transport, Timeout: *timeout}
+
+	// With a rate, workers take a token before every request
+	var tokens chan struct{}
+	if interval > 0 {
+		tokens = make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					select {
+					case tokens <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	var next atomic.Uint64
+	workers := make([]benchCounts, *concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts := &workers[i]
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				r := requests[(next.Add(1)-1)%uint64(len(requests))]
+				counts.do(client, r.Clone(ctx))
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := summarize(workers, time.Since(start))
+	if *output == outputJSON {
+		return printJSON(&result)
+	}
+	printBench(os.Stdout, &result)
+	return nil
+}
+
+// benchCounts holds what a worker measured
+type benchCounts struct {
+	latencies []float64      // Latencies of the answered requests in milliseconds
+	errors    int            // Requests that got no answer
+	bytes     int64          // Body bytes received
+	cache     map[string]int // Answers by X-Cache header
+	status    map[int]int    // Answers by status code
+}
+
+// do sends the request and records its outcome; requests cut short by the end of the run are not counted
+func (c *benchCounts) do(client *http.Client, r *http.Request) {
+	start := time.Now()
+	resp, err := client.Do(r)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		c.errors++
+		return
+	}
+	c.bytes += n
+	c.latencies = append(c.latencies, float64(time.Since(start).Microseconds())/1000)
+	if c.cache == nil {
+		c.cache, c.status = make(map[string]int), make(map[int]int)
+	}
+	cacheStatus := resp.Header.Get("X-Cache")
+	if cacheStatus == "" {
+		cacheStatus = "NONE"
+	}
+	c.cache[cacheStatus]++
+	c.status[resp.StatusCode]++
+}
+
+// benchResult summarizes a run
+type benchResult struct {
+	Requests    int                `json:"requests"`         // Requests answered
+	Errors      int                `json:"errors"`           // Requests that got no answer
+	DurationMS  int64              `json:"duration_ms"`      // Length of the run
+	Throughput  float64            `json:"requests_per_sec"` // Answered requests per second
+	BytesPerSec float64            `json:"bytes_per_sec"`    // Body bytes received per second
+	HitRatio    float64            `json:"hit_ratio"`        // Share of answers that were cache hits
+	Cache       map[string]int     `json:"cache"`            // Answers by X-Cache header, NONE when it was missing
+	Status      map[string]int     `json:"status"`           // Answers by status code
+	Latency     map[string]float64 `json:"latency_ms"`       // Latency percentiles, min, mean and max in milliseconds
+}
+
+// summarize merges the counts of the workers
+func summarize(workers []benchCounts, elapsed time.Duration) benchResult {
+	result := benchResult{
+		DurationMS: elapsed.Milliseconds(),
+		Cache:      make(map[string]int),
+		Status:     make(map[string]int),
+		Latency:    make(map[string]float64),
+	}
+	var latencies []float64
+	var bytes int64
+	for i := range workers {
+		w := &workers[i]
+		latencies = append(latencies, w.latencies...)
+		result.Errors += w.errors
+		bytes += w.bytes
+		for cacheStatus, n := range w.cache {
+			result.Cache[cacheStatus] += n
+		}
+		for status, n := range w.status {
+			result.Status[strconv.Itoa(status)] += n
+		}
+	}
+	result.Requests = len(latencies)
+	result.Throughput = float64(result.Requests) / elapsed.Seconds()
+	result.BytesPerSec = float64(bytes) / elapsed.Seconds()
+	if result.Requests > 0 {
+		result.HitRatio = float64(result.Cache["HIT"]) / float64(result.Requests)
+	}
+
+	slices.Sort(latencies)
+	if len(latencies) > 0 {
+		var sum float64
+		for _, l := range latencies {
+			sum += l
+		}
+		result.Latency["min"] = latencies[0]
+		result.Latency["mean"] = sum / float64(len(latencies))
+		result.Latency["max"] = latencies[len(latencies)-1]
+	}
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		result.Latency["p"+strconv.Itoa(int(q*100))] = percentile(latencies, q)
+	}
+	return result
+}
+
+// printBench writes the summary of a run in a human-readable form
+func printBench(w io.Writer, r *benchResult) {
+	fmt.Fprintf(w, "Requests:    %d in %s (%d errors)\n", r.Requests, time.Duration(r.DurationMS)*time.Millisecond, r.Errors)
+	fmt.Fprintf(w, "Throughput:  %.1f requests/s, %s/s\n", r.Throughput, formatBytes(int64(r.BytesPerSec)))
+	fmt.Fprintf(w, "Hit ratio:   %.1f%%\n", 100*r.HitRatio)
+	fmt.Fprintf(w, "Cache:      ")
+	for _, cacheStatus := range sortedKeys(r.Cache) {
+		fmt.Fprintf(w, " %s %d", cacheStatus, r.Cache[cacheStatus])
+	}
+	fmt.Fprintf(w, "\nStatus:     ")
+	for _, status := range sortedKeys(r.Status) {
+		fmt.Fprintf(w, " %s %d", status, r.Status[status])
+	}
+	l := r.Latency
+	fmt.Fprintf(w, "\nLatency:     min %.2f ms, mean %.2f ms, max %.2f ms\n", l["min"], l["mean"], l["max"])
+	fmt.Fprintf(w, "             p50 %.2f ms, p90 %.2f ms, p95 %.2f ms, p99 %.2f ms\n", l["p50"], l["p90"], l["p95"], l["p99"])
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+// Package cli implements the management subcommands of the proxy, which work on a cache folder
+// or on a running instance.
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Output formats of the command results
+const (
+	outputText = "text" // Human-readable text
+	outputJSON = "json" // A single JSON document, for scripts
+)
+
+// Command runs a management subcommand with the arguments following its name
+type Command func(args []string) error
+
+// commands maps subcommand names to their implementations
+var commands = map[string]Command{
+	"bench":           bench,
+	"gc":              gc,
+	"inspect":         inspect,
+	"ls":              ls,
+	"purge":           purge,
+	"stats":           stats,
+	"top":             top,
+	"validate-config": validateConfig,
+	"warm":            warm,
+}
+
+// Lookup returns the subcommand with the name
+func Lookup(name string) (Command, bool) {
+	command, ok := commands[name]
+	return command, ok
+}
+
+// outputFlag adds the --output flag choosing the format the command prints its result in
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputText, "Output format: text or json.")
+}
+
+// checkOutput checks the output format given with --output
+func checkOutput(format string) error {
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("invalid output format '%s', expected text or json", format)
+	}
+	return nil
+}
+
+// printJSON writes the value to standard output as indented JSON
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	// URLs are printed as is, not with & and < escaped for HTML
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+// headerFlags collects the values of a repeatable "Name: value" header flag
+type headerFlags []string
+
+// String returns the headers joined with commas
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set adds a header
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/filecache"
+)
+
+// gc removes the expired entries of a running instance, or of a cache folder, at once
+func gc(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy gc (--admin <url> | --cache-folder <path>) [options]")
+		fmt.Println("Removes the expired entries at once, through the admin API of a running instance")
+		fmt.Println("or straight from the cache folder of a stopped one, and reports the space reclaimed.")
+		fs.PrintDefaults()
+	}
+	adminURL := fs.String("admin", "", "Base URL of the admin API of the instance, credentials included if required.")
+	cacheFolder := fs.String("cache-folder", "", "Cache folder of a stopped instance.")
+	cacheTimeout := fs.Duration("cache-timeout", 0, "Cache timeout of the instance, for entries without their own expiry time.")
+	grace := fs.Duration("serve-stale-on-error", 0, "Time expired entries are kept by the instance to be served stale.")
+	timeout := fs.Duration("timeout", time.Minute, "Timeout of the admin API call.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || (*adminURL == "") == (*cacheFolder == "") {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	var collection cache.Collection
+	if *adminURL != "" {
+		var err error
+		if collection, err = collectThrough(&http.Client{Timeout: *timeout}, *adminURL); err != nil {
+			return err
+		}
+	} else {
+		if _, err := os.Stat(*cacheFolder); err != nil {
+			return err
+		}
+		disk := filecache.New(*cacheTimeout, *cacheFolder)
+		disk.SetGracePeriod(*grace)
+		collection = disk.CollectGarbage()
+	}
+
+	if *output == outputJSON {
+		return printJSON(&collection)
+	}
+	fmt.Printf("Removed %d expired entries and %d temporary files, reclaimed %s\n",
+		collection.Entries, collection.TempFiles, formatBytes(collection.Bytes))
+	return nil
+}
+
+// collectThrough runs the garbage collection of a running instance through its admin API
+func collectThrough(client *http.Client, adminURL string) (cache.Collection, error) {
+	var collection cache.Collection
+	endpoint, err := url.JoinPath(adminURL, "cache/gc")
+	if err != nil {
+		return collection, err
+	}
+	resp, err := client.Post(endpoint, "", nil)
+	if err != nil {
+		return collection, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return collection, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&collection); err != nil {
+		return collection, fmt.Errorf("invalid response: %w", err)
+	}
+	return collection, nil
+}
@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/argparser"
+	"github.com/ig-rudenko/caching-proxy/internal/config"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/filecache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/remote"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// keyOptions are the proxy options the cache key depends on, named like the proxy flags
+type keyOptions struct {
+	configFile        string
+	namespace         string
+	foldTrailingSlash bool
+	tenantByHost      bool
+	ignoreQueryParams string
+	languageVariants  string
+	uniqueByUser      bool
+}
+
+// register adds the key option flags to the flag set
+func (o *keyOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "Path to the JSON configuration file, for its vary rules.")
+	fs.StringVar(&o.namespace, "cache-namespace", "", "Value mixed into every cache key.")
+	fs.BoolVar(&o.foldTrailingSlash, "fold-trailing-slash", false, "Cache paths with and without a trailing slash as one entry.")
+	fs.BoolVar(&o.tenantByHost, "tenant-by-host", false, "Keep the cache entries of every request host apart.")
+	fs.StringVar(&o.ignoreQueryParams, "ignore-query-params", "", "Comma-separated query parameters left out of the cache key.")
+	fs.StringVar(&o.languageVariants, "vary-language", "", "Comma-separated primary languages cached separately.")
+	fs.BoolVar(&o.uniqueByUser, "unique", false, "Cache per user (based on User-Agent or cookies).")
+}
+
+// proxy returns a proxy for the cache and origin with the extra options, computing cache keys the way
+// one started with the same options does
+func (o *keyOptions) proxy(c proxy.Cache, origin *url.URL, extra ...proxy.Option) (*proxy.Proxy, error) {
+	cfg := &config.Config{}
+	if o.configFile != "" {
+		var err error
+		if cfg, err = config.Load(o.configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := []proxy.Option{
+		proxy.WithCacheNamespace(o.namespace),
+		proxy.WithFoldTrailingSlash(o.foldTrailingSlash),
+		proxy.WithTenantByHost(o.tenantByHost),
+		proxy.WithIgnoredQueryParams(argparser.SplitList(o.ignoreQueryParams)),
+		proxy.WithLanguageVariants(argparser.SplitList(o.languageVariants)),
+		proxy.WithVaryRules(cfg.Vary),
+		proxy.WithTenantRules(cfg.Tenants),
+	}
+	if o.uniqueByUser {
+		opts = append(opts, proxy.WithUniqueByUser())
+	}
+	return proxy.New(c, origin, append(opts, extra...)...), nil
+}
+
+// inspect prints the cache key of a URL and the entry cached under it, if any
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy inspect [options] <url>")
+		fmt.Println("Shows the cache key of the URL, whether it is cached, and the stored entry.")
+		fs.PrintDefaults()
+	}
+
+	var keyOpts keyOptions
+	var headers headerFlags
+	keyOpts.register(fs)
+	cacheFolder := fs.String("cache-folder", "./cache", "Directory the proxy caches in.")
+	cacheSidecar := fs.String("cache-sidecar", "", "Base URL of the sidecar service storing the cache.")
+	cacheTimeout := fs.Duration("cache-timeout", 0, "Cache timeout of the proxy, for the remaining TTL.")
+	method := fs.String("X", http.MethodGet, "Request method.")
+	fs.Var(&headers, "H", "Request header as \"Name: value\", repeatable (e.g., Accept-Encoding: gzip).")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	r, err := newRequest(*method, fs.Arg(0), headers)
+	if err != nil {
+		return err
+	}
+	p, err := keyOpts.proxy(nil, &url.URL{})
+	if err != nil {
+		return err
+	}
+	result := inspectResult{Method: r.Method, URL: r.URL.RequestURI(), Key: p.CacheKey(r)}
+
+	var entry *cache.Entry
+	var size int64
+	if *cacheSidecar != "" {
+		sidecarURL, err := url.Parse(*cacheSidecar)
+		if err != nil {
+			return fmt.Errorf("invalid sidecar URL: %w", err)
+		}
+		var ok bool
+		if entry, ok = remote.New(sidecarURL, *cacheTimeout).Get(context.Background(), result.Key); ok {
+			size = int64(len(entry.Body))
+		}
+	} else {
+		entry, size, err = filecache.ReadEntry(*cacheFolder, result.Key)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if entry != nil {
+		entry.Body = nil
+		result.Cached, result.Entry, result.Size = true, entry, size
+		if expiresAt, ok := entry.DueAt(*cacheTimeout, 0); ok {
+			result.ExpiresAt = &expiresAt
+		}
+	}
+
+	if *output == outputJSON {
+		return printJSON(&result)
+	}
+	printEntry(os.Stdout, &result)
+	return nil
+}
+
+// inspectResult describes the cache key of a URL and the entry cached under it
+type inspectResult struct {
+	Method    string       `json:"method"`               // Request method
+	URL       string       `json:"url"`                  // Requested path with the query
+	Key       string       `json:"key"`                  // Cache key of the request
+	Cached    bool         `json:"cached"`               // Whether an entry is stored under the key
+	Entry     *cache.Entry `json:"entry,omitempty"`      // Stored entry without its body
+	Size      int64        `json:"size,omitempty"`       // Body size in bytes
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"` // Time the entry expires, nil if it never does
+}
+
+// newRequest builds the request to compute the cache key for, from a full URL or a path
+func newRequest(method, rawURL string, headers []string) (*http.Request, error) {
+	r, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %w", rawURL, err)
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("header '%s' is not in \"Name: value\" form", header)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		// The host is not a header of a Go request, and decides the tenant
+		if strings.EqualFold(name, "Host") {
+			r.Host = value
+			continue
+		}
+		r.Header.Add(name, value)
+	}
+	// The proxy keys requests on the URL as received, without scheme and host
+	r.URL = &url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	return r, nil
+}
+
+// printEntry writes the key and the cached entry in a human-readable form
+func printEntry(w io.Writer, result *inspectResult) {
+	fmt.Fprintf(w, "URL:       %s %s\n", result.Method, result.URL)
+	fmt.Fprintf(w, "Key:       %s\n", result.Key)
+	entry := result.Entry
+	if entry == nil {
+		fmt.Fprintln(w, "Cached:    no")
+		return
+	}
+
+	fmt.Fprintln(w, "Cached:    yes")
+	fmt.Fprintf(w, "Stored:    %s (%s ago)\n", entry.StoredAt.Format(time.RFC3339), time.Since(entry.StoredAt).Round(time.Second))
+	fmt.Fprintf(w, "Status:    %d %s\n", entry.Status, http.StatusText(entry.Status))
+	fmt.Fprintf(w, "Size:      %d bytes\n", result.Size)
+	fmt.Fprintf(w, "TTL:       %s\n", remainingTTL(entry.Pinned, result.ExpiresAt))
+	if !entry.RefreshAt.IsZero() {
+		fmt.Fprintf(w, "Refresh:   %s\n", entry.RefreshAt.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(w, "Headers:")
+	names := make([]string, 0, len(entry.Header))
+	for name := range entry.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range entry.Header[name] {
+			fmt.Fprintf(w, "  %s: %s\n", name, value)
+		}
+	}
+}
+
+// remainingTTL describes how long an entry expiring at the time stays fresh
+func remainingTTL(pinned bool, expiresAt *time.Time) string {
+	switch {
+	case pinned:
+		return "never expires (pinned)"
+	case expiresAt == nil:
+		return "never expires"
+	case time.Until(*expiresAt) <= 0:
+		return fmt.Sprintf("expired %s ago", time.Since(*expiresAt).Round(time.Second))
+	default:
+		return time.Until(*expiresAt).Round(time.Second).String()
+	}
+}
@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/filecache"
+)
+
+// lsEntry is a cached entry listed by ls
+type lsEntry struct {
+	Key         string    `json:"key"`          // Cache key, the name of the entry file
+	URL         string    `json:"url"`          // URL the response was cached for, empty in older entries
+	Status      int       `json:"status"`       // Stored status code
+	ContentType string    `json:"content_type"` // Stored Content-Type header
+	Size        int64     `json:"size"`         // Body size in bytes
+	StoredAt    time.Time `json:"stored_at"`    // Time the entry was stored
+	Pinned      bool      `json:"pinned"`       // Whether the entry never expires
+}
+
+// lsSorts orders listed entries by the field named with --sort
+var lsSorts = map[string]func(a, b lsEntry) int{
+	"url":    func(a, b lsEntry) int { return cmp.Compare(a.URL, b.URL) },
+	"age":    func(a, b lsEntry) int { return b.StoredAt.Compare(a.StoredAt) },
+	"size":   func(a, b lsEntry) int { return cmp.Compare(a.Size, b.Size) },
+	"status": func(a, b lsEntry) int { return cmp.Compare(a.Status, b.Status) },
+}
+
+// ls lists the entries of a cache folder matching the filters
+func ls(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy ls [options]")
+		fmt.Println("Lists the entries of the cache folder with their URL, status, content type, size and age.")
+		fs.PrintDefaults()
+	}
+	cacheFolder := fs.String("cache-folder", "./cache", "Directory the proxy caches in.")
+	urlFilter := fs.String("url", "", "List only entries whose URL contains the text.")
+	minAge := fs.Duration("min-age", 0, "List only entries stored at least this long ago (e.g., 1h).")
+	contentType := fs.String("content-type", "", "List only entries whose Content-Type starts with the text (e.g., image/).")
+	minSize := fs.String("min-size", "", "List only entries with a body at least this large (e.g., 512, 10K, 1M).")
+	sortBy := fs.String("sort", "url", "Sort by url, age, size or status.")
+	reverse := fs.Bool("reverse", false, "Reverse the order.")
+	limit := fs.Int("limit", 0, "Maximum number of entries listed, 0 for all.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	compare, ok := lsSorts[*sortBy]
+	if !ok {
+		return fmt.Errorf("invalid sort '%s', expected url, age, size or status", *sortBy)
+	}
+	minBytes, err := parseSize(*minSize)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	entries := []lsEntry{}
+	err = filecache.WalkEntries(*cacheFolder, func(key string, entry *cache.Entry, size int64) {
+		listed := lsEntry{
+			Key:         key,
+			URL:         entry.URL,
+			Status:      entry.Status,
+			ContentType: entry.Header.Get("Content-Type"),
+			Size:        size,
+			StoredAt:    entry.StoredAt,
+			Pinned:      entry.Pinned,
+		}
+		switch {
+		case *urlFilter != "" && !strings.Contains(listed.URL, *urlFilter),
+			now.Sub(listed.StoredAt) < *minAge,
+			*contentType != "" && !strings.HasPrefix(listed.ContentType, *contentType),
+			listed.Size < minBytes:
+			return
+		}
+		entries = append(entries, listed)
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortStableFunc(entries, func(a, b lsEntry) int {
+		if *reverse {
+			a, b = b, a
+		}
+		return cmp.Or(compare(a, b), cmp.Compare(a.Key, b.Key))
+	})
+	if *limit > 0 {
+		entries = entries[:min(*limit, len(entries))]
+	}
+
+	if *output == outputJSON {
+		return printJSON(entries)
+	}
+	printEntries(os.Stdout, entries, now)
+	return nil
+}
+
+// printEntries writes the listed entries as a table
+func printEntries(w io.Writer, entries []lsEntry, now time.Time) {
+	fmt.Fprintf(w, "%-10s %6s %10s  %-24s  %s\n", "AGE", "STATUS", "SIZE", "CONTENT-TYPE", "URL")
+	for _, e := range entries {
+		target := e.URL
+		if target == "" {
+			// Entries cached before URLs were recorded are known by their key only
+			target = "(key " + e.Key + ")"
+		}
+		contentType, _, _ := strings.Cut(e.ContentType, ";")
+		fmt.Fprintf(w, "%-10s %6d %10s  %-24s  %s\n",
+			now.Sub(e.StoredAt).Round(time.Second), e.Status, formatBytes(e.Size), contentType, target)
+	}
+	fmt.Fprintf(w, "%d entries\n", len(entries))
+}
+
+// parseSize parses a byte size with an optional K, M or G binary suffix, zero when empty
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	number, shift := size, 0
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		number = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected e.g. 512, 10K or 1M", size)
+	}
+	return n << shift, nil
+}
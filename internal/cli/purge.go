@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/argparser"
+)
+
+// purge removes the cached entries of a URL, or of the URLs matching a pattern, on running instances
+func purge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy purge --admin <url> [options] <url or pattern>")
+		fmt.Println("Purges a URL, or the URLs matching a pattern such as /static/*, through the admin API.")
+		fs.PrintDefaults()
+	}
+	adminURL := fs.String("admin", "", "Base URL of the admin API of the instance, credentials included if required.")
+	allInstances := fs.Bool("all-instances", false, "Purge on the peers as well.")
+	peers := fs.String("peers", "", "Comma-separated base URLs of the admin API of the other instances.")
+	tenant := fs.String("tenant", "", "Purge only the entries of this tenant, with --tenant-by-host the request host.")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each admin API call.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 || *adminURL == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	instances := []string{*adminURL}
+	if *allInstances {
+		if *peers == "" {
+			return fmt.Errorf("--all-instances requires --peers")
+		}
+		instances = append(instances, argparser.SplitList(*peers)...)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	results := make([]purgeResult, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Instance = redact(instance)
+			purged, err := purgeInstance(client, instance, *tenant, fs.Arg(0))
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+			results[i].Purged = purged
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if *output == outputJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s: error: %s\n", result.Instance, result.Error)
+			} else {
+				fmt.Printf("%s: purged %d entries\n", result.Instance, result.Purged)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("purge failed on %d of %d instances", failed, len(instances))
+	}
+	return nil
+}
+
+// purgeResult is the outcome of a purge on one instance
+type purgeResult struct {
+	Instance string `json:"instance"`        // Admin API of the instance, without its password
+	Purged   int    `json:"purged"`          // Number of entries removed
+	Error    string `json:"error,omitempty"` // Why the purge failed, empty if it succeeded
+}
+
+// purgeInstance calls the purge endpoint of the admin API and returns how many entries were removed;
+// an empty tenant purges the entries of every tenant
+func purgeInstance(client *http.Client, adminURL, tenant, target string) (int, error) {
+	endpoint, err := url.JoinPath(adminURL, "cache/purge")
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{"url": {target}}
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	resp, err := client.Post(endpoint+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var result struct {
+		Purged int `json:"purged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	return result.Purged, nil
+}
+
+// redact returns the URL without its password, for printing
+func redact(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Redacted()
+	}
+	return rawURL
+}
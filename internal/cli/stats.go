@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/admin"
+	"github.com/ig-rudenko/caching-proxy/internal/argparser"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// stats prints the counters of a running instance, or the ones it persisted when it is stopped
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy stats [--admin <url>] [--stats-file <path>] [options]")
+		fmt.Println("Prints the hit ratio, cache usage and most hit URLs of an instance, read from its admin API,")
+		fmt.Println("or from its stats file when it is stopped. With --all-instances the counters of the peers are")
+		fmt.Println("added up into a cluster view.")
+		fs.PrintDefaults()
+	}
+	adminURL := fs.String("admin", "", "Base URL of the admin API of the instance, credentials included if required.")
+	statsFile := fs.String("stats-file", "", "Stats file of the instance, read when the admin API is not given or cannot be reached.")
+	allInstances := fs.Bool("all-instances", false, "Add up the counters of the peers as well.")
+	peers := fs.String("peers", "", "Comma-separated base URLs of the admin API of the other instances.")
+	topN := fs.Int("top", 10, "Number of most hit URLs to print.")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of the admin API call.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || *adminURL == "" && *statsFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *allInstances {
+		if *peers == "" || *adminURL == "" {
+			return fmt.Errorf("--all-instances requires --admin and --peers")
+		}
+		return clusterStats(append([]string{*adminURL}, argparser.SplitList(*peers)...), *topN, *timeout, *output)
+	}
+
+	var report *admin.Report
+	var source string
+	var err error
+	if *adminURL != "" {
+		source = redact(*adminURL)
+		report, err = fetchReport(&http.Client{Timeout: *timeout}, *adminURL, *topN)
+		if err != nil && *statsFile != "" {
+			fmt.Fprintf(os.Stderr, "Admin API unavailable (%s), reading %s\n", err, *statsFile)
+		}
+	}
+	if report == nil && *statsFile != "" {
+		source = *statsFile
+		report, err = readReport(*statsFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The stats file keeps more URLs than asked for
+	report.TopURLs = report.TopURLs[:min(*topN, len(report.TopURLs))]
+	if *output == outputJSON {
+		return printJSON(struct {
+			Source string `json:"source"` // Admin API or stats file the report was read from
+			*admin.Report
+		}{source, report})
+	}
+	printReport(os.Stdout, source, report)
+	return nil
+}
+
+// instanceReport is the report of one instance of a cluster
+type instanceReport struct {
+	Instance string        `json:"instance"`         // Admin API of the instance, without its password
+	Report   *admin.Report `json:"report,omitempty"` // Counters of the instance, nil if they could not be read
+	Error    string        `json:"error,omitempty"`  // Why the counters could not be read
+}
+
+// clusterStats prints the counters of every instance and their sum
+func clusterStats(instances []string, top int, timeout time.Duration, output string) error {
+	client := &http.Client{Timeout: timeout}
+	results := make([]instanceReport, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Instance = redact(instance)
+			report, err := fetchReport(client, instance, top)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Report = report
+		}()
+	}
+	wg.Wait()
+
+	var reports []*admin.Report
+	for _, result := range results {
+		if result.Report != nil {
+			reports = append(reports, result.Report)
+		}
+	}
+	cluster := mergeReports(reports, top)
+
+	if output == outputJSON {
+		if err := printJSON(struct {
+			Instances []instanceReport `json:"instances"` // Counters of every instance
+			Cluster   *admin.Report    `json:"cluster"`   // Counters added up over the instances that answered
+		}{results, cluster}); err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s: error: %s\n", result.Instance, result.Error)
+				continue
+			}
+			s := result.Report.Stats
+			fmt.Printf("%s: %.1f%% hit ratio, %d hits, %d misses", result.Instance, 100*s.HitRatio(), s.Hits, s.Misses)
+			if u := result.Report.Cache; u != nil {
+				fmt.Printf(", %d entries, %s on disk", u.Entries, formatBytes(u.DiskBytes))
+			}
+			fmt.Println()
+		}
+		fmt.Println()
+		printReport(os.Stdout, fmt.Sprintf("%d of %d instances", len(reports), len(instances)), cluster)
+	}
+	if len(reports) < len(instances) {
+		return fmt.Errorf("stats unavailable on %d of %d instances", len(instances)-len(reports), len(instances))
+	}
+	return nil
+}
+
+// mergeReports adds up the counters of the reports into one cluster report: the hit ratio follows from the
+// summed hits and misses, the most hit URLs and tenants are summed by name, and the cache usage is counted only
+// for the instances reporting one. The origin health is left out, it is only meaningful per instance.
+func mergeReports(reports []*admin.Report, top int) *admin.Report {
+	merged := &admin.Report{TopURLs: []proxy.URLHits{}}
+	urlHits := make(map[string]uint64)
+	tenants := make(map[string]*proxy.TenantStats)
+	for _, report := range reports {
+		if report.Time.After(merged.Time) {
+			merged.Time = report.Time
+		}
+		s, m := report.Stats, &merged.Stats
+		m.Hits += s.Hits
+		m.Misses += s.Misses
+		m.Bypasses += s.Bypasses
+		m.Stale += s.Stale
+		m.PendingWrites += s.PendingWrites
+		m.OriginRequests += s.OriginRequests
+		m.OriginErrors += s.OriginErrors
+		if s.LastOriginError.After(m.LastOriginError) {
+			m.LastOriginError = s.LastOriginError
+		}
+		if s.LastOriginAnswer.After(m.LastOriginAnswer) {
+			m.LastOriginAnswer = s.LastOriginAnswer
+		}
+
+		if u := report.Cache; u != nil {
+			if merged.Cache == nil {
+				merged.Cache = &cache.Usage{}
+			}
+			merged.Cache.Entries += u.Entries
+			merged.Cache.DiskBytes += u.DiskBytes
+			merged.Cache.MemoryBytes += u.MemoryBytes
+			merged.Cache.Evictions += u.Evictions
+			merged.Cache.Expirations += u.Expirations
+		}
+
+		for _, u := range report.TopURLs {
+			urlHits[u.URL] += u.Hits
+		}
+		for _, t := range report.Tenants {
+			sum, ok := tenants[t.Tenant]
+			if !ok {
+				sum = &proxy.TenantStats{Tenant: t.Tenant}
+				tenants[t.Tenant] = sum
+			}
+			sum.Hits += t.Hits
+			sum.Misses += t.Misses
+			sum.Bypasses += t.Bypasses
+		}
+	}
+
+	for u, hits := range urlHits {
+		merged.TopURLs = append(merged.TopURLs, proxy.URLHits{URL: u, Hits: hits})
+	}
+	slices.SortFunc(merged.TopURLs, func(a, b proxy.URLHits) int {
+		if c := cmp.Compare(b.Hits, a.Hits); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.URL, b.URL)
+	})
+	merged.TopURLs = merged.TopURLs[:min(top, len(merged.TopURLs))]
+
+	for _, t := range tenants {
+		merged.Tenants = append(merged.Tenants, *t)
+	}
+	slices.SortFunc(merged.Tenants, func(a, b proxy.TenantStats) int {
+		return cmp.Compare(a.Tenant, b.Tenant)
+	})
+	return merged
+}
+
+// fetchReport reads the report of a running instance from its admin API
+func fetchReport(client *http.Client, adminURL string, top int) (*admin.Report, error) {
+	endpoint, err := url.JoinPath(adminURL, "stats")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(endpoint + "?top=" + strconv.Itoa(top))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API answered %s", resp.Status)
+	}
+	var report admin.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &report, nil
+}
+
+// readReport reads the report an instance persisted to its stats file
+func readReport(path string) (*admin.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report admin.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid stats file %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// printReport writes the report in a human-readable form
+func printReport(w io.Writer, source string, report *admin.Report) {
+	s := report.Stats
+	fmt.Fprintf(w, "Source:     %s (as of %s)\n", source, report.Time.Format(time.RFC3339))
+	fmt.Fprintf(w, "Hit ratio:  %.1f%% (%d hits, %d misses, %d bypasses, %d stale)\n",
+		100*s.HitRatio(), s.Hits, s.Misses, s.Bypasses, s.Stale)
+	fmt.Fprintf(w, "Origin:     %d requests, %d errors\n", s.OriginRequests, s.OriginErrors)
+	if u := report.Cache; u != nil {
+		fmt.Fprintf(w, "Entries:    %d\n", u.Entries)
+		fmt.Fprintf(w, "Disk:       %s\n", formatBytes(u.DiskBytes))
+		fmt.Fprintf(w, "Memory:     %s\n", formatBytes(u.MemoryBytes))
+		fmt.Fprintf(w, "Removed:    %d evicted, %d expired\n", u.Evictions, u.Expirations)
+	}
+
+	if len(report.TopURLs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Top URLs by hits:")
+	for _, u := range report.TopURLs {
+		fmt.Fprintf(w, "  %10d  %s\n", u.Hits, u.URL)
+	}
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,306 @@
+package cli
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// latencySamples is the number of recent miss latencies the percentiles are computed from
+const latencySamples = 1000
+
+// maxTopURLs limits the number of URLs counted by the dashboard
+const maxTopURLs = 10000
+
+// reconnectDelay is the wait before the event stream is opened again after it dropped
+const reconnectDelay = 2 * time.Second
+
+// top shows a live view of the activity of a running instance, fed by its event stream
+func top(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy top --admin <url> [options]")
+		fmt.Println("Shows the requests per second, hit ratio, origin latency, evictions and most requested URLs")
+		fmt.Println("of a running instance, refreshed live from its admin event stream. Ctrl-C quits.")
+		fs.PrintDefaults()
+	}
+	adminURL := fs.String("admin", "", "Base URL of the admin API of the instance, credentials included if required.")
+	interval := fs.Duration("interval", time.Second, "Refresh interval.")
+	topN := fs.Int("top", 15, "Number of most requested URLs to show.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || *adminURL == "" || *interval <= 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	endpoint, err := url.JoinPath(*adminURL, "events")
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	d := &dashboard{source: redact(*adminURL), started: time.Now(), urls: make(map[string]*urlActivity)}
+	go d.follow(ctx, endpoint)
+
+	if *output == outputText {
+		// The cursor is hidden while the screen is redrawn and shown again on exit
+		fmt.Print("\033[?25l")
+		defer fmt.Print("\033[?25h\n")
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			snapshot := d.snapshot(*interval, *topN)
+			if *output == outputJSON {
+				// One document per line, so the stream can be consumed line by line
+				data, err := json.Marshal(&snapshot)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				continue
+			}
+			render(os.Stdout, &snapshot)
+		}
+	}
+}
+
+// urlActivity counts the requests of a URL
+type urlActivity struct {
+	requests uint64
+	hits     uint64
+}
+
+// dashboard accumulates the events of the stream between refreshes
+type dashboard struct {
+	source    string
+	started   time.Time
+	mu        sync.Mutex
+	connected bool
+	lastError string
+	counts    map[string]uint64 // Events by type since the last refresh
+	hits      uint64            // Hits since the dashboard started
+	misses    uint64            // Misses since the dashboard started
+	latencies []float64         // Recent miss latencies in milliseconds, a ring of latencySamples
+	next      int               // Position of the next latency in the ring
+	urls      map[string]*urlActivity
+}
+
+// follow reads the event stream until the context is done, opening it again whenever it drops
+func (d *dashboard) follow(ctx context.Context, endpoint string) {
+	for {
+		err := d.read(ctx, endpoint)
+		d.mu.Lock()
+		d.connected = false
+		if err != nil {
+			d.lastError = err.Error()
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// read opens the event stream and records its events until it ends
+func (d *dashboard) read(ctx context.Context, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin API answered %s", resp.Status)
+	}
+
+	d.mu.Lock()
+	d.connected, d.lastError = true, ""
+	d.mu.Unlock()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e proxy.Event
+		if err := json.Unmarshal([]byte(data), &e); err == nil {
+			d.record(&e)
+		}
+	}
+	return scanner.Err()
+}
+
+// record counts the event
+func (d *dashboard) record(e *proxy.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.counts == nil {
+		d.counts = make(map[string]uint64)
+	}
+	d.counts[e.Type]++
+
+	switch e.Type {
+	case proxy.EventHit, proxy.EventMiss, proxy.EventBypass, proxy.EventStale:
+	default:
+		return
+	}
+	if e.Type == proxy.EventHit {
+		d.hits++
+	}
+	if e.Type == proxy.EventMiss {
+		d.misses++
+		if len(d.latencies) < latencySamples {
+			d.latencies = append(d.latencies, e.Latency)
+		} else {
+			d.latencies[d.next] = e.Latency
+		}
+		d.next = (d.next + 1) % latencySamples
+	}
+
+	u, ok := d.urls[e.URL]
+	if !ok {
+		if len(d.urls) >= maxTopURLs {
+			return
+		}
+		u = &urlActivity{}
+		d.urls[e.URL] = u
+	}
+	u.requests++
+	if e.Type == proxy.EventHit {
+		u.hits++
+	}
+}
+
+// topSnapshot is the state of the dashboard at a refresh
+type topSnapshot struct {
+	Time         time.Time          `json:"time"`
+	Source       string             `json:"source"`             // Admin API the events come from
+	Connected    bool               `json:"connected"`          // Whether the event stream is open
+	Error        string             `json:"error,omitempty"`    // Why the event stream dropped
+	Rates        map[string]float64 `json:"rates"`              // Events per second by type since the last refresh
+	RequestRate  float64            `json:"requests_per_sec"`   // Requests per second since the last refresh
+	HitRatio     float64            `json:"hit_ratio"`          // Share of lookups answered from the cache since the last refresh
+	SessionRatio float64            `json:"session_hit_ratio"`  // Share of lookups answered from the cache since the dashboard started
+	LatencyP50   float64            `json:"origin_latency_p50"` // Median latency of recent misses, in milliseconds
+	LatencyP95   float64            `json:"origin_latency_p95"`
+	LatencyP99   float64            `json:"origin_latency_p99"`
+	TopURLs      []topURL           `json:"top_urls"` // Most requested URLs since the dashboard started
+}
+
+// topURL is a URL among the most requested ones
+type topURL struct {
+	URL      string `json:"url"`
+	Requests uint64 `json:"requests"`
+	Hits     uint64 `json:"hits"`
+}
+
+// snapshot returns the state of the dashboard and starts a new refresh interval
+func (d *dashboard) snapshot(interval time.Duration, n int) topSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := topSnapshot{
+		Time:      time.Now(),
+		Source:    d.source,
+		Connected: d.connected,
+		Error:     d.lastError,
+		Rates:     make(map[string]float64),
+	}
+	for kind, count := range d.counts {
+		s.Rates[kind] = float64(count) / interval.Seconds()
+	}
+	hits, misses := d.counts[proxy.EventHit], d.counts[proxy.EventMiss]
+	s.RequestRate = float64(hits+misses+d.counts[proxy.EventBypass]+d.counts[proxy.EventStale]) / interval.Seconds()
+	s.HitRatio = proxy.Stats{Hits: hits, Misses: misses}.HitRatio()
+	s.SessionRatio = proxy.Stats{Hits: d.hits, Misses: d.misses}.HitRatio()
+	d.counts = nil
+
+	sorted := slices.Clone(d.latencies)
+	slices.Sort(sorted)
+	s.LatencyP50, s.LatencyP95, s.LatencyP99 = percentile(sorted, 0.5), percentile(sorted, 0.95), percentile(sorted, 0.99)
+
+	s.TopURLs = make([]topURL, 0, len(d.urls))
+	for u, activity := range d.urls {
+		s.TopURLs = append(s.TopURLs, topURL{URL: u, Requests: activity.requests, Hits: activity.hits})
+	}
+	slices.SortFunc(s.TopURLs, func(a, b topURL) int {
+		if c := cmp.Compare(b.Requests, a.Requests); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.URL, b.URL)
+	})
+	s.TopURLs = s.TopURLs[:min(n, len(s.TopURLs))]
+	return s
+}
+
+// percentile returns the value below which the share q of the sorted values falls, zero without values
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(q*float64(len(sorted))), len(sorted)-1)]
+}
+
+// render redraws the screen with the snapshot
+func render(w io.Writer, s *topSnapshot) {
+	var b strings.Builder
+	// Move the cursor home and clear the screen, drawing everything in one write to avoid flicker
+	b.WriteString("\033[H\033[2J")
+
+	state := "connected"
+	if !s.Connected {
+		state = "disconnected"
+		if s.Error != "" {
+			state += ": " + s.Error
+		}
+	}
+	fmt.Fprintf(&b, "caching-proxy top - %s - %s (%s)\n\n", s.Source, s.Time.Format(time.TimeOnly), state)
+	fmt.Fprintf(&b, "Requests/s %10.1f    Hit ratio %6.1f%%    Session hit ratio %6.1f%%\n",
+		s.RequestRate, 100*s.HitRatio, 100*s.SessionRatio)
+	fmt.Fprintf(&b, "Hits/s     %10.1f    Misses/s  %7.1f    Bypasses/s %6.1f    Stale/s %6.1f\n",
+		s.Rates[proxy.EventHit], s.Rates[proxy.EventMiss], s.Rates[proxy.EventBypass], s.Rates[proxy.EventStale])
+	fmt.Fprintf(&b, "Stores/s   %10.1f    Evictions/s %5.1f    Purges/s   %6.1f\n",
+		s.Rates[proxy.EventStore], s.Rates[proxy.EventEvict], s.Rates[proxy.EventPurge])
+	fmt.Fprintf(&b, "Origin latency (recent misses)  p50 %.1f ms    p95 %.1f ms    p99 %.1f ms\n\n",
+		s.LatencyP50, s.LatencyP95, s.LatencyP99)
+
+	fmt.Fprintf(&b, "%10s %10s %9s  %s\n", "REQUESTS", "HITS", "HIT%", "URL")
+	for _, u := range s.TopURLs {
+		ratio := 100 * float64(u.Hits) / float64(u.Requests)
+		fmt.Fprintf(&b, "%10d %10d %8.1f%%  %s\n", u.Requests, u.Hits, ratio, u.URL)
+	}
+	_, _ = io.WriteString(w, b.String())
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/config"
+	"github.com/ig-rudenko/caching-proxy/internal/middleware"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// validateConfig checks a configuration file the way the proxy would load it, and more, reporting every problem found
+func validateConfig(args []string) error {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy validate-config -c <path> [--probe [--origin <url>]] [options]")
+		fmt.Println("Checks the configuration file: its syntax and fields, every rule, the TLS and error page files")
+		fmt.Println("and the hook command; with --probe also that the listeners can bind and the origin answers.")
+		fs.PrintDefaults()
+	}
+	var path string
+	fs.StringVar(&path, "c", "", "Path to the JSON configuration file.")
+	fs.StringVar(&path, "config", "", "Path to the JSON configuration file.")
+	probe := fs.Bool("probe", false, "Also check that the listener addresses are free and the origin is reachable.")
+	origin := fs.String("origin", "", "Origin the proxy will forward to, requested with --probe.")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout of the origin probe.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || path == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	problems := checkConfig(path)
+	if *probe && len(problems) == 0 {
+		cfg, _ := config.Load(path)
+		problems = append(problems, probeConfig(cfg, *origin, *timeout)...)
+	}
+
+	result := struct {
+		Config string   `json:"config"`
+		Valid  bool     `json:"valid"`
+		Errors []string `json:"errors"`
+	}{path, len(problems) == 0, make([]string, 0, len(problems))}
+	for _, problem := range problems {
+		result.Errors = append(result.Errors, problem.Error())
+	}
+
+	if *output == outputJSON {
+		if err := printJSON(&result); err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range result.Errors {
+			fmt.Printf("%s: %s\n", path, problem)
+		}
+		if result.Valid {
+			fmt.Printf("%s: configuration is valid\n", path)
+		}
+	}
+	if !result.Valid {
+		return fmt.Errorf("%s is invalid", path)
+	}
+	return nil
+}
+
+// checkConfig checks the configuration file without touching the network and returns the problems found
+func checkConfig(path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	// Unknown fields are most often misspelled ones the proxy would silently ignore
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var strict config.Config
+	if err := decoder.Decode(&strict); err != nil {
+		return []error{jsonError(data, err, decoder.InputOffset())}
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+	addresses := make(map[string]bool)
+	for _, l := range cfg.Listeners {
+		if addresses[l.Address] {
+			problems = append(problems, fmt.Errorf("listener %s: address used by another listener", l.Address))
+		}
+		addresses[l.Address] = true
+
+		if _, err := middleware.Chain(http.NotFoundHandler(), l.Middlewares); err != nil {
+			problems = append(problems, fmt.Errorf("listener %s: %w", l.Address, err))
+		}
+		if l.TLS != nil {
+			if _, err := tls.LoadX509KeyPair(l.TLS.CertFile, l.TLS.KeyFile); err != nil {
+				problems = append(problems, fmt.Errorf("listener %s: tls: %w", l.Address, err))
+			}
+		}
+		if network, address := l.Network(); network == "unix" {
+			if _, err := os.Stat(filepath.Dir(address)); err != nil {
+				problems = append(problems, fmt.Errorf("listener %s: socket directory: %w", l.Address, err))
+			}
+		}
+	}
+
+	if len(cfg.ErrorPages) > 0 {
+		if _, err := proxy.LoadErrorPages(cfg.ErrorPages); err != nil {
+			problems = append(problems, fmt.Errorf("error pages: %w", err))
+		}
+	}
+	if cfg.Hook != nil {
+		if _, err := exec.LookPath(cfg.Hook.Command[0]); err != nil {
+			problems = append(problems, fmt.Errorf("hook: %w", err))
+		}
+	}
+	return problems
+}
+
+// jsonError points a decoding error at the line and column it occurred at
+func jsonError(data []byte, err error, offset int64) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder does not tell where the unknown field is, so its first occurrence is pointed at
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if i := bytes.Index(data, []byte(field)); i >= 0 {
+			offset = int64(i) + 1
+		}
+	}
+	offset = min(offset, int64(len(data)))
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// probeConfig checks that the TCP listeners can bind and the origin answers, returning the problems found
+func probeConfig(cfg *config.Config, origin string, timeout time.Duration) []error {
+	var problems []error
+	for _, l := range cfg.Listeners {
+		if network, address := l.Network(); network == "tcp" {
+			ln, err := net.Listen(network, address)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("listener %s: %w", l.Address, err))
+				continue
+			}
+			_ = ln.Close()
+		}
+	}
+
+	if origin == "" {
+		return problems
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return append(problems, fmt.Errorf("origin %s: not an http or https URL", origin))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return append(problems, fmt.Errorf("origin %s: %w", origin, err))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return append(problems, fmt.Errorf("origin %s: %w", origin, err))
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		problems = append(problems, fmt.Errorf("origin %s: answered %s", origin, resp.Status))
+	}
+	return problems
+}
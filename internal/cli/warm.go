@@ -0,0 +1,332 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/internal/argparser"
+	"github.com/ig-rudenko/caching-proxy/pkg/cache/filecache"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// warm requests a list of URLs, through a running instance or straight against the cache folder,
+// so they are cached before clients ask for them
+func warm(args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: caching-proxy warm --urls <file> (--proxy <url> | --origin <url> [options]) [options]")
+		fmt.Println("Prefetches the URLs listed in the file, one per line, through a running instance,")
+		fmt.Println("or with the proxy engine writing straight to the cache folder of a stopped one.")
+		fs.PrintDefaults()
+	}
+
+	var keyOpts keyOptions
+	var headers headerFlags
+	keyOpts.register(fs)
+	urlsFile := fs.String("urls", "", "File listing the URLs or paths to warm, one per line; - reads standard input.")
+	proxyURL := fs.String("proxy", "", "Base URL of the running instance the URLs are requested through.")
+	peers := fs.String("peers", "", "Comma-separated proxy URLs of the other instances of a cluster, listed as in their --peers; every URL is requested through the instance owning it, --proxy being the URL of one instance.")
+	origin := fs.String("origin", "", "Origin requested by the embedded proxy engine, when no instance is running.")
+	cacheFolder := fs.String("cache-folder", "./cache", "Directory the embedded proxy engine caches in.")
+	cacheTimeout := fs.Duration("cache-timeout", 0, "Cache timeout of the embedded proxy engine.")
+	concurrency := fs.Int("concurrency", 10, "Number of URLs requested at once.")
+	rate := fs.String("rate", "", "Maximum request rate, e.g. 100/s or 600/m; unlimited when empty.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout of each request.")
+	fs.Var(&headers, "H", "Request header as \"Name: value\", repeatable, e.g. to warm the gzip variants.")
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 || *urlsFile == "" || (*proxyURL == "") == (*origin == "") || *concurrency < 1 || *peers != "" && *proxyURL == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	interval, err := parseRate(*rate)
+	if err != nil {
+		return err
+	}
+	urls, err := readURLs(*urlsFile)
+	if err != nil {
+		return err
+	}
+
+	// fetch requests the URL and returns its cache status and the instance it was requested through, if any
+	var fetch func(target string) (string, string, error)
+	if *proxyURL != "" {
+		base, err := url.Parse(*proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		owner, err := keyOwner(&keyOpts, base, argparser.SplitList(*peers))
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Timeout: *timeout}
+		fetch = func(target string) (string, string, error) {
+			instance, err := owner(target, headers)
+			if err != nil {
+				return "", "", err
+			}
+			status, err := fetchThrough(client, instance, target, headers)
+			return status, redact(instance.String()), err
+		}
+	} else {
+		originURL, err := url.Parse(*origin)
+		if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+			return fmt.Errorf("invalid origin URL '%s'", *origin)
+		}
+		// Failed URLs are reported by the command, the per-request log would only drown them
+		p, err := keyOpts.proxy(filecache.New(*cacheTimeout, *cacheFolder), originURL,
+			proxy.WithOriginTimeout(*timeout),
+			proxy.WithDefaultTTL(*cacheTimeout),
+			proxy.WithLogger(log.New(io.Discard, "", 0)),
+		)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = p.FlushCacheWrites(context.Background())
+		}()
+		fetch = func(target string) (string, string, error) {
+			r, err := newRequest(http.MethodGet, target, headers)
+			if err != nil {
+				return "", "", err
+			}
+			w := &discardWriter{header: make(http.Header), status: http.StatusOK}
+			p.ServeHTTP(w, r)
+			status, err := checkWarmed(w.status, w.header)
+			return status, "", err
+		}
+	}
+
+	// URLs are handed to the workers no faster than the rate allows
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for _, u := range urls {
+			if tick != nil {
+				<-tick
+			}
+			jobs <- u
+		}
+	}()
+
+	start := time.Now()
+	result := warmResult{URLs: len(urls), Failures: []warmFailure{}}
+	if *peers != "" {
+		result.Instances = make(map[string]int)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				status, instance, err := fetch(target)
+				mu.Lock()
+				if result.Instances != nil && instance != "" {
+					result.Instances[instance]++
+				}
+				switch {
+				case err != nil:
+					result.Failures = append(result.Failures, warmFailure{URL: target, Error: err.Error()})
+					if *output == outputText {
+						fmt.Printf("%s: %s\n", target, err)
+					}
+				case status == "HIT":
+					result.Cached++
+				default:
+					result.Fetched++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	result.DurationMS = elapsed.Milliseconds()
+
+	if *output == outputJSON {
+		if err := printJSON(&result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Warmed %d URLs in %s: %d fetched, %d already cached, %d failed\n",
+			result.URLs, elapsed, result.Fetched, result.Cached, len(result.Failures))
+		for _, instance := range slices.Sorted(maps.Keys(result.Instances)) {
+			fmt.Printf("  %s: %d URLs\n", instance, result.Instances[instance])
+		}
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("%d URLs could not be warmed", len(result.Failures))
+	}
+	return nil
+}
+
+// warmResult counts the outcomes of a warm run
+type warmResult struct {
+	URLs       int            `json:"urls"`                // URLs listed
+	Fetched    int            `json:"fetched"`             // URLs fetched from the origin
+	Cached     int            `json:"cached"`              // URLs already cached
+	Failures   []warmFailure  `json:"failures"`            // URLs the origin did not answer successfully
+	DurationMS int64          `json:"duration_ms"`         // Time the run took in milliseconds
+	Instances  map[string]int `json:"instances,omitempty"` // URLs requested through every instance of a cluster
+}
+
+// warmFailure is a URL that could not be warmed
+type warmFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// keyOwner returns a function picking the instance a URL is requested through: the one owning its cache key
+// when peers are given, so every instance warms its own share of a cluster and no object is fetched twice,
+// otherwise always the base
+func keyOwner(keyOpts *keyOptions, base *url.URL, peers []string) (func(target string, headers []string) (*url.URL, error), error) {
+	if len(peers) == 0 {
+		return func(string, []string) (*url.URL, error) { return base, nil }, nil
+	}
+	peerURLs := make([]*url.URL, 0, len(peers))
+	for _, peer := range peers {
+		peerURL, err := url.Parse(peer)
+		if err != nil || peerURL.Scheme == "" || peerURL.Host == "" {
+			return nil, fmt.Errorf("invalid peer URL '%s'", peer)
+		}
+		peerURLs = append(peerURLs, peerURL)
+	}
+	// The ring is the one of the instance at the base, which keys requests the way the options say
+	ring, err := keyOpts.proxy(nil, &url.URL{}, proxy.WithPeers(base, peerURLs))
+	if err != nil {
+		return nil, err
+	}
+	return func(target string, headers []string) (*url.URL, error) {
+		r, err := newRequest(http.MethodGet, target, headers)
+		if err != nil {
+			return nil, err
+		}
+		return ring.KeyOwner(r), nil
+	}, nil
+}
+
+// fetchThrough requests the URL through the running instance and returns its cache status
+func fetchThrough(client *http.Client, base *url.URL, target string, headers []string) (string, error) {
+	r, err := newRequest(http.MethodGet, target, headers)
+	if err != nil {
+		return "", err
+	}
+	r.URL = base.ResolveReference(&url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery})
+	r.Host = ""
+	// Without it the client asks for gzip on its own, warming the compressed variant instead of the plain one
+	if r.Header.Get("Accept-Encoding") == "" {
+		r.Header.Set("Accept-Encoding", "identity")
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// The body is read in full, so the proxy caches the complete response
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return "", err
+	}
+	return checkWarmed(resp.StatusCode, resp.Header)
+}
+
+// checkWarmed returns the cache status of a warming response, or an error if the origin did not answer it
+// successfully
+func checkWarmed(status int, header http.Header) (string, error) {
+	if status >= http.StatusBadRequest {
+		return "", fmt.Errorf("status %d", status)
+	}
+	return header.Get("X-Cache"), nil
+}
+
+// readURLs reads the URLs to warm, one per line, skipping blank lines and # comments
+func readURLs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// parseRate parses a rate such as 100/s, 600/m or 100 (per second) into the interval between requests,
+// zero when the rate is empty
+func parseRate(rate string) (time.Duration, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	count, unit, _ := strings.Cut(rate, "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("invalid rate '%s', expected e.g. 100/s", rate)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s', expected e.g. 100/s", rate)
+	}
+	return time.Duration(float64(per) / n), nil
+}
+
+// discardWriter is a response writer keeping only the status and headers, for the embedded proxy engine
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+// Header returns the response headers
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+// Write discards the body
+func (w *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader records the status
+func (w *discardWriter) WriteHeader(status int) {
+	w.status = status
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+	"os"
+	"strings"
+)
+
+// Config holds the structured settings loaded from the configuration file
+type Config struct {
+	Listeners    []Listener               `json:"listeners"`     // Addresses the proxy accepts connections on
+	Headers      []proxy.HeaderRule       `json:"headers"`       // Header rewrite rules for requests and responses
+	CORS         []proxy.CORSRule         `json:"cors"`          // CORS rules answered and injected by the proxy
+	ErrorPages   map[string]string        `json:"error_pages"`   // HTML templates for proxy errors, keyed by status code or "default"
+	ContentTypes []proxy.ContentTypeRule  `json:"content_types"` // Rules deciding caching by response Content-Type
+	Pinned       []string                 `json:"pinned"`        // Path patterns whose cached responses never expire
+	Vary         []proxy.VaryRule         `json:"vary"`          // Request headers added to the cache key per path
+	PostCache    []proxy.PostCacheRule    `json:"post_cache"`    // Paths whose POST responses are cached
+	Hook         *proxy.HookConfig        `json:"hook"`          // Script consulted for requests and responses
+	Routes       []proxy.Route            `json:"routes"`        // Named path patterns labeling the metrics
+	OriginAuth   *proxy.OriginCredentials `json:"origin_auth"`   // Credentials added to every origin request
+	TenantQuotas map[string]int           `json:"tenant_quotas"` // Megabytes single tenants may keep on disk, overriding --tenant-quota
+	Tenants      []proxy.TenantRule       `json:"tenants"`       // Settings overridden per host and path
+}
+
+// Listener describes a single address the proxy listens on
+type Listener struct {
+	Address     string   `json:"address"`     // "host:port" for TCP, "unix:/path/to.sock" for a unix socket or "systemd:name" for a socket passed by systemd
+	Handler     string   `json:"handler"`     // Handler served on this listener: "proxy" (default) or "admin"
+	TLS         *TLS     `json:"tls"`         // Optional TLS certificate and key, enables HTTPS on the listener
+	Middlewares []string `json:"middlewares"` // Names of middlewares applied to this listener, in order
+}
+
+// TLS holds paths to the certificate and private key used by a TLS listener
+type TLS struct {
+	CertFile string `json:"cert_file"` // Path to the PEM encoded certificate
+	KeyFile  string `json:"key_file"`  // Path to the PEM encoded private key
+}
+
+// Listener handler names
+const (
+	HandlerProxy = "proxy"
+	HandlerAdmin = "admin"
+)
+
+// Load reads and validates the JSON configuration file at the given path
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration for missing or conflicting values and fills in defaults
+func (c *Config) Validate() error {
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+
+		if l.Address == "" {
+			return fmt.Errorf("listener #%d: address is required", i+1)
+		}
+
+		// Use the proxy handler when none was specified
+		if l.Handler == "" {
+			l.Handler = HandlerProxy
+		}
+		if l.Handler != HandlerProxy && l.Handler != HandlerAdmin {
+			return fmt.Errorf("listener %s: unknown handler '%s'", l.Address, l.Handler)
+		}
+
+		if l.TLS != nil && (l.TLS.CertFile == "" || l.TLS.KeyFile == "") {
+			return fmt.Errorf("listener %s: tls requires both cert_file and key_file", l.Address)
+		}
+	}
+
+	for i := range c.Headers {
+		if err := c.Headers[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.CORS {
+		if err := c.CORS[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.ContentTypes {
+		if err := c.ContentTypes[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Vary {
+		if err := c.Vary[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Routes {
+		if err := c.Routes[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Tenants {
+		if err := c.Tenants[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.PostCache {
+		if err := c.PostCache[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := proxy.ValidatePinned(c.Pinned); err != nil {
+		return err
+	}
+
+	if c.Hook != nil {
+		if err := c.Hook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.OriginAuth != nil {
+		if err := c.OriginAuth.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for tenant, quota := range c.TenantQuotas {
+		if quota < 0 {
+			return fmt.Errorf("tenant quota of %s: %d megabytes must not be negative", tenant, quota)
+		}
+	}
+	return nil
+}
+
+// IsUnix reports whether the listener address points to a unix socket
+func (l *Listener) IsUnix() bool {
+	return strings.HasPrefix(l.Address, "unix:")
+}
+
+// IsSystemd reports whether the listener takes its socket from systemd socket activation
+func (l *Listener) IsSystemd() bool {
+	return strings.HasPrefix(l.Address, "systemd:")
+}
+
+// Network returns the network name and address suitable for net.Listen,
+// or "systemd" and the socket name for sockets passed by systemd
+func (l *Listener) Network() (string, string) {
+	if l.IsUnix() {
+		return "unix", strings.TrimPrefix(l.Address, "unix:")
+	}
+	if l.IsSystemd() {
+		return "systemd", strings.TrimPrefix(l.Address, "systemd:")
+	}
+	return "tcp", l.Address
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+const (
+	alertCheckInterval = 10 * time.Second // How often the thresholds are checked
+	minAlertSamples    = 20               // Fewest lookups or origin requests in a window for its rates to count
+	webhookTimeout     = 10 * time.Second // Time limit for delivering an alert to the webhook
+)
+
+// Thresholds are the limits alerts fire on, computed over a sliding window
+type Thresholds struct {
+	MinHitRatio  float64       // Hit ratio below which an alert fires, between 0 and 1; zero disables the alert
+	MaxErrorRate float64       // Share of failed origin requests above which an alert fires; zero disables the alert
+	Window       time.Duration // Period the rates are computed over
+	Webhook      *url.URL      // Receives alerts as JSON POST requests, nil to only log them
+}
+
+// Alert is a threshold crossing, as sent to the webhook
+type Alert struct {
+	Name      string    `json:"name"`      // "hit_ratio" or "origin_error_rate"
+	Status    string    `json:"status"`    // "firing" when the threshold is crossed, "resolved" when back within it
+	Value     float64   `json:"value"`     // Rate over the window, between 0 and 1
+	Threshold float64   `json:"threshold"` // Threshold that was crossed, between 0 and 1
+	Window    string    `json:"window"`    // Period the rate was computed over
+	Time      time.Time `json:"time"`      // Time the crossing was detected
+}
+
+// sample is a snapshot of the proxy counters at a point in time
+type sample struct {
+	at    time.Time
+	stats proxy.Stats
+}
+
+// Alerts watches the proxy counters and reports when the hit ratio or the origin error rate cross their
+// thresholds, and again when they are back within them, so regressions from config changes are caught quickly
+type Alerts struct {
+	stats      func() proxy.Stats
+	thresholds Thresholds
+	client     *http.Client
+	samples    []sample        // Snapshots covering the window, oldest first
+	firing     map[string]bool // Alerts currently firing by name
+}
+
+// NewAlerts creates alerts on the counters returned by stats, e.g. Proxy.Stats
+func NewAlerts(stats func() proxy.Stats, thresholds Thresholds) *Alerts {
+	return &Alerts{
+		stats:      stats,
+		thresholds: thresholds,
+		client:     &http.Client{Timeout: webhookTimeout},
+		firing:     make(map[string]bool),
+	}
+}
+
+// Run checks the thresholds periodically until the context is done
+func (a *Alerts) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(alertCheckInterval, a.thresholds.Window))
+	defer ticker.Stop()
+	a.record(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.record(now)
+			a.check(now)
+		}
+	}
+}
+
+// record takes a snapshot of the counters, dropping the ones no longer needed to cover the window
+func (a *Alerts) record(now time.Time) {
+	a.samples = append(a.samples, sample{at: now, stats: a.stats()})
+	start := now.Add(-a.thresholds.Window)
+	for len(a.samples) > 1 && !a.samples[1].at.After(start) {
+		a.samples = a.samples[1:]
+	}
+}
+
+// check computes the rates over the window and reports the alerts whose state changed;
+// nothing is checked until a whole window was observed
+func (a *Alerts) check(now time.Time) {
+	first, last := a.samples[0], a.samples[len(a.samples)-1]
+	if now.Sub(first.at) < a.thresholds.Window {
+		return
+	}
+
+	if a.thresholds.MinHitRatio > 0 {
+		hits := last.stats.Hits - first.stats.Hits
+		lookups := hits + last.stats.Misses - first.stats.Misses
+		if lookups >= minAlertSamples {
+			ratio := float64(hits) / float64(lookups)
+			a.update(now, "hit_ratio", ratio, a.thresholds.MinHitRatio, ratio < a.thresholds.MinHitRatio)
+		}
+	}
+
+	if a.thresholds.MaxErrorRate > 0 {
+		requests := last.stats.OriginRequests - first.stats.OriginRequests
+		if requests >= minAlertSamples {
+			rate := float64(last.stats.OriginErrors-first.stats.OriginErrors) / float64(requests)
+			a.update(now, "origin_error_rate", rate, a.thresholds.MaxErrorRate, rate > a.thresholds.MaxErrorRate)
+		}
+	}
+}
+
+// update reports the alert when it starts or stops firing
+func (a *Alerts) update(now time.Time, name string, value, threshold float64, crossed bool) {
+	if crossed == a.firing[name] {
+		return
+	}
+	a.firing[name] = crossed
+
+	alert := Alert{Name: name, Status: "resolved", Value: value, Threshold: threshold, Window: a.thresholds.Window.String(), Time: now}
+	if crossed {
+		alert.Status = "firing"
+		log.Printf("ALERT: %s is %.1f%% over the last %s, threshold %.1f%%\n", name, value*100, alert.Window, threshold*100)
+	} else {
+		log.Printf("Alert resolved: %s is %.1f%% over the last %s, threshold %.1f%%\n", name, value*100, alert.Window, threshold*100)
+	}
+	if a.thresholds.Webhook != nil {
+		go a.notify(alert)
+	}
+}
+
+// notify posts the alert to the webhook
+func (a *Alerts) notify(alert Alert) {
+	body, err := json.Marshal(&alert)
+	if err != nil {
+		return
+	}
+	resp, err := a.client.Post(a.thresholds.Webhook.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending alert to webhook: %s\n", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Error sending alert to webhook: status %d\n", resp.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+// Package metrics derives the gauges and rates the proxy publishes from its caches and counters.
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// capacityInterval is how often the cache capacity gauges are refreshed
+const capacityInterval = 10 * time.Second
+
+// CapacityGauges is a snapshot of the cache capacity
+type CapacityGauges struct {
+	Entries              int       `json:"entries"`                // Stored entries, expired ones not yet removed included
+	DiskBytes            int64     `json:"disk_bytes"`             // Bytes of the entries stored on disk
+	MemoryBytes          int64     `json:"memory_bytes"`           // Bytes of the bodies kept in memory
+	EvictionsPerSecond   float64   `json:"evictions_per_second"`   // Entries removed to make room, over the last interval
+	ExpirationsPerSecond float64   `json:"expirations_per_second"` // Entries removed once expired, over the last interval
+	UpdatedAt            time.Time `json:"updated_at"`             // Time of the last refresh
+}
+
+// Capacity keeps the cache capacity gauges, refreshed in the background from the usage counters of the cache,
+// so reading them never walks the cache storage
+type Capacity struct {
+	cache  cache.UsageReporter
+	mu     sync.RWMutex
+	last   cache.Usage    // Usage at the last refresh, to compute the rates
+	gauges CapacityGauges // Gauges computed at the last refresh
+}
+
+// NewCapacity creates the capacity gauges of the cache, refreshed once right away
+func NewCapacity(c cache.UsageReporter) *Capacity {
+	capacity := &Capacity{cache: c}
+	capacity.refresh()
+	return capacity
+}
+
+// Run refreshes the gauges periodically until the context is done
+func (c *Capacity) Run(ctx context.Context) {
+	ticker := time.NewTicker(capacityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// Gauges returns the gauges computed at the last refresh
+func (c *Capacity) Gauges() CapacityGauges {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gauges
+}
+
+// refresh reads the cache usage and derives the rates from the counters since the previous refresh
+func (c *Capacity) refresh() {
+	usage := c.cache.Usage()
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gauges := CapacityGauges{
+		Entries:     usage.Entries,
+		DiskBytes:   usage.DiskBytes,
+		MemoryBytes: usage.MemoryBytes,
+		UpdatedAt:   now,
+	}
+	if elapsed := now.Sub(c.gauges.UpdatedAt).Seconds(); !c.gauges.UpdatedAt.IsZero() && elapsed > 0 {
+		gauges.EvictionsPerSecond = float64(usage.Evictions-c.last.Evictions) / elapsed
+		gauges.ExpirationsPerSecond = float64(usage.Expirations-c.last.Expirations) / elapsed
+	}
+	c.last = usage
+	c.gauges = gauges
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/proxy"
+)
+
+// Push formats
+const (
+	FormatInflux      = "influx"      // InfluxDB line protocol
+	FormatOpenMetrics = "openmetrics" // OpenMetrics text format
+)
+
+// pushTimeout is the time limit for a single push
+const pushTimeout = 10 * time.Second
+
+// Snapshot holds the metrics pushed at once
+type Snapshot struct {
+	Stats    proxy.Stats           // Global answer and origin counters
+	Health   proxy.OriginHealth    // Origin health
+	Routes   []proxy.RouteStats    // Answer counters by route
+	Tenants  []proxy.TenantStats   // Answer counters by tenant, empty without tenants
+	Origin   []proxy.OriginMetrics // Origin histograms by route and status class
+	Capacity *CapacityGauges       // Cache capacity, nil if the cache cannot report it
+}
+
+// Pusher sends the metrics periodically to an endpoint, for environments without a scraping Prometheus
+type Pusher struct {
+	collect  func() Snapshot
+	endpoint *url.URL
+	format   string
+	interval time.Duration
+	client   *http.Client
+}
+
+// NewPusher creates a pusher posting the snapshots returned by collect to the endpoint every interval,
+// in the FormatInflux or FormatOpenMetrics format. Credentials in the endpoint URL are sent with basic auth.
+func NewPusher(collect func() Snapshot, endpoint *url.URL, format string, interval time.Duration) *Pusher {
+	return &Pusher{
+		collect:  collect,
+		endpoint: endpoint,
+		format:   format,
+		interval: interval,
+		client:   &http.Client{Timeout: pushTimeout},
+	}
+}
+
+// Run pushes the metrics periodically until the context is done
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.push(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error pushing metrics: %s\n", err)
+			}
+		}
+	}
+}
+
+// push encodes the current metrics and posts them to the endpoint
+func (p *Pusher) push(ctx context.Context) error {
+	var buf bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+	if p.format == FormatOpenMetrics {
+		writeOpenMetrics(&buf, p.collect())
+		contentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	} else {
+		writeInflux(&buf, p.collect(), time.Now())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeInflux writes the snapshot in the InfluxDB line protocol, with nanosecond timestamps
+func writeInflux(w io.Writer, s Snapshot, now time.Time) {
+	ts := now.UnixNano()
+	fmt.Fprintf(w, "caching_proxy hits=%di,misses=%di,bypasses=%di,pending_writes=%di,origin_requests=%di,origin_errors=%di %d\n",
+		s.Stats.Hits, s.Stats.Misses, s.Stats.Bypasses, s.Stats.PendingWrites, s.Stats.OriginRequests, s.Stats.OriginErrors, ts)
+	fmt.Fprintf(w, "caching_proxy_origin_health healthy=%t,consecutive_failures=%di,stale_served=%di %d\n",
+		s.Health.Healthy, s.Health.ConsecutiveFailures, s.Health.StaleServed, ts)
+	for _, r := range s.Routes {
+		fmt.Fprintf(w, "caching_proxy_route,route=%s hits=%di,misses=%di,bypasses=%di %d\n",
+			influxTag(r.Route), r.Hits, r.Misses, r.Bypasses, ts)
+	}
+	for _, t := range s.Tenants {
+		fmt.Fprintf(w, "caching_proxy_tenant,tenant=%s hits=%di,misses=%di,bypasses=%di %d\n",
+			influxTag(t.Tenant), t.Hits, t.Misses, t.Bypasses, ts)
+	}
+	for _, o := range s.Origin {
+		tags := "route=" + influxTag(o.Route) + ",status_class=" + influxTag(o.StatusClass)
+		writeInfluxHistogram(w, "caching_proxy_origin_latency_seconds", tags, o.Latency, ts)
+		writeInfluxHistogram(w, "caching_proxy_origin_size_bytes", tags, o.Size, ts)
+	}
+	if c := s.Capacity; c != nil {
+		fmt.Fprintf(w, "caching_proxy_cache entries=%di,disk_bytes=%di,memory_bytes=%di,evictions_per_second=%s,expirations_per_second=%s %d\n",
+			c.Entries, c.DiskBytes, c.MemoryBytes, formatFloat(c.EvictionsPerSecond), formatFloat(c.ExpirationsPerSecond), ts)
+	}
+}
+
+// writeInfluxHistogram writes the count and sum of a histogram, and one line per bucket tagged with its bound
+func writeInfluxHistogram(w io.Writer, name, tags string, h proxy.Histogram, ts int64) {
+	fmt.Fprintf(w, "%s,%s count=%di,sum=%s %d\n", name, tags, h.Count, formatFloat(h.Sum), ts)
+	for _, b := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket,%s,le=%s count=%di %d\n", name, tags, formatFloat(b.UpperBound), b.Count, ts)
+	}
+	fmt.Fprintf(w, "%s_bucket,%s,le=+Inf count=%di %d\n", name, tags, h.Count, ts)
+}
+
+// influxTag escapes a tag value of the line protocol
+func influxTag(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// writeOpenMetrics writes the snapshot in the OpenMetrics text format
+func writeOpenMetrics(w io.Writer, s Snapshot) {
+	counters := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"caching_proxy_hits", "Requests answered from the cache.", s.Stats.Hits},
+		{"caching_proxy_misses", "Requests forwarded to the origin for lack of a usable cached copy.", s.Stats.Misses},
+		{"caching_proxy_bypasses", "Requests forwarded without looking at the cache.", s.Stats.Bypasses},
+		{"caching_proxy_origin_requests", "Requests sent to the origin.", s.Stats.OriginRequests},
+		{"caching_proxy_origin_errors", "Origin requests that failed or got a server error.", s.Stats.OriginErrors},
+		{"caching_proxy_stale_served", "Expired copies served because the origin failed.", s.Health.StaleServed},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# TYPE %s counter\n# HELP %s %s\n%s_total %d\n", c.name, c.name, c.help, c.name, c.value)
+	}
+	fmt.Fprintf(w, "# TYPE caching_proxy_pending_writes gauge\n# HELP caching_proxy_pending_writes Cache writes in progress.\n")
+	fmt.Fprintf(w, "caching_proxy_pending_writes %d\n", s.Stats.PendingWrites)
+	healthy := 0
+	if s.Health.Healthy {
+		healthy = 1
+	}
+	fmt.Fprintf(w, "# TYPE caching_proxy_origin_healthy gauge\n# HELP caching_proxy_origin_healthy Whether the last origin request or health check succeeded.\n")
+	fmt.Fprintf(w, "caching_proxy_origin_healthy %d\n", healthy)
+	fmt.Fprintf(w, "# TYPE caching_proxy_origin_consecutive_failures gauge\n# HELP caching_proxy_origin_consecutive_failures Origin requests and health checks failed in a row.\n")
+	fmt.Fprintf(w, "caching_proxy_origin_consecutive_failures %d\n", s.Health.ConsecutiveFailures)
+
+	if len(s.Routes) > 0 {
+		fmt.Fprintf(w, "# TYPE caching_proxy_route_requests counter\n# HELP caching_proxy_route_requests Requests by route and cache result.\n")
+		for _, r := range s.Routes {
+			route := openMetricsLabel(r.Route)
+			fmt.Fprintf(w, "caching_proxy_route_requests_total{route=\"%s\",result=\"hit\"} %d\n", route, r.Hits)
+			fmt.Fprintf(w, "caching_proxy_route_requests_total{route=\"%s\",result=\"miss\"} %d\n", route, r.Misses)
+			fmt.Fprintf(w, "caching_proxy_route_requests_total{route=\"%s\",result=\"bypass\"} %d\n", route, r.Bypasses)
+		}
+	}
+
+	if len(s.Tenants) > 0 {
+		fmt.Fprintf(w, "# TYPE caching_proxy_tenant_requests counter\n# HELP caching_proxy_tenant_requests Requests by tenant and cache result.\n")
+		for _, t := range s.Tenants {
+			tenant := openMetricsLabel(t.Tenant)
+			fmt.Fprintf(w, "caching_proxy_tenant_requests_total{tenant=\"%s\",result=\"hit\"} %d\n", tenant, t.Hits)
+			fmt.Fprintf(w, "caching_proxy_tenant_requests_total{tenant=\"%s\",result=\"miss\"} %d\n", tenant, t.Misses)
+			fmt.Fprintf(w, "caching_proxy_tenant_requests_total{tenant=\"%s\",result=\"bypass\"} %d\n", tenant, t.Bypasses)
+		}
+	}
+
+	if len(s.Origin) > 0 {
+		histograms := []struct {
+			name, help string
+			get        func(proxy.OriginMetrics) proxy.Histogram
+		}{
+			{"caching_proxy_origin_latency_seconds", "Time until the origin response headers arrived.", func(o proxy.OriginMetrics) proxy.Histogram { return o.Latency }},
+			{"caching_proxy_origin_size_bytes", "Size of the origin response bodies.", func(o proxy.OriginMetrics) proxy.Histogram { return o.Size }},
+		}
+		for _, h := range histograms {
+			fmt.Fprintf(w, "# TYPE %s histogram\n# HELP %s %s\n", h.name, h.name, h.help)
+			for _, o := range s.Origin {
+				labels := fmt.Sprintf("route=\"%s\",status_class=\"%s\"", openMetricsLabel(o.Route), openMetricsLabel(o.StatusClass))
+				hist := h.get(o)
+				for _, b := range hist.Buckets {
+					fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, labels, formatFloat(b.UpperBound), b.Count)
+				}
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labels, hist.Count)
+				fmt.Fprintf(w, "%s_count{%s} %d\n%s_sum{%s} %s\n", h.name, labels, hist.Count, h.name, labels, formatFloat(hist.Sum))
+			}
+		}
+	}
+
+	if c := s.Capacity; c != nil {
+		gauges := []struct {
+			name, help string
+			value      string
+		}{
+			{"caching_proxy_cache_entries", "Stored cache entries.", strconv.Itoa(c.Entries)},
+			{"caching_proxy_cache_disk_bytes", "Bytes of the cache entries stored on disk.", strconv.FormatInt(c.DiskBytes, 10)},
+			{"caching_proxy_cache_memory_bytes", "Bytes of the cached bodies kept in memory.", strconv.FormatInt(c.MemoryBytes, 10)},
+			{"caching_proxy_cache_evictions_per_second", "Cache entries removed to make room per second.", formatFloat(c.EvictionsPerSecond)},
+			{"caching_proxy_cache_expirations_per_second", "Expired cache entries removed per second.", formatFloat(c.ExpirationsPerSecond)},
+		}
+		for _, g := range gauges {
+			fmt.Fprintf(w, "# TYPE %s gauge\n# HELP %s %s\n%s %s\n", g.name, g.name, g.help, g.name, g.value)
+		}
+	}
+	fmt.Fprint(w, "# EOF\n")
+}
+
+// openMetricsLabel escapes a label value of the OpenMetrics text format
+func openMetricsLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats a float in decimal notation, in the shortest form that parses back to the same value
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
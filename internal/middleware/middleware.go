@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps an http.Handler with additional behavior
+type Middleware func(http.Handler) http.Handler
+
+// registry maps middleware names usable in the configuration file to their implementations
+var registry = map[string]Middleware{
+	"access-log": AccessLog,
+	"recover":    Recover,
+}
+
+// Chain wraps the handler with the named middlewares, the first name becoming the outermost one
+func Chain(handler http.Handler, names []string) (http.Handler, error) {
+	for i := len(names) - 1; i >= 0; i-- {
+		m, ok := registry[names[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware '%s'", names[i])
+		}
+		handler = m(handler)
+	}
+	return handler, nil
+}
+
+// AccessLog logs the method, URL, status and duration of every request
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+		log.Printf("%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL.String(), sw.Status, time.Since(start))
+	})
+}
+
+// Recover converts panics in the wrapped handler into 500 responses instead of dropping the connection
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("Panic while serving %s: %v", r.URL.String(), err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StatusWriter records the status code written by the wrapped handler
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int // Status code written, 200 until the handler writes another one
+}
+
+// NewStatusWriter wraps the writer to record the status code written to it
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader records the status code and forwards it to the underlying writer
+func (w *StatusWriter) WriteHeader(status int) {
+	w.Status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom forwards to the underlying writer, so bodies served from files keep the sendfile path
+func (w *StatusWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Unwrap returns the underlying writer so http.ResponseController can reach it
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ig-rudenko/caching-proxy/internal/config"
+	"github.com/ig-rudenko/caching-proxy/internal/systemd"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Server serves a handler on a single configured listener
+type Server struct {
+	listener config.Listener // Listener configuration: address, TLS and middlewares
+	srv      *http.Server    // Underlying HTTP server
+	ln       net.Listener    // Opened socket, nil until Listen is called
+}
+
+// New creates a new Server for the given listener configuration and handler
+func New(listener config.Listener, handler http.Handler) *Server {
+	return &Server{listener: listener, srv: &http.Server{Handler: handler}}
+}
+
+// ListenAndServe opens the listener socket and serves requests until the server stops
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen opens the listener socket, or takes the one passed by systemd, without serving it yet
+func (s *Server) Listen() error {
+	network, address := s.listener.Network()
+
+	var err error
+	switch network {
+	case "systemd":
+		s.ln, err = systemd.Listener(address)
+		return err
+	case "unix":
+		// Remove a stale unix socket left by a previous run, otherwise binding fails
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	s.ln, err = net.Listen(network, address)
+	return err
+}
+
+// Serve serves requests on the socket opened by Listen until the server stops
+func (s *Server) Serve() error {
+	var err error
+	if s.listener.TLS != nil {
+		log.Printf("Listening on %s (TLS, %s)\n", s.listener.Address, s.listener.Handler)
+		err = s.srv.ServeTLS(s.ln, s.listener.TLS.CertFile, s.listener.TLS.KeyFile)
+	} else {
+		log.Printf("Listening on %s (%s)\n", s.listener.Address, s.listener.Handler)
+		err = s.srv.Serve(s.ln)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for active requests until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// Group runs several servers at once
+type Group struct {
+	servers []*Server
+}
+
+// NewGroup creates a new Group from the given servers
+func NewGroup(servers ...*Server) *Group {
+	return &Group{servers}
+}
+
+// Add appends a server to the group
+func (g *Group) Add(s *Server) {
+	g.servers = append(g.servers, s)
+}
+
+// ListenAndServe opens the sockets of every server, then serves them, returning the first error
+func (g *Group) ListenAndServe() error {
+	if err := g.Listen(); err != nil {
+		return err
+	}
+	return g.Serve()
+}
+
+// Listen opens the sockets of every server, so they all accept connections once it returns
+func (g *Group) Listen() error {
+	for _, s := range g.servers {
+		if err := s.Listen(); err != nil {
+			return fmt.Errorf("listener %s: %w", s.listener.Address, err)
+		}
+	}
+	return nil
+}
+
+// Serve serves every server opened by Listen in its own goroutine and returns the first error
+func (g *Group) Serve() error {
+	errCh := make(chan error, len(g.servers))
+	for _, s := range g.servers {
+		go func(s *Server) {
+			errCh <- s.Serve()
+		}(s)
+	}
+
+	for range g.servers {
+		if err := <-errCh; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown stops every server of the group at once, returning the first error
+func (g *Group) Shutdown(ctx context.Context) error {
+	errCh := make(chan error, len(g.servers))
+	for _, s := range g.servers {
+		go func(s *Server) {
+			errCh <- s.Shutdown(ctx)
+		}(s)
+	}
+
+	var firstErr error
+	for range g.servers {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,33 @@
+// Package service runs the proxy as a Windows service.
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// Name is the name the service is installed under and its event log source
+const Name = "caching-proxy"
+
+// Command runs a service subcommand: install with the proxy arguments, uninstall, start, stop,
+// or run, used by the service control manager to start the proxy as a service
+func Command(args []string, run func(ctx context.Context)) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: caching-proxy service install|uninstall|start|stop [proxy options]")
+	}
+
+	switch args[0] {
+	case "install":
+		return install(args[1:])
+	case "uninstall":
+		return uninstall()
+	case "start":
+		return start()
+	case "stop":
+		return stop()
+	case "run":
+		return runService(args[1:], run)
+	default:
+		return fmt.Errorf("unknown service command '%s'", args[0])
+	}
+}
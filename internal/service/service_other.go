@@ -0,0 +1,31 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// errUnsupported is returned by every service command outside Windows, where systemd or an init script runs the proxy
+var errUnsupported = errors.New("service mode is only supported on Windows")
+
+func install([]string) error {
+	return errUnsupported
+}
+
+func uninstall() error {
+	return errUnsupported
+}
+
+func start() error {
+	return errUnsupported
+}
+
+func stop() error {
+	return errUnsupported
+}
+
+func runService([]string, func(ctx context.Context)) error {
+	return errUnsupported
+}
@@ -0,0 +1,215 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+)
+
+// Service control manager constants, see winsvc.h
+const (
+	serviceWin32OwnProcess = 0x10
+
+	stateStopped     = 1
+	stateStopPending = 3
+	stateRunning     = 4
+
+	acceptStop     = 0x1
+	acceptShutdown = 0x4
+
+	controlStop        = 1
+	controlInterrogate = 4
+	controlShutdown    = 5
+
+	errorCallNotImplemented = 120
+)
+
+// Event log constants; the messages of EventCreate.exe print the logged text as is for IDs 1 to 1000
+const (
+	eventError       = 0x1
+	eventInformation = 0x4
+	eventID          = 1
+	eventMessageFile = `%SystemRoot%\System32\EventCreate.exe`
+	eventLogKey      = `HKLM\SYSTEM\CurrentControlSet\Services\EventLog\Application\` + Name
+)
+
+// install registers the service, started automatically with the given proxy arguments,
+// and the event log source its messages are written to
+func install(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmdLine := []string{syscall.EscapeArg(exe), "service", "run"}
+	for _, arg := range args {
+		cmdLine = append(cmdLine, syscall.EscapeArg(arg))
+	}
+	if err := command("sc.exe", "create", Name, "binPath=", strings.Join(cmdLine, " "), "start=", "auto", "DisplayName=", "Caching Proxy"); err != nil {
+		return err
+	}
+
+	if err := command("reg.exe", "add", eventLogKey, "/v", "EventMessageFile", "/t", "REG_EXPAND_SZ", "/d", eventMessageFile, "/f"); err != nil {
+		return err
+	}
+	return command("reg.exe", "add", eventLogKey, "/v", "TypesSupported", "/t", "REG_DWORD", "/d", "7", "/f")
+}
+
+// uninstall removes the service and its event log source
+func uninstall() error {
+	if err := command("sc.exe", "delete", Name); err != nil {
+		return err
+	}
+	return command("reg.exe", "delete", eventLogKey, "/f")
+}
+
+// start asks the service control manager to start the service
+func start() error {
+	return command("sc.exe", "start", Name)
+}
+
+// stop asks the service control manager to stop the service, which shuts the proxy down gracefully
+func stop() error {
+	return command("sc.exe", "stop", Name)
+}
+
+// command runs a system tool, returning its output as the error if it fails
+func command(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s", name, args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// serviceStatus is the SERVICE_STATUS structure
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// serviceTableEntry is the SERVICE_TABLE_ENTRYW structure
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// statusHandle is the handle the service reports its state with
+var statusHandle uintptr
+
+// setStatus reports the service state to the service control manager
+func setStatus(state, accepted uint32) {
+	status := serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state, controlsAccepted: accepted}
+	_, _, _ = procSetServiceStatus.Call(statusHandle, uintptr(unsafe.Pointer(&status)))
+}
+
+// runService runs the proxy with the given arguments under the service control manager, logging to the
+// event log; a stop request or a system shutdown cancels the context, shutting the proxy down gracefully
+func runService(args []string, run func(ctx context.Context)) error {
+	// Services start in the system directory, relative paths such as the default ./cache are
+	// resolved against the directory of the executable instead
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(exe)); err != nil {
+		return err
+	}
+	os.Args = append([]string{os.Args[0]}, args...)
+
+	if events, err := openEventLog(); err == nil {
+		log.SetFlags(0)
+		log.SetOutput(events)
+	}
+
+	name, err := syscall.UTF16PtrFromString(Name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := syscall.NewCallback(func(control, _, _, _ uintptr) uintptr {
+		switch control {
+		case controlStop, controlShutdown:
+			setStatus(stateStopPending, 0)
+			cancel()
+		case controlInterrogate:
+		default:
+			return errorCallNotImplemented
+		}
+		return 0
+	})
+	serviceMain := syscall.NewCallback(func(_, _ uintptr) uintptr {
+		statusHandle, _, _ = procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(name)), handler, 0)
+		setStatus(stateRunning, acceptStop|acceptShutdown)
+		run(ctx)
+		setStatus(stateStopped, 0)
+		return 0
+	})
+
+	// The dispatcher returns once the service has stopped
+	table := []serviceTableEntry{{name, serviceMain}, {nil, 0}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("not started by the service control manager: %w", err)
+	}
+	return nil
+}
+
+// eventLog writes log messages to the Windows event log, as errors when they mention one
+type eventLog struct {
+	handle uintptr
+}
+
+// openEventLog opens the event log source registered on install
+func openEventLog() (*eventLog, error) {
+	name, err := syscall.UTF16PtrFromString(Name)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return nil, err
+	}
+	return &eventLog{h}, nil
+}
+
+// Write reports one log message as an event
+func (l *eventLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	kind := eventInformation
+	if strings.Contains(strings.ToLower(msg), "error") {
+		kind = eventError
+	}
+
+	text, err := syscall.UTF16PtrFromString(strings.ReplaceAll(msg, "\x00", ""))
+	if err != nil {
+		return 0, err
+	}
+	if r, _, err := procReportEventW.Call(l.handle, uintptr(kind), 0, eventID, 0, 1, 0, uintptr(unsafe.Pointer(&text)), 0); r == 0 {
+		return 0, err
+	}
+	return len(p), nil
+}
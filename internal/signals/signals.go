@@ -0,0 +1,2 @@
+// Package signals maps the operator signals of the proxy to what the platform supports.
+package signals
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package signals
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifyStats relays SIGUSR1, which asks for a stats dump, to the channel
+func NotifyStats(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+// NotifyToggleCache relays SIGUSR2, which switches between caching and pass-through, to the channel
+func NotifyToggleCache(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package signals
+
+import "os"
+
+// NotifyStats does nothing, Windows has no user signals
+func NotifyStats(chan<- os.Signal) {}
+
+// NotifyToggleCache does nothing, Windows has no user signals
+func NotifyToggleCache(chan<- os.Signal) {}
@@ -0,0 +1,93 @@
+// Package systemd implements the parts of the systemd protocols used by the proxy:
+// socket activation and service state notifications, without linking libsystemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// Service states reported with Notify
+const (
+	Ready     = "READY=1"     // Startup finished, the service accepts connections
+	Reloading = "RELOADING=1" // The service is reloading its configuration
+	Stopping  = "STOPPING=1"  // The service is shutting down
+)
+
+var (
+	inheritOnce sync.Once
+	inherited   []*os.File // Sockets passed by systemd, in order
+	names       []string   // Names of the passed sockets, from FileDescriptorName=
+)
+
+// inherit takes the sockets passed by systemd once, removing the variables describing them,
+// so child processes such as the hook script do not take them as their own
+func inherit() {
+	inheritOnce.Do(func() {
+		defer func() {
+			_ = os.Unsetenv("LISTEN_PID")
+			_ = os.Unsetenv("LISTEN_FDS")
+			_ = os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			return
+		}
+
+		fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < count; i++ {
+			name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+			if i < len(fdNames) && fdNames[i] != "" {
+				name = fdNames[i]
+			}
+			inherited = append(inherited, os.NewFile(uintptr(listenFdsStart+i), name))
+			names = append(names, name)
+		}
+	})
+}
+
+// Listener returns the listening socket passed by systemd with the given name, set by FileDescriptorName=
+// in the socket unit (the unit name by default), or its position among the passed sockets starting at 0
+func Listener(name string) (net.Listener, error) {
+	inherit()
+
+	for i, n := range names {
+		if n == name || strconv.Itoa(i) == name {
+			return net.FileListener(inherited[i])
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("systemd socket '%s': no sockets were passed by systemd", name)
+	}
+	return nil, fmt.Errorf("systemd socket '%s' not found among %s", name, strings.Join(names, ", "))
+}
+
+// Notify reports the service state to systemd; it does nothing when the service is not run
+// with Type=notify, i.e. NOTIFY_SOCKET is not set
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// A name starting with "@" is an abstract socket, which the net package handles by itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
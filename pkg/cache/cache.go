@@ -0,0 +1,145 @@
+// Package cache defines the entries stored by the proxy and the interfaces its cache backends implement.
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Entry is a cached response with its metadata, always stored and loaded as a whole,
+// so readers never see the body of one response with the headers of another
+type Entry struct {
+	Status    int         `json:"status"`     // Response status code
+	Header    http.Header `json:"header"`     // Response headers
+	Body      []byte      `json:"-"`          // Response body, stored apart from the metadata
+	ExpiresAt time.Time   `json:"expires_at"` // Expiration time, zero follows the cache timeout
+	RefreshAt time.Time   `json:"refresh_at"` // Time after which the entry is refreshed in the background, zero never
+	Pinned    bool        `json:"pinned"`     // Whether the entry never expires and is removed only by a purge
+	URL       string      `json:"url"`        // Normalized URL the response was cached for, empty in older entries
+	Tenant    string      `json:"tenant"`     // Tenant the response was cached for, empty without tenants
+	StoredAt  time.Time   `json:"stored_at"`  // Time the entry was stored, set by the cache
+}
+
+// Expired reports whether the entry expired more than grace ago; entries without
+// an expiration time expire timeout after being stored, or never if the timeout is zero
+func (e *Entry) Expired(timeout, grace time.Duration) bool {
+	switch {
+	case e.Pinned:
+		return false
+	case !e.ExpiresAt.IsZero():
+		return time.Since(e.ExpiresAt) > grace
+	default:
+		return timeout > 0 && time.Since(e.StoredAt) > timeout+grace
+	}
+}
+
+// DueAt returns when the entry expires past the grace period, or false if it never does
+func (e *Entry) DueAt(timeout, grace time.Duration) (time.Time, bool) {
+	switch {
+	case e.Pinned:
+		return time.Time{}, false
+	case !e.ExpiresAt.IsZero():
+		return e.ExpiresAt.Add(grace), true
+	case timeout > 0:
+		return e.StoredAt.Add(timeout + grace), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// ErrFull is returned by Set when the cache has no room left and the entry was not stored
+var ErrFull = errors.New("cache is full")
+
+// ErrLocked is returned by Set when another writer holds the key and the entry was not stored
+var ErrLocked = errors.New("entry is being written by another host")
+
+// Cache stores entries by key
+type Cache interface {
+	Get(ctx context.Context, key string) (*Entry, bool)
+	Set(ctx context.Context, key string, entry *Entry) error
+}
+
+// Usage is a snapshot of what a cache holds and how many entries it removed
+type Usage struct {
+	Entries     int    `json:"entries"`      // Stored entries, expired ones not yet removed included
+	DiskBytes   int64  `json:"disk_bytes"`   // Bytes of the entries stored on disk
+	MemoryBytes int64  `json:"memory_bytes"` // Bytes of the bodies kept in memory
+	Evictions   uint64 `json:"evictions"`    // Entries removed to make room since the cache was created
+	Expirations uint64 `json:"expirations"`  // Entries removed once expired since the cache was created
+}
+
+// UsageReporter is implemented by caches able to report their usage cheaply, without walking their storage
+type UsageReporter interface {
+	Usage() Usage
+}
+
+// Collection reports what a garbage collection pass removed
+type Collection struct {
+	Entries   int   `json:"entries"`    // Expired entries removed
+	TempFiles int   `json:"temp_files"` // Leftovers of interrupted writes removed
+	Bytes     int64 `json:"bytes"`      // Bytes reclaimed
+}
+
+// Collector is implemented by caches able to run their cleanup on demand
+type Collector interface {
+	// CollectGarbage removes every expired entry at once, instead of waiting for the periodic cleanup
+	CollectGarbage() Collection
+}
+
+// Reasons a cache removes an entry on its own
+const (
+	RemovalExpired = "expired" // The entry expired
+	RemovalEvicted = "evicted" // The entry was removed to make room
+)
+
+// RemovalFunc is called with the key of every entry a cache removes on its own, and the reason
+type RemovalFunc func(key, reason string)
+
+// RemovalNotifier is implemented by caches able to report the entries they remove on their own
+type RemovalNotifier interface {
+	SetOnRemoval(fn RemovalFunc)
+}
+
+// Purger is implemented by caches able to remove entries by the URL and tenant they were cached for
+type Purger interface {
+	// Purge removes every entry the function matches, given the entry whose body may be left out,
+	// and returns how many were removed
+	Purge(match func(entry *Entry) bool) int
+}
+
+// Clearer is implemented by caches able to remove all their entries at once
+type Clearer interface {
+	// ClearAll removes every entry, reporting the entries it could not remove
+	ClearAll() error
+}
+
+// Refresher is implemented by caches able to renew an entry without storing its body again
+type Refresher interface {
+	// Refresh sets new expiry and refresh times on the entry stored under the key and restarts its age,
+	// reporting false if the entry is gone or cannot be renewed in place
+	Refresh(ctx context.Context, key string, expiresAt, refreshAt time.Time) bool
+}
+
+// StreamSetter is implemented by caches able to store an entry whose body is read from a stream,
+// never holding the whole body in memory
+type StreamSetter interface {
+	// SetStream stores the entry, without a body of its own, with the size bytes read from the body;
+	// a body shorter than size is an error and nothing is stored
+	SetStream(ctx context.Context, key string, entry *Entry, body io.Reader, size int64) error
+}
+
+// Body is a cached body read straight from storage
+type Body interface {
+	io.ReadSeekCloser
+	Size() int64
+}
+
+// Streamer is implemented by caches able to hand out bodies without loading them into memory
+type Streamer interface {
+	// GetStream returns the entry without its body and a reader over the body, which the caller must close.
+	// The reader is nil when the body is already in memory, in which case it is set on the entry.
+	GetStream(ctx context.Context, key string) (*Entry, Body, bool)
+}
@@ -0,0 +1,79 @@
+package filecache
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// diskFullPause is how long writes are skipped after the disk filled up, before they are tried again
+const diskFullPause = time.Minute
+
+// emergencyEvictFraction is the share of the cached bytes removed, oldest entries first, when the disk fills up
+const emergencyEvictFraction = 0.1
+
+// diskFullEvents counts the times the cache disk filled up, published as an expvar metric
+var diskFullEvents = expvar.NewInt("filecache_disk_full")
+
+// writesPaused reports whether writes are skipped because the disk filled up recently
+func (c *Cache) writesPaused() bool {
+	until, _ := c.pausedUntil.Load().(time.Time)
+	return time.Now().Before(until)
+}
+
+// checkDiskFull handles a failed write: when the disk is full, writes are paused, so responses
+// pass through without being stored, and the oldest entries are evicted to make room
+func (c *Cache) checkDiskFull(err error) error {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	c.pausedUntil.Store(time.Now().Add(diskFullPause))
+	diskFullEvents.Add(1)
+
+	freed := c.evictOldest(emergencyEvictFraction)
+	log.Printf("ALERT: cache disk is full, passing responses through for %s; evicted %d bytes of the oldest entries\n", diskFullPause, freed)
+	return fmt.Errorf("%w: %w", cache.ErrFull, err)
+}
+
+// evictOldest removes the oldest entries until the given fraction of the cached bytes is freed,
+// returning the number of bytes freed; pinned entries are kept
+func (c *Cache) evictOldest(fraction float64) int64 {
+	type candidate struct {
+		key      string
+		size     int64
+		storedAt time.Time
+	}
+
+	c.index.mu.RLock()
+	var total int64
+	candidates := make([]candidate, 0, len(c.index.entries))
+	for key, ie := range c.index.entries {
+		total += ie.size
+		if !ie.entry.Pinned {
+			candidates = append(candidates, candidate{key, ie.size, ie.entry.StoredAt})
+		}
+	}
+	c.index.mu.RUnlock()
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return a.storedAt.Compare(b.storedAt)
+	})
+
+	target := int64(float64(total) * fraction)
+	var freed int64
+	for _, cand := range candidates {
+		if freed >= target {
+			break
+		}
+		c.remove(cand.key)
+		c.removed(cand.key, cache.RemovalEvicted)
+		freed += cand.size
+	}
+	return freed
+}
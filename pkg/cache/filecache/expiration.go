@@ -0,0 +1,80 @@
+package filecache
+
+import (
+	"container/heap"
+	"log"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// expiration is an entry due for a cleanup check at a given time
+type expiration struct {
+	dueAt time.Time
+	key   string
+}
+
+// expirationHeap orders expirations by due time, earliest first
+type expirationHeap []expiration
+
+func (h expirationHeap) Len() int           { return len(h) }
+func (h expirationHeap) Less(i, j int) bool { return h[i].dueAt.Before(h[j].dueAt) }
+func (h expirationHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expirationHeap) Push(x any)        { *h = append(*h, x.(expiration)) }
+func (h *expirationHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// schedule queues the entry for removal once it is due; entries that never expire are not queued.
+// An entry may be queued several times, every check looks at its current state.
+func (c *Cache) schedule(key string) {
+	ie, ok := c.lookup(key)
+	if !ok {
+		return
+	}
+	dueAt, ok := ie.entry.DueAt(c.timeout, c.gracePeriod)
+	if !ok {
+		return
+	}
+
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	heap.Push(&c.index.expirations, expiration{dueAt: dueAt, key: key})
+}
+
+// dueExpirations takes the entries whose due time has passed off the queue
+func (c *Cache) dueExpirations() []string {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	var keys []string
+	now := time.Now()
+	for c.index.expirations.Len() > 0 && !c.index.expirations[0].dueAt.After(now) {
+		keys = append(keys, heap.Pop(&c.index.expirations).(expiration).key)
+	}
+	return keys
+}
+
+// expireEntry removes the entry if it has expired, or queues it again if it is still fresh,
+// e.g. because it was stored anew since it was queued
+func (c *Cache) expireEntry(key string) {
+	ie, ok := c.lookup(key)
+	if !ok {
+		return
+	}
+	if !ie.entry.Expired(c.timeout, c.gracePeriod) {
+		c.schedule(key)
+		return
+	}
+	// Another host may have stored the entry anew, its current file is rescheduled when indexed
+	if c.shared && !c.expiredOnDisk(key) {
+		return
+	}
+
+	log.Printf("Removing old file: %s\n", key)
+	c.remove(key)
+	c.removed(key, cache.RemovalExpired)
+}
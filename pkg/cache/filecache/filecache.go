@@ -0,0 +1,320 @@
+// Package filecache implements a proxy cache storing every entry in its own file.
+package filecache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// defaultCleanUpInterval is the cleanup interval used when no global timeout is set
+const defaultCleanUpInterval = time.Minute
+
+// tempSuffix marks files being written, which are renamed into place once complete
+const tempSuffix = ".tmp"
+
+type Cache struct {
+	timeout     time.Duration // Duration before cache entries expire
+	folderPath  string        // Directory where cache files are stored
+	gracePeriod time.Duration // Duration expired entries are kept before removal
+	keepExpired bool          // Never remove expired entries, e.g. when serving a snapshot
+	index       index         // Entries in the cache folder, so lookups do not touch the disk
+	pausedUntil atomic.Value  // Time until which writes are skipped because the disk filled up
+	evictions   atomic.Uint64 // Entries removed to make room
+	expirations atomic.Uint64 // Entries removed once expired
+	onRemoval   cache.RemovalFunc
+
+	tenantQuota  int64            // Bytes every tenant may keep on disk, zero for no limit
+	tenantQuotas map[string]int64 // Quotas of single tenants, overriding tenantQuota
+
+	shared bool   // Whether other hosts use the folder too, see NewShared
+	host   string // Host name put in the temporary file names of a shared folder
+}
+
+// New creates a new Cache instance with the specified timeout and folder path
+func New(timeout time.Duration, folderPath string) *Cache {
+	c := &Cache{timeout: timeout, folderPath: folderPath}
+	c.createCacheDir()
+	c.buildIndex()
+	return c
+}
+
+// SetKeepExpired sets whether expired entries are kept instead of being removed
+func (c *Cache) SetKeepExpired(keep bool) {
+	c.keepExpired = keep
+}
+
+// SetGracePeriod sets how long expired entries are kept on disk, so they can still be served as stale copies
+func (c *Cache) SetGracePeriod(period time.Duration) {
+	c.gracePeriod = period
+}
+
+// SetOnRemoval sets the function called for every entry removed because it expired or to make room
+func (c *Cache) SetOnRemoval(fn cache.RemovalFunc) {
+	c.onRemoval = fn
+}
+
+// removed counts an entry the cache removed on its own and reports it
+func (c *Cache) removed(key, reason string) {
+	if reason == cache.RemovalExpired {
+		c.expirations.Add(1)
+	} else {
+		c.evictions.Add(1)
+	}
+	if c.onRemoval != nil {
+		c.onRemoval(key, reason)
+	}
+}
+
+// Get retrieves the entry for the given key, body included
+func (c *Cache) Get(ctx context.Context, key string) (*cache.Entry, bool) {
+	entry, body, ok := c.GetStream(ctx, key)
+	if !ok || body == nil {
+		return entry, ok
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, false
+	}
+	entry.Body = data
+	return entry, true
+}
+
+// GetStream retrieves the entry for the given key with a reader over its body in the file;
+// preloaded bodies are returned on the entry instead
+func (c *Cache) GetStream(_ context.Context, key string) (*cache.Entry, cache.Body, bool) {
+	ie, file, ok := c.open(key)
+	if !ok {
+		return nil, nil, false
+	}
+	if !c.keepExpired && ie.entry.Expired(c.timeout, c.gracePeriod) {
+		if file != nil {
+			_ = file.Close()
+		}
+		c.remove(key)
+		c.removed(key, cache.RemovalExpired)
+		return nil, nil, false
+	}
+
+	entry := ie.entry
+	if file == nil {
+		entry.Body = ie.body
+		return &entry, nil, true
+	}
+	return &entry, &fileBody{io.NewSectionReader(file, ie.bodyOffset, ie.size), file}, true
+}
+
+// Set stores the entry with the given key, replacing the previous one atomically.
+// While the disk is full nothing is written and the error wraps cache.ErrFull;
+// in a shared folder a key another host is writing is left to it and cache.ErrLocked is returned.
+func (c *Cache) Set(_ context.Context, key string, entry *cache.Entry) error {
+	if c.writesPaused() {
+		return cache.ErrFull
+	}
+	if err := c.write(key, entry); err != nil {
+		return c.checkDiskFull(err)
+	}
+	return nil
+}
+
+// SetStream stores the entry with the size bytes of the body read from the reader, copied straight to disk.
+// While the disk is full nothing is written and the error wraps cache.ErrFull;
+// in a shared folder a key another host is writing is left to it and cache.ErrLocked is returned.
+func (c *Cache) SetStream(_ context.Context, key string, entry *cache.Entry, body io.Reader, size int64) error {
+	if c.writesPaused() {
+		return cache.ErrFull
+	}
+	stored := *entry
+	stored.Body = nil
+	if err := c.writeFrom(key, &stored, body, size); err != nil {
+		return c.checkDiskFull(err)
+	}
+	return nil
+}
+
+// write writes the entry to a temporary file and moves it into place
+func (c *Cache) write(key string, entry *cache.Entry) error {
+	return c.writeFrom(key, entry, bytes.NewReader(entry.Body), int64(len(entry.Body)))
+}
+
+// writeFrom writes the entry with the size bytes of the body read from the reader to a temporary file
+// and moves it into place
+func (c *Cache) writeFrom(key string, entry *cache.Entry, body io.Reader, size int64) error {
+	stored := *entry
+	stored.StoredAt = time.Now()
+	meta, err := json.Marshal(&fileMeta{Entry: stored, BodySize: &size})
+	if err != nil {
+		return err
+	}
+
+	// In a shared folder the host holding the lock writes the key, the others leave it to that host
+	pattern := key + "-*" + tempSuffix
+	if c.shared {
+		if !c.lock(key) {
+			return cache.ErrLocked
+		}
+		defer c.unlock(key)
+		// A long write keeps the lock fresh, so the other hosts do not take it for a crashed writer's
+		defer c.keepLocked(key)()
+		pattern = key + "-" + c.host + "-*" + tempSuffix
+	}
+
+	// The entry is written to a temporary file first, so readers never see it half written;
+	// the name is created exclusively, so two writers never share a file
+	file, err := os.CreateTemp(c.folderPath, pattern)
+	if err != nil {
+		return fmt.Errorf("error adding to cache: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	w := bufio.NewWriter(file)
+	_, _ = w.Write(meta)
+	_ = w.WriteByte('\n')
+	if n, err := io.CopyN(w, body, size); err != nil {
+		_ = file.Close()
+		if err == io.EOF {
+			err = fmt.Errorf("body ended after %d of %d bytes", n, size)
+		}
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := c.commit(key, &stored, int64(len(meta)+1), size, file.Name()); err != nil {
+		return err
+	}
+	c.enforceQuota(stored.Tenant)
+	return nil
+}
+
+// RunCleanUp starts a goroutine for periodic cleanup of old cache files
+func (c *Cache) RunCleanUp() {
+	if c.keepExpired {
+		return
+	}
+	go c.cleanUpOldFiles()
+}
+
+// cleanUpOldFiles periodically removes the entries that are due according to the expiration queue,
+// so each run only touches what has expired instead of walking the whole directory
+func (c *Cache) cleanUpOldFiles() {
+	// Entries may carry their own expiry time, so cleanup runs even without a global timeout
+	interval := c.timeout
+	if interval <= 0 {
+		interval = defaultCleanUpInterval
+	}
+
+	for {
+		for _, key := range c.dueExpirations() {
+			c.expireEntry(key)
+		}
+
+		// Wait before the next cleanup run
+		time.Sleep(interval)
+	}
+}
+
+// remove deletes the file of the entry and drops it from the index
+func (c *Cache) remove(key string) {
+	if err := os.Remove(c.getFilePath(key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing file: %s\n", err)
+	}
+	c.forget(key)
+}
+
+// Purge removes the entries the function matches and returns how many were removed
+func (c *Cache) Purge(match func(entry *cache.Entry) bool) int {
+	var keys []string
+	c.index.mu.RLock()
+	for key, ie := range c.index.entries {
+		if match(&ie.entry) {
+			keys = append(keys, key)
+		}
+	}
+	c.index.mu.RUnlock()
+
+	for _, key := range keys {
+		c.remove(key)
+	}
+	return len(keys)
+}
+
+// ClearAll removes all files and directories in the cache folder, reporting the ones it could not remove
+func (c *Cache) ClearAll() error {
+	c.index.mu.Lock()
+	c.index.entries = make(map[string]*indexEntry)
+	c.index.expirations = nil
+	c.index.diskBytes, c.index.memoryBytes = 0, 0
+	c.index.tenantBytes = nil
+	c.index.mu.Unlock()
+
+	// Get a list of all files and directories in the folder
+	files, err := os.ReadDir(c.folderPath)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	// Iterate over each item and remove it
+	var errs []error
+	for _, file := range files {
+		filePath := filepath.Join(c.folderPath, file.Name())
+		err := os.RemoveAll(filePath) // Remove file or directory recursively
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", filePath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getFilePath generates the file path for the given cache key
+func (c *Cache) getFilePath(key string) string {
+	return c.folderPath + "/" + key
+}
+
+// createCacheDir creates the cache directory with permissions 0755 (read/write for owner, read for group and others)
+func (c *Cache) createCacheDir() {
+	err := os.MkdirAll(c.folderPath, 0755)
+	if err != nil {
+		log.Fatalf("failed to create cache directory: %s\n", err)
+	}
+}
+
+// fileBody reads the body section of an entry file
+type fileBody struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// Close closes the entry file
+func (b *fileBody) Close() error {
+	return b.file.Close()
+}
+
+// CheckWritable reports whether new entries can be written to the cache folder
+func (c *Cache) CheckWritable(context.Context) error {
+	file, err := os.CreateTemp(c.folderPath, "readyz-*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,68 @@
+package filecache
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// tempFileMaxAge is the age past which a temporary file is taken for the leftover of an interrupted write
+// rather than one in progress
+const tempFileMaxAge = time.Hour
+
+// CollectGarbage removes every expired entry at once, with the temporary files left by interrupted writes,
+// and reports what was reclaimed; expired entries are kept if the cache is set to keep them
+func (c *Cache) CollectGarbage() cache.Collection {
+	var collection cache.Collection
+	if !c.keepExpired {
+		type expired struct {
+			key  string
+			size int64
+		}
+		var due []expired
+		c.index.mu.RLock()
+		for key, ie := range c.index.entries {
+			if ie.entry.Expired(c.timeout, c.gracePeriod) {
+				due = append(due, expired{key, ie.bodyOffset + ie.size})
+			}
+		}
+		c.index.mu.RUnlock()
+
+		for _, e := range due {
+			if c.shared && !c.expiredOnDisk(e.key) {
+				continue
+			}
+			c.remove(e.key)
+			c.removed(e.key, cache.RemovalExpired)
+			collection.Entries++
+			collection.Bytes += e.size
+		}
+	}
+
+	files, err := os.ReadDir(c.folderPath)
+	if err != nil {
+		return collection
+	}
+	for _, file := range files {
+		maxAge := tempFileMaxAge
+		if strings.HasSuffix(file.Name(), lockSuffix) {
+			maxAge = lockMaxAge
+		} else if !strings.HasSuffix(file.Name(), tempSuffix) {
+			continue
+		}
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if os.Remove(c.getFilePath(file.Name())) == nil {
+			collection.TempFiles++
+			collection.Bytes += info.Size()
+		}
+	}
+	return collection
+}
@@ -0,0 +1,303 @@
+package filecache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// indexEntry describes a single cache file
+type indexEntry struct {
+	entry      cache.Entry // Entry metadata, without the body
+	bodyOffset int64       // Offset of the body in the file
+	size       int64       // Body size in bytes
+	body       []byte      // Body of a preloaded entry
+}
+
+// fileMeta is the metadata line of an entry file
+type fileMeta struct {
+	cache.Entry
+	BodySize *int64 `json:"body_size"` // Body length, to detect truncated files; nil in files written before it was recorded
+}
+
+// index maps cache keys to their entries, so lookups do not have to touch the disk
+type index struct {
+	mu          sync.RWMutex
+	entries     map[string]*indexEntry
+	expirations expirationHeap   // Entries queued for removal, earliest due first
+	diskBytes   int64            // Size of the indexed entry files
+	memoryBytes int64            // Size of the preloaded bodies
+	tenantBytes map[string]int64 // Size of the entry files by tenant
+}
+
+// account adds the sizes of the entry to the totals, or subtracts them for a negative sign;
+// the caller holds the lock
+func (ix *index) account(ie *indexEntry, sign int64) {
+	ix.diskBytes += sign * (ie.bodyOffset + ie.size)
+	if ie.body != nil {
+		ix.memoryBytes += sign * ie.size
+	}
+	if tenant := ie.entry.Tenant; tenant != "" {
+		if ix.tenantBytes == nil {
+			ix.tenantBytes = make(map[string]int64)
+		}
+		ix.tenantBytes[tenant] += sign * (ie.bodyOffset + ie.size)
+		if ix.tenantBytes[tenant] == 0 {
+			delete(ix.tenantBytes, tenant)
+		}
+	}
+}
+
+// buildIndex scans the cache folder once and indexes the metadata of every entry in it, before traffic is served.
+// Files that are not valid entries, e.g. left by an interrupted write, truncated or of an older cache format,
+// are removed, unless the folder is shared and they may be written by another host right now.
+func (c *Cache) buildIndex() {
+	c.index.entries = make(map[string]*indexEntry)
+
+	files, err := os.ReadDir(c.folderPath)
+	if err != nil {
+		log.Printf("Error reading cache directory: %s\n", err)
+		return
+	}
+	removed, skipped := 0, 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		ie, err := c.readIndexEntry(file.Name())
+		if err != nil {
+			if c.shared {
+				skipped++
+				continue
+			}
+			_ = os.Remove(c.getFilePath(file.Name()))
+			removed++
+			continue
+		}
+		c.index.entries[file.Name()] = ie
+		c.index.account(ie, 1)
+	}
+	if c.shared {
+		log.Printf("Shared cache validated: %d entries indexed, %d files skipped\n", len(c.index.entries), skipped)
+	} else {
+		log.Printf("Cache validated: %d entries indexed, %d invalid files removed\n", len(c.index.entries), removed)
+	}
+
+	// Queue every entry found for removal once it expires
+	for key := range c.index.entries {
+		c.schedule(key)
+	}
+}
+
+// readIndexEntry reads the metadata line of an entry file
+func (c *Cache) readIndexEntry(key string) (*indexEntry, error) {
+	if strings.HasSuffix(key, tempSuffix) || strings.HasSuffix(key, lockSuffix) {
+		return nil, os.ErrInvalid
+	}
+	file, err := os.Open(c.getFilePath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readEntryFile(file)
+}
+
+// readEntryFile reads the metadata line of an opened entry file, leaving the file open
+func readEntryFile(file *os.File) (*indexEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	meta, err := bufio.NewReader(io.NewSectionReader(file, 0, info.Size())).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var fm fileMeta
+	if err := json.Unmarshal(meta, &fm); err != nil {
+		return nil, err
+	}
+	size := info.Size() - int64(len(meta))
+	if err := validate(&fm, size); err != nil {
+		return nil, err
+	}
+	return &indexEntry{entry: fm.Entry, bodyOffset: int64(len(meta)), size: size}, nil
+}
+
+// ReadEntry reads the metadata of the entry stored under the key in the cache folder, with its body size,
+// without indexing or modifying the folder, e.g. to inspect the cache of a running proxy
+func ReadEntry(folderPath, key string) (*cache.Entry, int64, error) {
+	c := &Cache{folderPath: folderPath}
+	ie, err := c.readIndexEntry(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &ie.entry, ie.size, nil
+}
+
+// WalkEntries calls the function with the key, metadata and body size of every valid entry in the cache folder,
+// without indexing or modifying the folder; invalid files are skipped
+func WalkEntries(folderPath string, fn func(key string, entry *cache.Entry, size int64)) error {
+	files, err := os.ReadDir(folderPath)
+	if err != nil {
+		return err
+	}
+	c := &Cache{folderPath: folderPath}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if ie, err := c.readIndexEntry(file.Name()); err == nil {
+			fn(file.Name(), &ie.entry, ie.size)
+		}
+	}
+	return nil
+}
+
+// validate checks the metadata of an entry file against the body found after it
+func validate(fm *fileMeta, size int64) error {
+	switch {
+	case fm.Status < 100 || fm.Status > 599:
+		return fmt.Errorf("invalid status %d", fm.Status)
+	case fm.StoredAt.IsZero():
+		return errors.New("missing store time")
+	case fm.BodySize != nil && *fm.BodySize != size:
+		return fmt.Errorf("body of %d bytes, expected %d", size, *fm.BodySize)
+	}
+	return nil
+}
+
+// Preload loads the bodies of the most recently stored entries into memory, up to limit bytes in total;
+// bodies that do not fit are skipped, so smaller ones may still be loaded
+func (c *Cache) Preload(limit int64) {
+	// Other hosts may replace the files of a shared folder, the preloaded bodies would go stale
+	if c.shared {
+		log.Println("Preloading skipped, the cache folder is shared")
+		return
+	}
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	keys := make([]string, 0, len(c.index.entries))
+	for key := range c.index.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return c.index.entries[b].entry.StoredAt.Compare(c.index.entries[a].entry.StoredAt)
+	})
+
+	var loaded, total int64
+	for _, key := range keys {
+		ie := c.index.entries[key]
+		if total+ie.size > limit {
+			continue
+		}
+		data, err := os.ReadFile(c.getFilePath(key))
+		if err != nil || int64(len(data)) != ie.bodyOffset+ie.size {
+			continue
+		}
+		ie.body = data[ie.bodyOffset:]
+		c.index.memoryBytes += ie.size
+		total += ie.size
+		loaded++
+	}
+	log.Printf("Preloaded %d cache entries (%d bytes) into memory\n", loaded, total)
+}
+
+// lookup returns the index entry of the key. Files written to the folder by other processes
+// are only seen after a restart.
+func (c *Cache) lookup(key string) (*indexEntry, bool) {
+	c.index.mu.RLock()
+	defer c.index.mu.RUnlock()
+	ie, ok := c.index.entries[key]
+	return ie, ok
+}
+
+// Len returns the number of entries in the cache folder, expired ones not yet removed included
+func (c *Cache) Len() int {
+	c.index.mu.RLock()
+	defer c.index.mu.RUnlock()
+	return len(c.index.entries)
+}
+
+// open returns the index entry of the key with its file opened, or a nil file if the body is preloaded.
+// Both are taken under the index lock, so the file always matches the entry; in a shared folder the entry
+// is read from the file instead, which other hosts may have replaced.
+func (c *Cache) open(key string) (*indexEntry, *os.File, bool) {
+	if c.shared {
+		return c.openShared(key)
+	}
+	c.index.mu.RLock()
+	defer c.index.mu.RUnlock()
+
+	ie, ok := c.index.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if ie.body != nil {
+		return ie, nil, true
+	}
+	file, err := os.Open(c.getFilePath(key))
+	if err != nil {
+		return nil, nil, false
+	}
+	return ie, file, true
+}
+
+// commit moves the written temporary file into place and indexes the entry in one step,
+// keeping its body in memory if the previous one was preloaded and the new one came with its body
+func (c *Cache) commit(key string, entry *cache.Entry, bodyOffset, size int64, tempPath string) error {
+	ie := &indexEntry{entry: *entry, bodyOffset: bodyOffset, size: size}
+	ie.entry.Body = nil
+
+	c.index.mu.Lock()
+	if err := os.Rename(tempPath, c.getFilePath(key)); err != nil {
+		c.index.mu.Unlock()
+		return err
+	}
+	if old, ok := c.index.entries[key]; ok {
+		if old.body != nil {
+			ie.body = entry.Body
+		}
+		c.index.account(old, -1)
+	}
+	c.index.entries[key] = ie
+	c.index.account(ie, 1)
+	c.index.mu.Unlock()
+
+	c.schedule(key)
+	return nil
+}
+
+// forget removes the key from the index
+func (c *Cache) forget(key string) {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	if ie, ok := c.index.entries[key]; ok {
+		c.index.account(ie, -1)
+		delete(c.index.entries, key)
+	}
+}
+
+// Usage returns the number and size of the cached entries, kept up to date as entries come and go,
+// with the number of entries evicted and expired so far
+func (c *Cache) Usage() cache.Usage {
+	c.index.mu.RLock()
+	defer c.index.mu.RUnlock()
+	return cache.Usage{
+		Entries:     len(c.index.entries),
+		DiskBytes:   c.index.diskBytes,
+		MemoryBytes: c.index.memoryBytes,
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
+}
@@ -0,0 +1,88 @@
+package filecache
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// SetTenantQuotas limits the bytes every tenant may keep on disk: the quota applies to every tenant
+// without an override of its own, zero meaning no limit. A tenant over its quota evicts its own oldest
+// entries, so one tenant filling the cache never evicts another. Entries without a tenant are not limited.
+func (c *Cache) SetTenantQuotas(quota int64, overrides map[string]int64) {
+	c.tenantQuota = quota
+	c.tenantQuotas = make(map[string]int64, len(overrides))
+	for tenant, q := range overrides {
+		c.tenantQuotas[strings.ToLower(tenant)] = q
+	}
+}
+
+// quotaOf returns the quota of the tenant, zero if it is not limited
+func (c *Cache) quotaOf(tenant string) int64 {
+	if tenant == "" {
+		return 0
+	}
+	if q, ok := c.tenantQuotas[tenant]; ok {
+		return q
+	}
+	return c.tenantQuota
+}
+
+// TenantUsage returns the bytes every tenant keeps on disk
+func (c *Cache) TenantUsage() map[string]int64 {
+	c.index.mu.RLock()
+	defer c.index.mu.RUnlock()
+	return maps.Clone(c.index.tenantBytes)
+}
+
+// enforceQuota evicts the oldest entries of the tenant until it fits its quota again; pinned entries are kept
+func (c *Cache) enforceQuota(tenant string) {
+	quota := c.quotaOf(tenant)
+	if quota <= 0 {
+		return
+	}
+
+	type candidate struct {
+		key      string
+		size     int64
+		storedAt time.Time
+	}
+
+	c.index.mu.RLock()
+	excess := c.index.tenantBytes[tenant] - quota
+	var candidates []candidate
+	if excess > 0 {
+		for key, ie := range c.index.entries {
+			if ie.entry.Tenant == tenant && !ie.entry.Pinned {
+				candidates = append(candidates, candidate{key, ie.bodyOffset + ie.size, ie.entry.StoredAt})
+			}
+		}
+	}
+	c.index.mu.RUnlock()
+	if excess <= 0 {
+		return
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return a.storedAt.Compare(b.storedAt)
+	})
+
+	var freed int64
+	evicted := 0
+	for _, cand := range candidates {
+		if freed >= excess {
+			break
+		}
+		c.remove(cand.key)
+		c.removed(cand.key, cache.RemovalEvicted)
+		freed += cand.size
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("Tenant %s over its quota of %d bytes, evicted %d entries (%d bytes)\n", tenant, quota, evicted, freed)
+	}
+}
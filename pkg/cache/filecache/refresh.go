@@ -0,0 +1,62 @@
+package filecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Refresh sets new expiry and refresh times on the stored entry by rewriting the metadata line of its file
+// in place, leaving the body untouched. The line is padded to its former length; if the new metadata
+// does not fit or the folder is shared, where files are only ever replaced whole, false is returned and
+// the entry must be stored anew.
+func (c *Cache) Refresh(_ context.Context, key string, expiresAt, refreshAt time.Time) bool {
+	if c.shared {
+		return false
+	}
+	c.index.mu.Lock()
+	ie, ok := c.index.entries[key]
+	if !ok {
+		c.index.mu.Unlock()
+		return false
+	}
+
+	renewed := *ie
+	renewed.entry.ExpiresAt, renewed.entry.RefreshAt, renewed.entry.StoredAt = expiresAt, refreshAt, time.Now()
+	meta, err := json.Marshal(&fileMeta{Entry: renewed.entry, BodySize: &renewed.size})
+	// The line ends with a newline right before the body
+	lineLength := int(renewed.bodyOffset) - 1
+	if err != nil || len(meta) > lineLength {
+		c.index.mu.Unlock()
+		return false
+	}
+	meta = append(meta, bytes.Repeat([]byte{' '}, lineLength-len(meta))...)
+
+	// Bodies are read at their offset, never through the metadata line, so readers are not disturbed;
+	// the file is replaced by renames under the same lock, so it belongs to the indexed entry
+	if err := writeAt(c.getFilePath(key), meta); err != nil {
+		c.index.mu.Unlock()
+		return false
+	}
+	// Readers may hold the indexed entry, so it is replaced rather than modified
+	c.index.entries[key] = &renewed
+	c.index.mu.Unlock()
+
+	c.schedule(key)
+	return true
+}
+
+// writeAt overwrites the start of the file with the data
+func writeAt(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
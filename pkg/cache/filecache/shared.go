@@ -0,0 +1,141 @@
+package filecache
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// lockSuffix marks the lock files taken by the host writing an entry into a shared folder
+const lockSuffix = ".lock"
+
+// lockMaxAge is the age past which a lock file is taken for the leftover of a crashed writer and broken
+const lockMaxAge = 2 * time.Minute
+
+// sharedReadAttempts is how many times an entry file of a shared folder is read before it is given up:
+// over NFS a file just replaced by another host may be stale or not fully visible for a moment
+const sharedReadAttempts = 3
+
+// sharedRetryDelay is the pause between the reads of an entry file of a shared folder
+const sharedRetryDelay = 50 * time.Millisecond
+
+// NewShared creates a new Cache instance for a folder shared by several hosts, e.g. mounted over NFS.
+// Entry files are read anew on every lookup instead of being trusted from the index, so entries written,
+// replaced or removed by the other hosts are seen; each key is written under a lock file, temporary files
+// carry the host name, and entries are only removed as expired after their current file is checked.
+// Refreshes never rewrite files in place and bodies are never preloaded.
+func NewShared(timeout time.Duration, folderPath string) *Cache {
+	c := &Cache{timeout: timeout, folderPath: folderPath, shared: true}
+	c.host, _ = os.Hostname()
+	c.host = strings.NewReplacer("/", "_", "-", "_").Replace(c.host)
+	c.createCacheDir()
+	c.buildIndex()
+	return c
+}
+
+// openShared opens the entry file of the key in a shared folder and indexes the metadata found in it,
+// retrying while the file looks inconsistent, so close-to-open delays are tolerated
+func (c *Cache) openShared(key string) (*indexEntry, *os.File, bool) {
+	for attempt := 1; ; attempt++ {
+		file, err := os.Open(c.getFilePath(key))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed by another host
+			c.forget(key)
+			return nil, nil, false
+		}
+		if err == nil {
+			ie, err := readEntryFile(file)
+			if err == nil {
+				c.track(key, ie)
+				return ie, file, true
+			}
+			_ = file.Close()
+		}
+		if attempt >= sharedReadAttempts {
+			return nil, nil, false
+		}
+		time.Sleep(sharedRetryDelay)
+	}
+}
+
+// track indexes the entry read from the shared folder, replacing the indexed one if another host stored it anew
+func (c *Cache) track(key string, ie *indexEntry) {
+	c.index.mu.Lock()
+	old, ok := c.index.entries[key]
+	if ok && old.entry.StoredAt.Equal(ie.entry.StoredAt) && old.bodyOffset == ie.bodyOffset && old.size == ie.size {
+		c.index.mu.Unlock()
+		return
+	}
+	if ok {
+		c.index.account(old, -1)
+	}
+	c.index.entries[key] = ie
+	c.index.account(ie, 1)
+	c.index.mu.Unlock()
+
+	c.schedule(key)
+}
+
+// expiredOnDisk checks the current file of an entry in a shared folder before it is removed as expired,
+// since another host may have stored it anew; the index is updated with what was found
+func (c *Cache) expiredOnDisk(key string) bool {
+	ie, file, ok := c.openShared(key)
+	if !ok {
+		return false
+	}
+	_ = file.Close()
+	return ie.entry.Expired(c.timeout, c.gracePeriod)
+}
+
+// lock takes the lock file of the key in a shared folder, breaking a lock left by a crashed writer.
+// It reports false if another host is writing the key right now.
+func (c *Cache) lock(key string) bool {
+	path := c.getFilePath(key) + lockSuffix
+	for range 2 {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, _ = file.WriteString(c.host)
+			_ = file.Close()
+			return true
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return false
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			// Released in the meantime
+			continue
+		}
+		if time.Since(info.ModTime()) < lockMaxAge {
+			return false
+		}
+		_ = os.Remove(path)
+	}
+	return false
+}
+
+// keepLocked touches the lock file of the key periodically until the returned function is called
+func (c *Cache) keepLocked(key string) (stop func()) {
+	path := c.getFilePath(key) + lockSuffix
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockMaxAge / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				_ = os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// unlock releases the lock file of the key
+func (c *Cache) unlock(key string) {
+	_ = os.Remove(c.getFilePath(key) + lockSuffix)
+}
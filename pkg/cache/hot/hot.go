@@ -0,0 +1,309 @@
+// Package hot fronts a proxy cache with an in-memory copy of its most requested entries.
+package hot
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// promoteInterval is how often the hottest entries are recomputed
+const promoteInterval = 10 * time.Second
+
+// Backend is the cache fronted by the in-memory layer
+type Backend interface {
+	cache.Cache
+	cache.Clearer
+	RunCleanUp()
+	SetGracePeriod(time.Duration)
+	SetKeepExpired(bool)
+}
+
+// Cache keeps the most requested entries of the backend in memory, so hits on them never touch the backend
+type Cache struct {
+	Backend                             // Cache holding every entry
+	timeout     time.Duration           // Duration before entries without their own expiry time expire
+	gracePeriod time.Duration           // Duration expired entries are still served
+	keepExpired bool                    // Never drop expired entries
+	limit       int                     // Maximum number of entries kept in memory
+	mu          sync.RWMutex            // Guards hits, hot, streamed and onRemoval
+	hits        map[string]int          // Decaying hit counts by key
+	hot         map[string]*cache.Entry // Copies of the hottest entries by key
+	streamed    map[string]struct{}     // Keys stored from a stream, too large to be copied into memory
+	onRemoval   cache.RemovalFunc       // Called for the entries the backend removes on its own
+}
+
+// New creates a Cache keeping up to limit of the most requested backend entries in memory;
+// the timeout must be the one of the backend
+func New(backend Backend, limit int, timeout time.Duration) *Cache {
+	c := &Cache{
+		Backend:  backend,
+		timeout:  timeout,
+		limit:    limit,
+		hits:     make(map[string]int),
+		hot:      make(map[string]*cache.Entry),
+		streamed: make(map[string]struct{}),
+	}
+	// The entries the backend expires or evicts are forgotten in memory as well
+	if notifier, ok := backend.(cache.RemovalNotifier); ok {
+		notifier.SetOnRemoval(c.removed)
+	}
+	return c
+}
+
+// SetOnRemoval sets the function called for every entry the backend removes because it expired or to make room;
+// it is never called if the backend cannot report its removals
+func (c *Cache) SetOnRemoval(fn cache.RemovalFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemoval = fn
+}
+
+// removed drops the in-memory state of an entry the backend removed on its own and reports it
+func (c *Cache) removed(key, reason string) {
+	c.mu.Lock()
+	delete(c.hot, key)
+	delete(c.streamed, key)
+	fn := c.onRemoval
+	c.mu.Unlock()
+
+	if fn != nil {
+		fn(key, reason)
+	}
+}
+
+// SetKeepExpired sets whether expired entries are kept instead of being removed
+func (c *Cache) SetKeepExpired(keep bool) {
+	c.Backend.SetKeepExpired(keep)
+	c.keepExpired = keep
+}
+
+// SetGracePeriod sets how long expired entries are kept, so they can still be served as stale copies
+func (c *Cache) SetGracePeriod(period time.Duration) {
+	c.Backend.SetGracePeriod(period)
+	c.gracePeriod = period
+}
+
+// Get retrieves the entry for the given key, from memory if it is hot, counting the lookup as a hit
+func (c *Cache) Get(ctx context.Context, key string) (*cache.Entry, bool) {
+	if entry, ok := c.getHot(key); ok {
+		return entry, true
+	}
+	return c.Backend.Get(ctx, key)
+}
+
+// GetStream retrieves the entry for the given key; hot entries come with their body in memory,
+// others are streamed by the backend if it supports it
+func (c *Cache) GetStream(ctx context.Context, key string) (*cache.Entry, cache.Body, bool) {
+	if entry, ok := c.getHot(key); ok {
+		return entry, nil, true
+	}
+	if streamer, ok := c.Backend.(cache.Streamer); ok {
+		return streamer.GetStream(ctx, key)
+	}
+	entry, ok := c.Backend.Get(ctx, key)
+	return entry, nil, ok
+}
+
+// Set stores the entry in the backend, updating the in-memory copy of a hot entry
+func (c *Cache) Set(ctx context.Context, key string, entry *cache.Entry) error {
+	if err := c.Backend.Set(ctx, key, entry); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.streamed, key)
+	if _, ok := c.hot[key]; ok {
+		stored := *entry
+		stored.StoredAt = time.Now()
+		c.hot[key] = &stored
+	}
+	return nil
+}
+
+// SetStream stores the entry read from the stream in the backend, if it supports it; such entries are
+// too large to be copied into memory and are never promoted
+func (c *Cache) SetStream(ctx context.Context, key string, entry *cache.Entry, body io.Reader, size int64) error {
+	setter, ok := c.Backend.(cache.StreamSetter)
+	if !ok {
+		return errors.New("cache backend cannot store streamed bodies")
+	}
+	if err := setter.SetStream(ctx, key, entry, body, size); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hot, key)
+	c.streamed[key] = struct{}{}
+	return nil
+}
+
+// Refresh renews the entry in the backend, if it supports it, and its in-memory copy
+func (c *Cache) Refresh(ctx context.Context, key string, expiresAt, refreshAt time.Time) bool {
+	refresher, ok := c.Backend.(cache.Refresher)
+	if !ok || !refresher.Refresh(ctx, key, expiresAt, refreshAt) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.hot[key]; ok {
+		renewed := *entry
+		renewed.ExpiresAt, renewed.RefreshAt, renewed.StoredAt = expiresAt, refreshAt, time.Now()
+		c.hot[key] = &renewed
+	}
+	return true
+}
+
+// ClearAll removes all entries from the backend and from memory
+func (c *Cache) ClearAll() error {
+	c.mu.Lock()
+	c.hits = make(map[string]int)
+	c.hot = make(map[string]*cache.Entry)
+	c.streamed = make(map[string]struct{})
+	c.mu.Unlock()
+
+	return c.Backend.ClearAll()
+}
+
+// Purge removes the matching entries from memory and from the backend, if it supports purging,
+// returning how many the backend removed
+func (c *Cache) Purge(match func(entry *cache.Entry) bool) int {
+	c.mu.Lock()
+	for key, entry := range c.hot {
+		if match(entry) {
+			delete(c.hot, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if purger, ok := c.Backend.(cache.Purger); ok {
+		return purger.Purge(match)
+	}
+	return 0
+}
+
+// CollectGarbage drops the expired in-memory copies and runs the cleanup of the backend, if it supports it,
+// reporting what the backend reclaimed
+func (c *Cache) CollectGarbage() cache.Collection {
+	if !c.keepExpired {
+		c.mu.Lock()
+		for key, entry := range c.hot {
+			if entry.Expired(c.timeout, c.gracePeriod) {
+				delete(c.hot, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	if collector, ok := c.Backend.(cache.Collector); ok {
+		return collector.CollectGarbage()
+	}
+	return cache.Collection{}
+}
+
+// RunCleanUp starts the backend cleanup and the periodic promotion of the hottest entries
+func (c *Cache) RunCleanUp() {
+	c.Backend.RunCleanUp()
+	go func() {
+		for {
+			time.Sleep(promoteInterval)
+			c.promote()
+		}
+	}()
+}
+
+// Usage returns the usage of the backend, if it can tell, with the bodies of the hot entries
+// added to the bytes in memory
+func (c *Cache) Usage() cache.Usage {
+	var usage cache.Usage
+	if reporter, ok := c.Backend.(cache.UsageReporter); ok {
+		usage = reporter.Usage()
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.hot {
+		usage.MemoryBytes += int64(len(entry.Body))
+	}
+	return usage
+}
+
+// getHot counts a hit on the key and returns its in-memory copy, if the entry is hot and still fresh
+func (c *Cache) getHot(key string) (*cache.Entry, bool) {
+	c.mu.Lock()
+	c.hits[key]++
+	entry, ok := c.hot[key]
+	c.mu.Unlock()
+
+	if !ok || !c.keepExpired && entry.Expired(c.timeout, c.gracePeriod) {
+		return nil, false
+	}
+	copied := *entry
+	return &copied, true
+}
+
+// promote moves the most requested entries into memory and drops the ones that cooled down,
+// then halves the hit counts so older hits weigh less
+func (c *Cache) promote() {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.hits))
+	for key := range c.hits {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Compare(c.hits[b], c.hits[a])
+	})
+	keys = keys[:min(len(keys), c.limit)]
+
+	hottest := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		hottest[key] = struct{}{}
+	}
+	var promoted []string
+	for key := range hottest {
+		_, ok := c.hot[key]
+		_, large := c.streamed[key]
+		if !ok && !large {
+			promoted = append(promoted, key)
+		}
+	}
+	demoted := 0
+	for key := range c.hot {
+		if _, ok := hottest[key]; !ok {
+			delete(c.hot, key)
+			demoted++
+		}
+	}
+
+	for key, hits := range c.hits {
+		if hits /= 2; hits > 0 {
+			c.hits[key] = hits
+		} else {
+			delete(c.hits, key)
+		}
+	}
+	c.mu.Unlock()
+
+	// The backend is read without the lock, so lookups are not blocked meanwhile
+	for _, key := range promoted {
+		entry, ok := c.Backend.Get(context.Background(), key)
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		c.hot[key] = entry
+		c.mu.Unlock()
+	}
+	if len(promoted) > 0 || demoted > 0 {
+		log.Printf("Hot entries: %d promoted to memory, %d demoted\n", len(promoted), demoted)
+	}
+}
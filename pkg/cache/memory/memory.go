@@ -0,0 +1,262 @@
+// Package memory implements a proxy cache kept entirely in memory.
+package memory
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// defaultCleanUpInterval is the cleanup interval used when no global timeout is set
+const defaultCleanUpInterval = time.Minute
+
+// shardCount is the number of independently locked shards, a power of two
+const shardCount = 64
+
+// shard holds a subset of entries under its own lock
+type shard struct {
+	mu      sync.RWMutex            // Guards entries and bytes
+	entries map[string]*cache.Entry // Stored entries by key
+	bytes   int64                   // Size of the stored bodies
+}
+
+type Cache struct {
+	timeout     time.Duration     // Duration before cache entries expire
+	gracePeriod time.Duration     // Duration expired entries are kept before removal
+	keepExpired bool              // Never remove expired entries
+	shards      [shardCount]shard // Stored entries, spread by key so requests rarely share a lock
+	expirations atomic.Uint64     // Entries removed once expired
+	onRemoval   cache.RemovalFunc // Called for every expired entry removed
+}
+
+// New creates a new in-memory Cache instance with the specified timeout
+func New(timeout time.Duration) *Cache {
+	c := &Cache{timeout: timeout}
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]*cache.Entry)
+	}
+	return c
+}
+
+// shardFor returns the shard holding the key
+func (c *Cache) shardFor(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &c.shards[h.Sum32()&(shardCount-1)]
+}
+
+// SetKeepExpired sets whether expired entries are kept instead of being removed
+func (c *Cache) SetKeepExpired(keep bool) {
+	c.keepExpired = keep
+}
+
+// SetGracePeriod sets how long expired entries are kept, so they can still be served as stale copies
+func (c *Cache) SetGracePeriod(period time.Duration) {
+	c.gracePeriod = period
+}
+
+// SetOnRemoval sets the function called for every entry removed because it expired
+func (c *Cache) SetOnRemoval(fn cache.RemovalFunc) {
+	c.onRemoval = fn
+}
+
+// expired counts an expired entry removed and reports it
+func (c *Cache) expired(key string) {
+	c.expirations.Add(1)
+	if c.onRemoval != nil {
+		c.onRemoval(key, cache.RemovalExpired)
+	}
+}
+
+// Get retrieves the entry for the given key, removing it once it has expired
+func (c *Cache) Get(_ context.Context, key string) (*cache.Entry, bool) {
+	s := c.shardFor(key)
+	s.mu.RLock()
+	entry, ok := s.entries[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if !c.keepExpired && entry.Expired(c.timeout, c.gracePeriod) {
+		c.Delete(key)
+		c.expired(key)
+		return nil, false
+	}
+	// Entries are never modified once stored, a shallow copy keeps callers from replacing their fields
+	copied := *entry
+	return &copied, true
+}
+
+// Set stores the entry with the given key
+func (c *Cache) Set(_ context.Context, key string, entry *cache.Entry) error {
+	stored := *entry
+	stored.StoredAt = time.Now()
+
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.entries[key]; ok {
+		s.bytes -= int64(len(old.Body))
+	}
+	s.entries[key] = &stored
+	s.bytes += int64(len(stored.Body))
+	return nil
+}
+
+// Refresh sets new expiry and refresh times on the stored entry, keeping its body
+func (c *Cache) Refresh(_ context.Context, key string, expiresAt, refreshAt time.Time) bool {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	// Stored entries are never modified, readers may hold them
+	renewed := *entry
+	renewed.ExpiresAt, renewed.RefreshAt, renewed.StoredAt = expiresAt, refreshAt, time.Now()
+	s.entries[key] = &renewed
+	return true
+}
+
+// Delete removes the entry stored with the given key
+func (c *Cache) Delete(key string) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		s.bytes -= int64(len(entry.Body))
+		delete(s.entries, key)
+	}
+}
+
+// Purge removes the entries the function matches and returns how many were removed
+func (c *Cache) Purge(match func(entry *cache.Entry) bool) int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if match(entry) {
+				s.bytes -= int64(len(entry.Body))
+				delete(s.entries, key)
+				n++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Len returns the number of stored entries, expired ones not yet cleaned up included
+func (c *Cache) Len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Usage returns the number and size of the stored entries, kept up to date as entries come and go,
+// with the number of entries expired so far; the memory cache never evicts
+func (c *Cache) Usage() cache.Usage {
+	usage := cache.Usage{Expirations: c.expirations.Load()}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		usage.Entries += len(s.entries)
+		usage.MemoryBytes += s.bytes
+		s.mu.RUnlock()
+	}
+	return usage
+}
+
+// RunCleanUp starts a goroutine for periodic cleanup of expired entries
+func (c *Cache) RunCleanUp() {
+	if c.keepExpired {
+		return
+	}
+	go c.cleanUpExpired()
+}
+
+// cleanUpExpired periodically removes expired entries
+func (c *Cache) cleanUpExpired() {
+	interval := c.timeout
+	if interval <= 0 {
+		interval = defaultCleanUpInterval
+	}
+
+	for {
+		removed := 0
+		for i := range c.shards {
+			keys, _ := c.cleanUpShard(&c.shards[i])
+			for _, key := range keys {
+				c.expired(key)
+			}
+			removed += len(keys)
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired entries from memory\n", removed)
+		}
+
+		// Wait before the next cleanup run
+		time.Sleep(interval)
+	}
+}
+
+// CollectGarbage removes every expired entry at once and reports what was reclaimed;
+// expired entries are kept if the cache is set to keep them
+func (c *Cache) CollectGarbage() cache.Collection {
+	var collection cache.Collection
+	if c.keepExpired {
+		return collection
+	}
+	for i := range c.shards {
+		keys, bytes := c.cleanUpShard(&c.shards[i])
+		for _, key := range keys {
+			c.expired(key)
+		}
+		collection.Entries += len(keys)
+		collection.Bytes += bytes
+	}
+	return collection
+}
+
+// cleanUpShard removes the expired entries of the shard and returns their keys with the bytes freed
+func (c *Cache) cleanUpShard(s *shard) ([]string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []string
+	var freed int64
+	for key, entry := range s.entries {
+		if entry.Expired(c.timeout, c.gracePeriod) {
+			freed += int64(len(entry.Body))
+			delete(s.entries, key)
+			removed = append(removed, key)
+		}
+	}
+	s.bytes -= freed
+	return removed, freed
+}
+
+// ClearAll removes all entries from memory, it never fails
+func (c *Cache) ClearAll() error {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.entries = make(map[string]*cache.Entry)
+		s.bytes = 0
+		s.mu.Unlock()
+	}
+	return nil
+}
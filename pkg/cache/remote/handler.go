@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// handler serves a cache over the sidecar protocol
+type handler struct {
+	cache cache.Cache
+	token string // Bearer token every request must carry
+}
+
+// NewHandler serves the cache over the sidecar protocol, so a Cache of another instance can read and store
+// its entries, e.g. a primary pushing the entries it stores to its replicas. Only requests carrying the token
+// as a bearer token are served, an empty token rejecting them all. DELETE is only served if the cache can be
+// cleared.
+func NewHandler(c cache.Cache, token string) http.Handler {
+	return &handler{cache: c, token: token}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodGet && key != "":
+		h.get(w, r, key)
+	case r.Method == http.MethodPut && key != "":
+		h.put(w, r, key)
+	case r.Method == http.MethodDelete && key == "":
+		clearer, ok := h.cache.(cache.Clearer)
+		if !ok {
+			http.Error(w, "Cache cannot be cleared", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := clearer.ClearAll(); err != nil {
+			http.Error(w, "Failed to clear the cache: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get answers the entry stored under the key, its body streamed from the cache if it supports it
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	var entry *cache.Entry
+	var body cache.Body
+	var ok bool
+	if streamer, isStreamer := h.cache.(cache.Streamer); isStreamer {
+		entry, body, ok = streamer.GetStream(r.Context(), key)
+	} else {
+		entry, ok = h.cache.Get(r.Context(), key)
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var content io.Reader = bytes.NewReader(entry.Body)
+	size := int64(len(entry.Body))
+	if body != nil {
+		defer body.Close()
+		content, size = body, body.Size()
+	}
+	if err := setEntryHeader(w.Header(), entry); err != nil {
+		http.Error(w, "Failed to encode entry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.Copy(w, content)
+}
+
+// put stores the entry sent under the key, writing its body straight to the cache if it supports it
+func (h *handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	entry, err := parseEntryHeader(r.Header)
+	if err != nil {
+		http.Error(w, "Invalid entry: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if setter, ok := h.cache.(cache.StreamSetter); ok && r.ContentLength >= 0 {
+		err = setter.SetStream(r.Context(), key, entry, r.Body, r.ContentLength)
+	} else if entry.Body, err = io.ReadAll(r.Body); err == nil {
+		err = h.cache.Set(r.Context(), key, entry)
+	}
+	if err != nil {
+		http.Error(w, "Failed to store entry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
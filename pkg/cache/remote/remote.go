@@ -0,0 +1,201 @@
+// Package remote implements a proxy cache stored by a sidecar service over a small HTTP protocol,
+// so custom backends can be written in any language and shipped without changing the proxy.
+//
+// The sidecar serves entries under its base URL:
+//
+//	GET    /<key>  200 with the entry, or 404 if there is none
+//	PUT    /<key>  stores the entry sent, answering 2xx
+//	DELETE /       removes all entries, answering 2xx
+//
+// An entry travels as is: its body is the payload of the request or response, and the rest of the entry
+// is a JSON object with the fields of cache.Entry in the X-Cache-Entry header.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// requestTimeout is the time limit for one request to the sidecar
+const requestTimeout = 5 * time.Second
+
+// entryHeader carries the entry without its body, as JSON, next to the body sent as the payload
+const entryHeader = "X-Cache-Entry"
+
+// setEntryHeader puts the entry, without its body, into the headers
+func setEntryHeader(h http.Header, entry *cache.Entry) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	h.Set(entryHeader, string(meta))
+	h.Set("Content-Type", "application/octet-stream")
+	return nil
+}
+
+// parseEntryHeader reads the entry, without its body, from the headers
+func parseEntryHeader(h http.Header) (*cache.Entry, error) {
+	var entry cache.Entry
+	if err := json.Unmarshal([]byte(h.Get(entryHeader)), &entry); err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", entryHeader, err)
+	}
+	return &entry, nil
+}
+
+type Cache struct {
+	baseURL     *url.URL      // Base URL of the sidecar
+	client      *http.Client  // Client used to reach the sidecar
+	streams     *http.Client  // Client sending streamed bodies, which take as long as their size requires
+	timeout     time.Duration // Duration before entries without their own expiry time expire
+	gracePeriod time.Duration // Duration expired entries are still served
+	keepExpired bool          // Serve expired entries too
+	token       string        // Bearer token sent with every request, empty for none
+}
+
+// New creates a Cache stored by the sidecar at the base URL
+func New(baseURL *url.URL, timeout time.Duration) *Cache {
+	base := *baseURL
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	// Only the answer to a streamed body is time-limited, the body itself takes as long as it needs
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = requestTimeout
+	return &Cache{
+		baseURL: &base,
+		client:  &http.Client{Timeout: requestTimeout},
+		streams: &http.Client{Transport: transport},
+		timeout: timeout,
+	}
+}
+
+// SetKeepExpired sets whether expired entries are still served
+func (c *Cache) SetKeepExpired(keep bool) {
+	c.keepExpired = keep
+}
+
+// SetToken sets the bearer token sent with every request, e.g. the replication token of a replica
+func (c *Cache) SetToken(token string) {
+	c.token = token
+}
+
+// SetGracePeriod sets how long expired entries are still served as stale copies
+func (c *Cache) SetGracePeriod(period time.Duration) {
+	c.gracePeriod = period
+}
+
+// Get retrieves the entry for the given key from the sidecar
+func (c *Cache) Get(ctx context.Context, key string) (*cache.Entry, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.entryURL(key), nil)
+	if err != nil {
+		return nil, false
+	}
+	resp, err := c.send(c.client, req)
+	if err != nil {
+		log.Printf("Cache sidecar: %s", err)
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	entry, err := parseEntryHeader(resp.Header)
+	if err != nil {
+		log.Printf("Cache sidecar: entry %s: %s", key, err)
+		return nil, false
+	}
+	// Expired entries are left to the sidecar to remove
+	if !c.keepExpired && entry.Expired(c.timeout, c.gracePeriod) {
+		return nil, false
+	}
+	if entry.Body, err = io.ReadAll(resp.Body); err != nil {
+		log.Printf("Cache sidecar: entry %s: %s", key, err)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set stores the entry with the given key in the sidecar
+func (c *Cache) Set(ctx context.Context, key string, entry *cache.Entry) error {
+	req, err := c.putRequest(ctx, key, entry, bytes.NewReader(entry.Body), int64(len(entry.Body)))
+	if err != nil {
+		return err
+	}
+	return c.do(c.client, req)
+}
+
+// SetStream stores the entry with the size bytes of the body read from the reader in the sidecar,
+// sending the body as it is read
+func (c *Cache) SetStream(ctx context.Context, key string, entry *cache.Entry, body io.Reader, size int64) error {
+	req, err := c.putRequest(ctx, key, entry, body, size)
+	if err != nil {
+		return err
+	}
+	return c.do(c.streams, req)
+}
+
+// putRequest builds the request storing the entry with the size bytes of the body under the key
+func (c *Cache) putRequest(ctx context.Context, key string, entry *cache.Entry, body io.Reader, size int64) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.entryURL(key), io.NopCloser(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	stored := *entry
+	stored.StoredAt = time.Now()
+	if err := setEntryHeader(req.Header, &stored); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ClearAll removes all entries from the sidecar
+func (c *Cache) ClearAll() error {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(c.client, req)
+}
+
+// RunCleanUp does nothing, the sidecar removes expired entries itself
+func (c *Cache) RunCleanUp() {}
+
+// send sends the request to the sidecar with the client, adding the token, if any
+func (c *Cache) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return client.Do(req)
+}
+
+// do sends the request with the client and checks for a successful status
+func (c *Cache) do(client *http.Client, req *http.Request) error {
+	resp, err := c.send(client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("cache sidecar answered %s", resp.Status)
+	}
+	return nil
+}
+
+// entryURL returns the URL of the entry with the given key
+func (c *Cache) entryURL(key string) string {
+	return c.baseURL.String() + url.PathEscape(key)
+}
@@ -0,0 +1,349 @@
+// Package geoip looks up the country and region of IP addresses in MaxMind databases
+// (GeoLite2/GeoIP2 Country and City, .mmdb), with a reader written against the published format
+// so the proxy keeps no external dependencies.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of the database
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Location is where an IP address is registered
+type Location struct {
+	Country string // ISO 3166-1 country code, e.g. "DE"
+	Region  string // ISO 3166-2 subdivision code without the country, e.g. "BE", empty if unknown
+}
+
+// DB is a MaxMind database loaded into memory, safe for concurrent lookups
+type DB struct {
+	data       []byte // Whole database file
+	nodeCount  uint   // Number of nodes in the search tree
+	recordSize uint   // Size of a search tree record in bits: 24, 28 or 32
+	ipVersion  uint   // 4 for IPv4 only databases, 6 for databases holding both
+	treeSize   uint   // Size of the search tree in bytes
+	ipv4Start  uint   // Node where IPv4 addresses start in an IPv6 tree
+}
+
+// Open loads the MaxMind database at path
+func Open(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := bytes.LastIndex(data, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind database: metadata not found")
+	}
+	start += len(metadataMarker)
+	meta, _, err := (&decoder{data: data[start:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	db := &DB{
+		data:       data,
+		nodeCount:  uintField(fields, "node_count"),
+		recordSize: uintField(fields, "record_size"),
+		ipVersion:  uintField(fields, "ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	db.treeSize = db.nodeCount * db.recordSize / 4
+	if db.treeSize+16 > uint(start) {
+		return nil, errors.New("invalid search tree size")
+	}
+
+	// IPv4 addresses live under 96 zero bits in IPv6 databases
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// Lookup returns the location of the IP address, or false if the database does not know it
+func (db *DB) Lookup(ip net.IP) (Location, bool) {
+	record, ok := db.find(ip)
+	if !ok {
+		return Location{}, false
+	}
+
+	d := &decoder{data: db.data[db.treeSize+16:]}
+	value, _, err := d.decode(record - db.nodeCount - 16)
+	if err != nil {
+		return Location{}, false
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return Location{}, false
+	}
+
+	var loc Location
+	if country, ok := fields["country"].(map[string]any); ok {
+		loc.Country, _ = country["iso_code"].(string)
+	}
+	if subdivisions, ok := fields["subdivisions"].([]any); ok && len(subdivisions) > 0 {
+		if subdivision, ok := subdivisions[0].(map[string]any); ok {
+			loc.Region, _ = subdivision["iso_code"].(string)
+		}
+	}
+	return loc, loc.Country != ""
+}
+
+// find walks the search tree along the bits of the address, returning the record pointing to its data
+func (db *DB) find(ip net.IP) (uint, bool) {
+	node := uint(0)
+	bits := ip.To4()
+	if bits != nil && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if bits == nil {
+		if db.ipVersion == 4 {
+			return 0, false
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return 0, false
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = db.record(node, bit)
+	}
+	// A record equal to the node count means no data, larger ones point into the data section
+	if node <= db.nodeCount {
+		return 0, false
+	}
+	return node, true
+}
+
+// record reads the left (0) or right (1) record of a search tree node
+func (db *DB) record(node, side uint) uint {
+	b := db.data[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[side*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if side == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[side*4:]))
+	}
+}
+
+// uintField returns an unsigned integer metadata field, zero if it is missing
+func uintField(fields map[string]any, name string) uint {
+	v, _ := fields[name].(uint64)
+	return uint(v)
+}
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// errCorrupt is returned for data that does not follow the format
+var errCorrupt = errors.New("corrupt database")
+
+// maxDepth limits the nesting of decoded values, so pointer loops in a corrupt database end
+const maxDepth = 64
+
+// decoder decodes values of a data section, pointers being offsets from its start
+type decoder struct {
+	data  []byte
+	depth int // Nesting of the value being decoded
+}
+
+// decode decodes the value at the offset, returning it and the offset following it
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, 0, errCorrupt
+	}
+	defer func() { d.depth-- }()
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+	if kind == typeBool {
+		return size != 0, offset, nil
+	}
+	if kind == typeMap || kind == typeArray {
+		return d.collection(kind, size, offset)
+	}
+
+	end := offset + size
+	if end > uint(len(d.data)) {
+		return nil, 0, errCorrupt
+	}
+	b := d.data[offset:end]
+	switch kind {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes, typeUint128:
+		return bytes.Clone(b), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if kind == typeInt32 {
+			return int64(int32(uint32(v))), end, nil
+		}
+		return v, end, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unexpected type %d", errCorrupt, kind)
+	}
+}
+
+// control reads the control byte of a value, returning its type, size and the offset of its payload
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, errCorrupt
+	}
+	ctrl := d.data[offset]
+	offset++
+
+	kind := int(ctrl >> 5)
+	if kind == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errCorrupt
+		}
+		kind = 7 + int(d.data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if kind == typePointer || size < 29 {
+		return kind, size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > uint(len(d.data)) {
+		return 0, 0, 0, errCorrupt
+	}
+	var n uint
+	for _, c := range d.data[offset : offset+extra] {
+		n = n<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		size = 29 + n
+	case 30:
+		size = 285 + n
+	default:
+		size = 65821 + n
+	}
+	return kind, size, offset + extra, nil
+}
+
+// pointer reads a pointer whose size bits are given, returning its target and the offset following it
+func (d *decoder) pointer(size, offset uint) (uint, uint, error) {
+	length := (size>>3)&0x3 + 1
+	if offset+length > uint(len(d.data)) {
+		return 0, 0, errCorrupt
+	}
+	var v uint
+	if length < 4 {
+		v = size & 0x7
+	}
+	for _, c := range d.data[offset : offset+length] {
+		v = v<<8 | uint(c)
+	}
+	switch length {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + length, nil
+}
+
+// collection decodes a map or an array of size elements
+func (d *decoder) collection(kind int, size, offset uint) (any, uint, error) {
+	if kind == typeArray {
+		values := make([]any, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	}
+
+	values := make(map[string]any, min(size, 1024))
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, 0, errCorrupt
+		}
+		value, next, err := d.decode(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[name] = value
+		offset = next
+	}
+	return values, offset, nil
+}
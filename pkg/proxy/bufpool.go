@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool, so one huge response does not stay allocated
+const maxPooledBuffer = 1 << 20
+
+// bufferPool reuses the buffers origin bodies are read into
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// poolBuffers turns the pool off when false, so the benchmarks can measure the proxy without it
+var poolBuffers = true
+
+// getBuffer takes an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	if !poolBuffers {
+		return new(bytes.Buffer)
+	}
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns the buffer to the pool; its content must no longer be referenced
+func putBuffer(buf *bytes.Buffer) {
+	if !poolBuffers || buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// bodySizes are the origin body sizes the benchmarks request
+var bodySizes = []int{4 << 10, 64 << 10, 512 << 10}
+
+// missCache never has an entry and drops the entries stored, so every request takes the miss path
+type missCache struct{}
+
+func (missCache) Get(context.Context, string) (*cache.Entry, bool) { return nil, false }
+func (missCache) Set(context.Context, string, *cache.Entry) error  { return nil }
+
+// discardWriter is a response writer dropping the body, so only the proxy's own allocations are counted
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// benchmarkProxy measures requests going through the proxy to an httptest origin, with the buffer pool
+// on or off, for every body size
+func benchmarkProxy(b *testing.B, pooled bool, opts ...Option) {
+	defer func(old bool) { poolBuffers = old }(poolBuffers)
+	poolBuffers = pooled
+
+	for _, size := range bodySizes {
+		body := bytes.Repeat([]byte("x"), size)
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write(body)
+		}))
+		originURL, _ := url.Parse(origin.URL)
+		p := New(missCache{}, originURL, append(opts, WithLogger(log.New(io.Discard, "", 0)))...)
+
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for range b.N {
+				r := httptest.NewRequest(http.MethodGet, "/data", nil)
+				p.ServeHTTP(&discardWriter{header: make(http.Header)}, r)
+			}
+			p.pendingWrites.Wait()
+		})
+		origin.Close()
+	}
+}
+
+// BenchmarkMissPooled measures cache misses, fetched from the origin and stored, with the buffer pool
+func BenchmarkMissPooled(b *testing.B) {
+	benchmarkProxy(b, true)
+}
+
+// BenchmarkMissUnpooled measures cache misses, fetched from the origin and stored, without the buffer pool
+func BenchmarkMissUnpooled(b *testing.B) {
+	benchmarkProxy(b, false)
+}
+
+// BenchmarkRelayPooled measures responses relayed without caching, with the buffer pool
+func BenchmarkRelayPooled(b *testing.B) {
+	benchmarkProxy(b, true, WithNoCache())
+}
+
+// BenchmarkRelayUnpooled measures responses relayed without caching, without the buffer pool
+func BenchmarkRelayUnpooled(b *testing.B) {
+	benchmarkProxy(b, false, WithNoCache())
+}
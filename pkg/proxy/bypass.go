@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetNoCacheClients sets the client networks allowed to force a refetch with Cache-Control or Pragma no-cache
+func (p *Proxy) SetNoCacheClients(networks []*net.IPNet) {
+	p.noCacheClients = networks
+}
+
+// isClientBypass checks whether the client asked for a fresh response and is allowed to skip the cache
+func (p *Proxy) isClientBypass(r *http.Request) bool {
+	if len(p.noCacheClients) == 0 || !hasNoCacheDirective(r) {
+		return false
+	}
+
+	ip := net.ParseIP(remoteIP(r.RemoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.noCacheClients {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNoCacheDirective reports whether the request carries Cache-Control: no-cache or Pragma: no-cache
+func hasNoCacheDirective(r *http.Request) bool {
+	for _, value := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Pragma")), "no-cache")
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTTLHeader is the origin response header overriding the TTL of that response
+const DefaultTTLHeader = "X-Proxy-Cache-TTL"
+
+// SetCacheSetCookie sets whether responses carrying Set-Cookie may be cached
+func (p *Proxy) SetCacheSetCookie(is bool) {
+	p.cacheSetCookie = is
+}
+
+// SetCacheableCookies sets the cookie names that do not prevent a response from being cached
+func (p *Proxy) SetCacheableCookies(names []string) {
+	p.cacheableCookies = names
+}
+
+// SetNegativeCaching sets the TTL for 404 and 410 responses, and whether 5xx responses are cached with it too;
+// a zero ttl disables caching of these responses
+func (p *Proxy) SetNegativeCaching(ttl time.Duration, include5xx bool) {
+	p.negativeTTL = ttl
+	p.negativeCache5xx = include5xx
+}
+
+// SetTTLHeader sets the origin response header that overrides the TTL of that response, empty disables it.
+// The header is never cached or passed to clients.
+func (p *Proxy) SetTTLHeader(name string) {
+	p.ttlHeader = name
+}
+
+// cacheableStatuses are the statuses cached by default (RFC 9110, section 15.1), besides redirects and
+// missing resources, which have settings of their own
+var cacheableStatuses = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusMultipleChoices,
+	http.StatusMethodNotAllowed,
+	http.StatusRequestURITooLong,
+}
+
+// isCacheableResponse checks whether the origin response may be stored in the cache
+func (p *Proxy) isCacheableResponse(resp *http.Response) bool {
+	// A partial or not modified response answers the conditions of one client, it is never served to others
+	if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	// An explicit TTL from the origin overrides every other rule, zero forbidding caching
+	if ttl, ok := p.ttlOverride(resp); ok {
+		return ttl > 0 && p.isCacheableCookies(resp)
+	}
+	return p.isCacheableStatus(resp.StatusCode) && p.isCacheableCookies(resp) && p.isCacheableContentType(resp)
+}
+
+// responseTTL returns the TTL of the cached response, zero meaning the cache timeout applies
+func (p *Proxy) responseTTL(resp *http.Response) time.Duration {
+	if ttl, ok := p.ttlOverride(resp); ok {
+		return ttl
+	}
+	if isNegativeStatus(resp.StatusCode) {
+		return p.negativeTTL
+	}
+	if p.cachesRedirect(resp.StatusCode) {
+		return p.redirectTTL
+	}
+	return p.contentTypeTTL(resp)
+}
+
+// ttlOverride parses the TTL override header of the response, given in seconds or as a duration such as "5m"
+func (p *Proxy) ttlOverride(resp *http.Response) (time.Duration, bool) {
+	if p.ttlHeader == "" {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get(p.ttlHeader))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+		return ttl, true
+	}
+	return 0, false
+}
+
+// isCacheableStatus checks the status code: rate limits are never cached, redirects passed through only when permanent
+// and redirect caching is enabled, missing resources and server errors only when negative caching is, and any other
+// status only if it is cacheable by default
+func (p *Proxy) isCacheableStatus(status int) bool {
+	// A rate limit concerns the moment it was sent, it is replayed while the origin asked to hold off instead
+	if status == http.StatusTooManyRequests {
+		return false
+	}
+	if isRedirectStatus(status) {
+		return p.cachesRedirect(status)
+	}
+	if !isNegativeStatus(status) {
+		return slices.Contains(cacheableStatuses, status)
+	}
+	if status >= 500 {
+		return p.negativeTTL > 0 && p.negativeCache5xx
+	}
+	return p.negativeTTL > 0
+}
+
+// isOriginFailure reports whether the status means the origin failed or refused to answer for now
+func isOriginFailure(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// isNegativeStatus reports whether the status means the resource is missing or the origin failed
+func isNegativeStatus(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
+
+// isCacheableCookies checks the cookies set by the response: caching them would hand one user's
+// session to everyone else, so only allowlisted cookies are accepted unless caching is forced
+func (p *Proxy) isCacheableCookies(resp *http.Response) bool {
+	if p.cacheSetCookie {
+		return true
+	}
+	for _, cookie := range resp.Cookies() {
+		if !slices.Contains(p.cacheableCookies, cookie.Name) {
+			return false
+		}
+	}
+	return true
+}
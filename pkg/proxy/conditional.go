@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// clientConditions are the request headers asking for a part of the response, or for the response only under
+// conditions; they concern the copy of one client, so they are never sent with a request whose response is cached
+var clientConditions = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"}
+
+// removeClientConditions removes the conditions and range of the client from the headers
+func removeClientConditions(header http.Header) {
+	for _, name := range clientConditions {
+		header.Del(name)
+	}
+}
+
+// hasClientConditions reports whether the client asked for a range or set conditions
+func hasClientConditions(header http.Header) bool {
+	for _, name := range clientConditions {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutClientConditions returns a copy of the request without the conditions and range of the client,
+// so the origin sends the full response, which can be cached and answers them itself
+func withoutClientConditions(r *http.Request) *http.Request {
+	if !hasClientConditions(r.Header) {
+		return r
+	}
+	req := r.Clone(r.Context())
+	removeClientConditions(req.Header)
+	return req
+}
+
+// conditionalRequest returns a copy of the request asking the origin for the response only if it changed since
+// the cached entry was stored, or false if the entry has no validators or the client sent conditions of its own
+func conditionalRequest(r *http.Request, entry *cache.Entry) (*http.Request, bool) {
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		return nil, false
+	}
+	etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil, false
+	}
+
+	cr := r.Clone(r.Context())
+	removeClientConditions(cr.Header)
+	if etag != "" {
+		cr.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		cr.Header.Set("If-Modified-Since", lastModified)
+	}
+	return cr, true
+}
+
+// refreshExpired answers a request for an expired entry with validators through a conditional request:
+// if the origin reports it unchanged, the entry is renewed and served as a hit, any other answer
+// is relayed and cached like a miss
+func (p *Proxy) refreshExpired(w http.ResponseWriter, r, cr *http.Request, cacheKey string, entry *cache.Entry, body cache.Body) {
+	resp, err := p.getResponseFromOrigin(cr)
+	if err == nil && resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		p.renew(r, cacheKey, entry, resp)
+		w.Header().Set("X-Cache", "HIT")
+		p.responseFromCache(w, r, entry, body)
+		p.logCacheResult("HIT (revalidated)", r)
+		return
+	}
+
+	closeBody(body)
+	w.Header().Set("X-Cache", "MISS")
+	p.relayResponse(w, r, resp, err, r.Method != http.MethodHead, cacheKey)
+	p.logCacheResult("MISS", r)
+}
+
+// renew restarts the lifetime of a cached entry the origin reported unchanged, in place where the cache
+// supports it, so neither the body nor the headers are written again. The TTL header of the 304 response
+// applies as it would to a full one.
+func (p *Proxy) renew(r *http.Request, cacheKey string, entry *cache.Entry, notModified *http.Response) {
+	header := entry.Header
+	if value := notModified.Header.Get(p.ttlHeader); p.ttlHeader != "" && value != "" {
+		header = header.Clone()
+		header.Set(p.ttlHeader, value)
+	}
+	expiresAt := p.expiryTime(r, p.responseTTL(&http.Response{StatusCode: entry.Status, Header: header}))
+	refreshAt := p.refreshTime()
+
+	// Like a store, the renewal happens after the response is sent
+	p.pendingWrites.Add(1)
+	p.stats.pendingWrites.Add(1)
+	go func() {
+		defer p.pendingWrites.Done()
+		defer p.stats.pendingWrites.Add(-1)
+		ctx := context.WithoutCancel(r.Context())
+		if refresher, ok := p.cache.(cache.Refresher); ok && refresher.Refresh(ctx, cacheKey, expiresAt, refreshAt) {
+			return
+		}
+
+		// The cache cannot renew the entry in place, so it is stored again with its body
+		stored, ok := p.cache.Get(ctx, cacheKey)
+		if !ok {
+			return
+		}
+		stored.ExpiresAt, stored.RefreshAt = expiresAt, refreshAt
+		if err := p.cache.Set(ctx, cacheKey, stored); err != nil && !notStored(err) {
+			p.logger.Printf("Error renewing cached response for URL %s: %s", r.URL.String(), err)
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// ContentTypeRule decides whether and for how long responses with a matching Content-Type are cached
+type ContentTypeRule struct {
+	Pattern string   `json:"pattern"` // Media type pattern, e.g. "image/*" or "application/octet-stream"
+	Cache   *bool    `json:"cache"`   // Whether matching responses are cached (default: true)
+	TTL     Duration `json:"ttl"`     // TTL of matching responses, zero keeps the cache timeout
+}
+
+// Validate checks that the rule has a well-formed media type pattern
+func (c *ContentTypeRule) Validate() error {
+	if c.Pattern == "" {
+		return fmt.Errorf("content type rule: pattern is required")
+	}
+	if _, err := path.Match(c.Pattern, "text/html"); err != nil {
+		return fmt.Errorf("content type rule: invalid pattern '%s'", c.Pattern)
+	}
+	return nil
+}
+
+// SetContentTypeRules sets the rules deciding caching by response Content-Type, the first matching rule wins
+func (p *Proxy) SetContentTypeRules(rules []ContentTypeRule) {
+	p.contentTypeRules = rules
+}
+
+// findContentTypeRule returns the first rule matching the response Content-Type, or nil
+func (p *Proxy) findContentTypeRule(resp *http.Response) *ContentTypeRule {
+	if len(p.contentTypeRules) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	for i := range p.contentTypeRules {
+		if ok, _ := path.Match(strings.ToLower(p.contentTypeRules[i].Pattern), mediaType); ok {
+			return &p.contentTypeRules[i]
+		}
+	}
+	return nil
+}
+
+// isCacheableContentType checks whether a content type rule excludes the response from caching
+func (p *Proxy) isCacheableContentType(resp *http.Response) bool {
+	rule := p.findContentTypeRule(resp)
+	return rule == nil || rule.Cache == nil || *rule.Cache
+}
+
+// contentTypeTTL returns the TTL set by the matching content type rule, or zero
+func (p *Proxy) contentTypeTTL(resp *http.Response) time.Duration {
+	if rule := p.findContentTypeRule(resp); rule != nil {
+		return time.Duration(rule.TTL)
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORSRule describes the Access-Control-* headers the proxy adds to responses for matching paths
+type CORSRule struct {
+	Path             string   `json:"path"`              // Path pattern, e.g. "/api/*"; empty matches every path
+	AllowedOrigins   []string `json:"allowed_origins"`   // Origins allowed to read responses, "*" allows any
+	AllowedMethods   []string `json:"allowed_methods"`   // Methods allowed in preflight requests (default: GET, HEAD, OPTIONS)
+	AllowedHeaders   []string `json:"allowed_headers"`   // Request headers allowed in preflight requests, "*" allows any
+	ExposedHeaders   []string `json:"exposed_headers"`   // Response headers exposed to browser scripts
+	AllowCredentials bool     `json:"allow_credentials"` // Whether cookies and credentials may be sent
+	MaxAge           int      `json:"max_age"`           // Seconds a preflight response may be cached by the browser
+}
+
+// Validate checks the rule for a well-formed path pattern and at least one allowed origin
+func (c *CORSRule) Validate() error {
+	if _, err := path.Match(c.Path, "/"); err != nil {
+		return fmt.Errorf("cors rule: invalid path pattern '%s'", c.Path)
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return fmt.Errorf("cors rule %s: allowed_origins is required", c.Path)
+	}
+	return nil
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for the request origin, or "" if it is not allowed
+func (c *CORSRule) allowOrigin(origin string) string {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		// Credentials cannot be combined with a wildcard, so the origin is echoed back instead
+		if c.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	if slices.Contains(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// SetCORSRules sets the CORS rules applied to requests carrying an Origin header
+func (p *Proxy) SetCORSRules(rules []CORSRule) {
+	p.corsRules = rules
+}
+
+// findCORSRule returns the first CORS rule whose path pattern matches the request, or nil
+func (p *Proxy) findCORSRule(r *http.Request) *CORSRule {
+	for i := range p.corsRules {
+		if matchPath(p.corsRules[i].Path, r.URL.Path) {
+			return &p.corsRules[i]
+		}
+	}
+	return nil
+}
+
+// handlePreflight answers CORS preflight requests for configured routes without contacting the origin.
+// It reports whether the request was handled.
+func (p *Proxy) handlePreflight(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodOptions || r.Header.Get("Origin") == "" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	rule := p.findCORSRule(r)
+	if rule == nil {
+		return false
+	}
+
+	p.setCORSHeaders(w.Header(), r)
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	// Only announce methods and headers when the origin itself is allowed
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		methods := rule.AllowedMethods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+		if slices.Contains(rule.AllowedHeaders, "*") {
+			// Reflect the requested headers, since "*" is not honored together with credentials
+			if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if len(rule.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(rule.AllowedHeaders, ", "))
+		}
+
+		if rule.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAge))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// setCORSHeaders adds Access-Control-* headers to the response if a CORS rule matches the request
+func (p *Proxy) setCORSHeaders(headers http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	rule := p.findCORSRule(r)
+	if rule == nil {
+		return
+	}
+
+	allowed := rule.allowOrigin(origin)
+	if allowed != "*" {
+		headers.Add("Vary", "Origin")
+	}
+	if allowed == "" {
+		return
+	}
+
+	headers.Set("Access-Control-Allow-Origin", allowed)
+	if rule.AllowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(rule.ExposedHeaders) > 0 {
+		headers.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposedHeaders, ", "))
+	}
+}
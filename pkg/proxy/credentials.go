@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OriginCredentials are added to every request sent to the origin and its fallback, so the proxy can front
+// an authenticated API while clients reach it anonymously. Values may reference environment variables
+// as ${NAME}, keeping secrets out of the configuration file.
+type OriginCredentials struct {
+	BearerToken string            `json:"bearer_token"` // Token sent as "Authorization: Bearer <token>"
+	Username    string            `json:"username"`     // User name of HTTP basic authentication
+	Password    string            `json:"password"`     // Password of HTTP basic authentication
+	Headers     map[string]string `json:"headers"`      // Further headers, e.g. an API key
+	SigV4       *SigV4            `json:"sigv4"`        // AWS Signature Version 4 signing, instead of a token or basic authentication
+}
+
+// Validate expands the environment variables referenced by the values and checks that a single
+// Authorization scheme is configured
+func (c *OriginCredentials) Validate() error {
+	var missing []string
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string {
+			v, ok := os.LookupEnv(name)
+			if !ok && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+	c.BearerToken, c.Username, c.Password = expand(c.BearerToken), expand(c.Username), expand(c.Password)
+	for name, value := range c.Headers {
+		c.Headers[name] = expand(value)
+	}
+	if s := c.SigV4; s != nil {
+		s.AccessKeyID, s.SecretAccessKey, s.SessionToken = expand(s.AccessKeyID), expand(s.SecretAccessKey), expand(s.SessionToken)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("origin auth: environment variables not set: %s", strings.Join(missing, ", "))
+	}
+
+	switch {
+	case c.BearerToken != "" && c.Username != "":
+		return errors.New("origin auth: bearer_token and username are mutually exclusive")
+	case c.Password != "" && c.Username == "":
+		return errors.New("origin auth: password requires a username")
+	case c.SigV4 != nil && (c.BearerToken != "" || c.Username != ""):
+		return errors.New("origin auth: sigv4 signs the Authorization header, it excludes bearer_token and username")
+	}
+	for name := range c.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("origin auth: invalid header name '%s'", name)
+		}
+		if http.CanonicalHeaderKey(name) == "Authorization" && (c.BearerToken != "" || c.Username != "" || c.SigV4 != nil) {
+			return errors.New("origin auth: the Authorization header is already set by bearer_token, username or sigv4")
+		}
+	}
+	if c.SigV4 != nil {
+		return c.SigV4.Validate()
+	}
+	return nil
+}
+
+// header returns the headers carrying the credentials
+func (c *OriginCredentials) header() http.Header {
+	h := make(http.Header)
+	for name, value := range c.Headers {
+		h.Set(name, value)
+	}
+	switch {
+	case c.BearerToken != "":
+		h.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.Username != "":
+		r := &http.Request{Header: h}
+		r.SetBasicAuth(c.Username, c.Password)
+	}
+	return h
+}
+
+// SetOriginCredentials sets the credentials added to the origin requests; nil sends none
+func (p *Proxy) SetOriginCredentials(c *OriginCredentials) {
+	p.originAuth, p.sigV4 = nil, nil
+	if c != nil {
+		p.originAuth, p.sigV4 = c.header(), c.SigV4
+	}
+}
+
+// WithOriginCredentials sets the credentials added to the origin requests
+func WithOriginCredentials(c *OriginCredentials) Option {
+	return func(p *Proxy) { p.SetOriginCredentials(c) }
+}
+
+// stripCredentialHeaders removes the client copies of the credential headers, before the request is keyed,
+// so clients can neither vary the cache by them nor pass their own to the origin. With SigV4 signing these are
+// Authorization and the X-Amz-* headers, which would be signed along.
+func (p *Proxy) stripCredentialHeaders(headers http.Header) {
+	for name := range p.originAuth {
+		headers.Del(name)
+	}
+	if p.sigV4 != nil {
+		for name := range headers {
+			if name == "Authorization" || strings.HasPrefix(name, "X-Amz-") {
+				headers.Del(name)
+			}
+		}
+	}
+}
+
+// authenticate adds the credentials to an origin request and signs it, once its other headers are final
+func (p *Proxy) authenticate(req *http.Request) error {
+	for name, values := range p.originAuth {
+		req.Header[name] = slices.Clone(values)
+	}
+	if p.sigV4 != nil {
+		return p.sigV4.sign(req, time.Now())
+	}
+	return nil
+}
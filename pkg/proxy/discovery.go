@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PeerDiscovery keeps the peers of a proxy up to date from DNS, so a cluster follows scaling events without
+// a static peer list: either SRV records (names starting with an underscore, e.g. _http._tcp.proxy.example.com)
+// or the addresses of a name, e.g. a headless Kubernetes service, with the port given after it or else the one
+// of this instance. Peers are listed by address, with the scheme of this instance, so the URL of this instance
+// must be given by address too, e.g. http://$(POD_IP):8080.
+type PeerDiscovery struct {
+	proxy    *Proxy
+	self     *url.URL      // URL of this instance as the discovered peers list it
+	name     string        // DNS name the peers are discovered from
+	interval time.Duration // Interval of the lookups
+	current  []string      // URLs of the peers last set, sorted
+}
+
+// NewPeerDiscovery creates a discovery setting the peers of the proxy from the DNS name every interval
+func NewPeerDiscovery(p *Proxy, self *url.URL, name string, interval time.Duration) *PeerDiscovery {
+	return &PeerDiscovery{proxy: p, self: self, name: name, interval: interval}
+}
+
+// Run looks the peers up at once and then every interval until the context is done; when a lookup fails
+// the peers found last are kept
+func (d *PeerDiscovery) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh looks the peers up and sets them on the proxy if they changed
+func (d *PeerDiscovery) refresh(ctx context.Context) {
+	lookupCtx, cancel := context.WithTimeout(ctx, peerLookupTimeout)
+	defer cancel()
+	peers, err := d.lookup(lookupCtx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error discovering peers from %s: %s\n", d.name, err)
+		}
+		return
+	}
+
+	names := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		names = append(names, peerName(peer))
+	}
+	slices.Sort(names)
+	if slices.Equal(names, d.current) {
+		return
+	}
+	d.current = names
+	d.proxy.SetPeers(d.self, peers)
+	log.Printf("Cluster peers discovered from %s: %s\n", d.name, strings.Join(names, ", "))
+}
+
+// lookup returns the URLs of the instances the DNS name lists, this one included
+func (d *PeerDiscovery) lookup(ctx context.Context) ([]*url.URL, error) {
+	type target struct {
+		host string
+		port string
+	}
+	var targets []target
+	if strings.HasPrefix(d.name, "_") {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			targets = append(targets, target{strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))})
+		}
+	} else {
+		host, port, err := net.SplitHostPort(d.name)
+		if err != nil {
+			host, port = d.name, d.self.Port()
+		}
+		if port == "" {
+			return nil, fmt.Errorf("no port in %s nor in the URL of this instance", d.name)
+		}
+		targets = append(targets, target{host, port})
+	}
+
+	var peers []*url.URL
+	for _, t := range targets {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, t.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			peers = append(peers, &url.URL{Scheme: d.self.Scheme, Host: net.JoinHostPort(addr, t.port)})
+		}
+	}
+	return peers, nil
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings the proxy keeps cache variants for, in order of preference
+var cachedEncodings = []string{"br", "gzip"}
+
+// normalizedEncoding picks the preferred coding among br and gzip accepted by the client,
+// or an empty string for identity
+func normalizedEncoding(r *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || strings.HasPrefix(coding, "x-") && coding != "x-gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		accepted[coding] = true
+	}
+
+	for _, coding := range cachedEncodings {
+		if accepted[coding] || accepted["*"] {
+			return coding
+		}
+	}
+	return ""
+}
+
+// setOriginEncoding asks the origin for exactly the coding of the cache variant of the request,
+// so the cached body is only ever served to clients accepting it
+func setOriginEncoding(headers http.Header, r *http.Request) {
+	if coding := normalizedEncoding(r); coding != "" {
+		headers.Set("Accept-Encoding", coding)
+	} else {
+		headers.Del("Accept-Encoding")
+	}
+}
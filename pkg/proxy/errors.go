@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorPages holds the HTML templates rendered for errors generated by the proxy itself
+type ErrorPages struct {
+	byStatus map[int]*template.Template // Templates for specific status codes
+	fallback *template.Template         // Template used for any other status code, may be nil
+}
+
+// errorPageData is the data available to error page templates
+type errorPageData struct {
+	Status     int       // HTTP status code
+	StatusText string    // Standard text for the status code
+	Message    string    // Description of what went wrong
+	RequestID  string    // Identifier of the failed request, also sent as X-Request-ID
+	Time       time.Time // Time the error occurred
+}
+
+// LoadErrorPages parses the error page templates; keys are status codes such as "502" or "default"
+func LoadErrorPages(files map[string]string) (*ErrorPages, error) {
+	pages := &ErrorPages{byStatus: make(map[int]*template.Template)}
+
+	for key, file := range files {
+		tmpl, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("error page %s: %w", key, err)
+		}
+
+		if key == "default" {
+			pages.fallback = tmpl
+			continue
+		}
+		status, err := strconv.Atoi(key)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("error page %s: key must be an error status code or \"default\"", key)
+		}
+		pages.byStatus[status] = tmpl
+	}
+
+	return pages, nil
+}
+
+// template returns the template for the status code, or nil if none is configured
+func (e *ErrorPages) template(status int) *template.Template {
+	if e == nil {
+		return nil
+	}
+	if tmpl, ok := e.byStatus[status]; ok {
+		return tmpl
+	}
+	return e.fallback
+}
+
+// SetErrorPages sets the templates used for errors generated by the proxy
+func (p *Proxy) SetErrorPages(pages *ErrorPages) {
+	p.errorPages = pages
+}
+
+// writeError replies with a proxy-generated error, using the configured error page if there is one
+func (p *Proxy) writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	tmpl := p.errorPages.template(status)
+	if tmpl == nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	// Render into a buffer first, so a broken template still produces a valid response
+	var buf bytes.Buffer
+	data := errorPageData{status, http.StatusText(status), message, requestID, time.Now()}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		p.logger.Printf("Error rendering error page for status %d: %s", status, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// originErrorStatus maps an error from the origin request to the status returned to the client
+func originErrorStatus(err error) int {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// newRequestID generates a random identifier for a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
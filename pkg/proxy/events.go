@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	EventHit    = "hit"    // A request was answered from the cache
+	EventMiss   = "miss"   // A request was forwarded to the origin for lack of a usable cached copy
+	EventBypass = "bypass" // A request was forwarded without looking at the cache
+	EventStale  = "stale"  // An expired copy was served because the origin failed
+	EventStore  = "store"  // A response was stored in the cache
+	EventEvict  = "evict"  // The cache removed an entry on its own, because it expired or to make room
+	EventPurge  = "purge"  // Cached entries were removed on request
+)
+
+// Event is a cache activity observed by the subscribers of the proxy
+type Event struct {
+	Time    time.Time `json:"time"`                 // When the activity happened
+	Type    string    `json:"type"`                 // One of the Event... types
+	Method  string    `json:"method,omitempty"`     // Request method
+	URL     string    `json:"url,omitempty"`        // Request URL
+	Key     string    `json:"key,omitempty"`        // Cache key, for evictions, which have no URL
+	Reason  string    `json:"reason,omitempty"`     // Why an entry was evicted
+	Latency float64   `json:"latency_ms,omitempty"` // Time spent answering the request, in milliseconds
+	Size    int       `json:"size,omitempty"`       // Body size of a stored response
+}
+
+// eventStream fans events out to subscribers, dropping the events a slow subscriber has no room for
+type eventStream struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+	active      atomic.Bool // Whether anyone subscribed, so requests are not tracked for nobody
+}
+
+// Subscribe returns a channel receiving the events of the proxy, buffering up to buffer events,
+// and a function ending the subscription. Events are dropped while the buffer is full.
+func (p *Proxy) Subscribe(buffer int) (<-chan Event, func()) {
+	s := &p.events
+	ch := make(chan Event, buffer)
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan Event]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	s.active.Store(true)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subscribers, ch)
+			s.active.Store(len(s.subscribers) > 0)
+			s.mu.Unlock()
+		})
+	}
+}
+
+// Publish sends the event to the subscribers, setting its time if it has none; activities outside the proxy,
+// such as purges or evictions by the cache, are published this way
+func (p *Proxy) Publish(e Event) {
+	s := &p.events
+	if !s.active.Load() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// requestStartKey is the context key of the time the proxy started handling a request
+type requestStartKey struct{}
+
+// trackRequest records when handling of the request started, while anyone subscribed to events
+func (p *Proxy) trackRequest(r *http.Request) *http.Request {
+	if !p.events.active.Load() {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestStartKey{}, time.Now()))
+}
+
+// publishResult publishes how the request was answered, e.g. "HIT" or "MISS (offline)"
+func (p *Proxy) publishResult(result string, r *http.Request) {
+	if !p.events.active.Load() {
+		return
+	}
+	kind, _, _ := strings.Cut(result, " ")
+	e := Event{Type: strings.ToLower(kind), Method: r.Method, URL: r.URL.String()}
+	if start, ok := r.Context().Value(requestStartKey{}).(time.Time); ok {
+		e.Latency = float64(time.Since(start).Microseconds()) / 1000
+	}
+	p.Publish(e)
+}
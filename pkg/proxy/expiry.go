@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// SetDefaultTTL sets the TTL of cached responses that have no TTL of their own, zero meaning they never expire
+func (p *Proxy) SetDefaultTTL(ttl time.Duration) {
+	p.defaultTTL = ttl
+}
+
+// SetServeStaleOnError sets how long after expiration a cached copy may still be served when the origin fails
+func (p *Proxy) SetServeStaleOnError(window time.Duration) {
+	p.staleOnErrorWindow = window
+}
+
+// expiryTime returns when a response to the request cached now with the given TTL expires; a zero ttl
+// falls back to the default TTL of the tenant, and a zero time means the entry follows the cache timeout
+func (p *Proxy) expiryTime(r *http.Request, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = p.tenantDefaultTTL(r)
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// isExpired checks whether the cached entry has passed its expiration time
+func (p *Proxy) isExpired(entry *cache.Entry) bool {
+	return !entry.Pinned && !entry.ExpiresAt.IsZero() && time.Now().After(entry.ExpiresAt)
+}
+
+// serveStaleOnError serves an expired cached copy with a Warning header when the origin failed,
+// as long as it expired less than the stale window ago; a copy that has not expired yet, reached when
+// the client bypassed the cache, is served as a plain hit. It reports whether a response was written.
+func (p *Proxy) serveStaleOnError(w http.ResponseWriter, r *http.Request, cacheKey string) bool {
+	if p.staleOnErrorWindow <= 0 || cacheKey == "" {
+		return false
+	}
+
+	entry, body, ok := p.lookup(r.Context(), cacheKey)
+	if !ok {
+		return false
+	}
+	if entry.ExpiresAt.IsZero() || time.Since(entry.ExpiresAt) > p.staleOnErrorWindow {
+		closeBody(body)
+		return false
+	}
+	if !p.isExpired(entry) {
+		p.logger.Printf("Origin failed, serving cached copy for URL: %s", r.URL.String())
+		w.Header().Set("X-Cache", "HIT")
+		p.responseFromCache(w, r, entry, body)
+		return true
+	}
+
+	p.logger.Printf("Origin failed, serving stale copy for URL: %s", r.URL.String())
+	p.stats.stale.Add(1)
+	p.Publish(Event{Type: EventStale, Method: r.Method, URL: r.URL.String()})
+	w.Header().Set("X-Cache", "STALE")
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	p.responseFromCache(w, r, entry, body)
+	return true
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SetFallbackOrigin sets the secondary origin tried when the primary one fails or answers with a server error
+func (p *Proxy) SetFallbackOrigin(origin *url.URL) {
+	p.fallbackOrigin = origin
+}
+
+// SetOriginTimeout sets the time limit for origin requests, including reading the response body; zero means no limit
+func (p *Proxy) SetOriginTimeout(timeout time.Duration) {
+	p.client.Timeout = timeout
+}
+
+// sendWithFallback sends the request to the primary origin and retries it on the fallback origin
+// if the primary fails or answers with a server error
+func (p *Proxy) sendWithFallback(r *http.Request) (*http.Response, error) {
+	// The body can be read only once, so it is buffered to be sent to both origins
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = r.Body.Close()
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := p.sendRequest(p.origin, r)
+	// A request canceled by the client is not worth retrying
+	if err == nil && resp.StatusCode < 500 || r.Context().Err() != nil {
+		return resp, err
+	}
+
+	p.logger.Printf("Primary origin failed for URL %s, trying fallback origin %s", r.URL.String(), p.fallbackOrigin.String())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	fallbackResp, fallbackErr := p.sendRequest(p.fallbackOrigin, r)
+	if fallbackErr != nil {
+		// Neither origin answered properly, report what the primary one said
+		return resp, err
+	}
+
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return fallbackResp, nil
+}
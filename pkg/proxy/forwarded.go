@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers set on requests to the origin server
+const (
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerForwarded       = "Forwarded"
+)
+
+// SetTrustedProxies sets the networks whose incoming forwarding headers are honored and appended to
+func (p *Proxy) SetTrustedProxies(networks []*net.IPNet) {
+	p.trustedProxies = networks
+}
+
+// setForwardedHeaders adds X-Forwarded-* and RFC 7239 Forwarded headers describing the client to the origin request
+func (p *Proxy) setForwardedHeaders(originReq *http.Request, r *http.Request) {
+	clientIP := remoteIP(r.RemoteAddr)
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	// Values set by untrusted clients can be forged, so they are dropped instead of extended
+	if !p.isTrustedProxy(clientIP) {
+		for _, name := range []string{headerXForwardedFor, headerXForwardedProto, headerXForwardedHost, headerForwarded} {
+			originReq.Header.Del(name)
+		}
+	}
+
+	// Append the client address to the existing chain
+	if prior := originReq.Header.Values(headerXForwardedFor); len(prior) > 0 {
+		originReq.Header.Set(headerXForwardedFor, strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		originReq.Header.Set(headerXForwardedFor, clientIP)
+	}
+
+	// Protocol and host describe the original client request, so values from a trusted proxy are kept
+	if originReq.Header.Get(headerXForwardedProto) == "" {
+		originReq.Header.Set(headerXForwardedProto, proto)
+	}
+	if originReq.Header.Get(headerXForwardedHost) == "" {
+		originReq.Header.Set(headerXForwardedHost, r.Host)
+	}
+
+	// Append this hop to the RFC 7239 Forwarded header
+	element := "for=" + forwardedNode(clientIP) + ";host=" + quoteForwarded(r.Host) + ";proto=" + proto
+	if prior := originReq.Header.Values(headerForwarded); len(prior) > 0 {
+		originReq.Header.Set(headerForwarded, strings.Join(prior, ", ")+", "+element)
+	} else {
+		originReq.Header.Set(headerForwarded, element)
+	}
+}
+
+// isTrustedProxy checks whether the IP address belongs to one of the trusted proxy networks
+func (p *Proxy) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range p.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client: the peer address, or for trusted proxies the rightmost
+// X-Forwarded-For address not belonging to one of them
+func (p *Proxy) clientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !p.isTrustedProxy(ip) {
+		return ip
+	}
+
+	chain := strings.Split(strings.Join(r.Header.Values(headerXForwardedFor), ","), ",")
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(chain[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !p.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteIP extracts the IP address from a "host:port" remote address, or "unknown" for non-IP peers such as unix sockets
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil || net.ParseIP(host) == nil {
+		return "unknown"
+	}
+	return host
+}
+
+// forwardedNode formats an IP address as an RFC 7239 node, quoting and bracketing IPv6 addresses
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded quotes a Forwarded parameter value when it contains characters outside the token set
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]\" ;,") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// removeFramingHeaders drops the origin's message framing headers, which describe how the origin sent the body
+// rather than the body itself, so they are never stored or replayed as is
+func removeFramingHeaders(headers http.Header) {
+	headers.Del("Transfer-Encoding")
+	headers.Del("Content-Length")
+}
+
+// setContentLength sets Content-Length to the size of the body being sent, so net/http
+// frames the response correctly instead of replaying the origin's framing; a negative length is unknown
+func setContentLength(headers http.Header, status int, length int64) {
+	if length < 0 || !bodyAllowedForStatus(status) {
+		return
+	}
+	headers.Set("Content-Length", strconv.FormatInt(length, 10))
+}
+
+// bodyAllowedForStatus reports whether a response with the given status may include a body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
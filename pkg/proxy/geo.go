@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/geoip"
+)
+
+// Headers telling the origin where the client is
+const (
+	headerGeoCountry = "X-Geo-Country"
+	headerGeoRegion  = "X-Geo-Region"
+)
+
+// GeoLocator finds where an IP address is registered, e.g. a *geoip.DB
+type GeoLocator interface {
+	Lookup(ip net.IP) (geoip.Location, bool)
+}
+
+// SetGeoIP sets the locator whose country and region of the client are forwarded to the origin
+// as X-Geo-Country and X-Geo-Region; with vary the country also selects a cache variant
+func (p *Proxy) SetGeoIP(locator GeoLocator, vary bool) {
+	p.geo = locator
+	p.geoVary = vary
+}
+
+// WithGeoIP sets the locator of clients forwarded to the origin and optionally keying the cache
+func WithGeoIP(locator GeoLocator, vary bool) Option {
+	return func(p *Proxy) { p.SetGeoIP(locator, vary) }
+}
+
+// locate returns where the client of the request is, or false if unknown or no locator is set
+func (p *Proxy) locate(r *http.Request) (geoip.Location, bool) {
+	if p.geo == nil {
+		return geoip.Location{}, false
+	}
+	ip := net.ParseIP(p.clientIP(r))
+	if ip == nil {
+		return geoip.Location{}, false
+	}
+	return p.geo.Lookup(ip)
+}
+
+// geoCountry returns the country keying the cache variant of the request, or an empty string
+func (p *Proxy) geoCountry(r *http.Request) string {
+	if !p.geoVary {
+		return ""
+	}
+	loc, _ := p.locate(r)
+	return loc.Country
+}
+
+// setGeoHeaders tells the origin where the client is, dropping values sent by the client itself
+func (p *Proxy) setGeoHeaders(headers http.Header, r *http.Request) {
+	if p.geo == nil {
+		return
+	}
+	headers.Del(headerGeoCountry)
+	headers.Del(headerGeoRegion)
+
+	loc, ok := p.locate(r)
+	if !ok {
+		return
+	}
+	headers.Set(headerGeoCountry, loc.Country)
+	if loc.Region != "" {
+		headers.Set(headerGeoRegion, loc.Region)
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Header rule directions
+const (
+	DirectionRequest  = "request"  // Applied to requests forwarded to the origin
+	DirectionResponse = "response" // Applied to responses sent to clients
+)
+
+// HeaderRule adds, sets or removes headers on requests or responses matching a path pattern
+type HeaderRule struct {
+	Direction string            `json:"direction"` // DirectionRequest or DirectionResponse
+	Path      string            `json:"path"`      // Path pattern, e.g. "/static/*"; empty matches every path
+	Remove    []string          `json:"remove"`    // Header names to remove
+	Set       map[string]string `json:"set"`       // Headers to set, replacing existing values
+	Add       map[string]string `json:"add"`       // Headers to add next to existing values
+}
+
+// Validate checks that the rule has a known direction and a well-formed path pattern
+func (h *HeaderRule) Validate() error {
+	if h.Direction != DirectionRequest && h.Direction != DirectionResponse {
+		return fmt.Errorf("header rule: unknown direction '%s'", h.Direction)
+	}
+	if _, err := path.Match(h.Path, "/"); err != nil {
+		return fmt.Errorf("header rule: invalid path pattern '%s'", h.Path)
+	}
+	return nil
+}
+
+// apply rewrites the headers according to the rule, removals first, then sets and additions
+func (h *HeaderRule) apply(headers http.Header) {
+	for _, name := range h.Remove {
+		headers.Del(name)
+	}
+	for name, value := range h.Set {
+		headers.Set(name, value)
+	}
+	for name, value := range h.Add {
+		headers.Add(name, value)
+	}
+}
+
+// SetHeaderRules sets the header rewrite rules applied to requests and responses
+func (p *Proxy) SetHeaderRules(rules []HeaderRule) {
+	p.headerRules = rules
+}
+
+// rewriteHeaders applies every rule with the given direction whose path pattern matches the request path
+func (p *Proxy) rewriteHeaders(direction string, headers http.Header, r *http.Request) {
+	for i := range p.headerRules {
+		rule := &p.headerRules[i]
+		if rule.Direction == direction && matchPath(rule.Path, r.URL.Path) {
+			rule.apply(headers)
+		}
+	}
+}
+
+// matchPath checks the path against a pattern; a trailing "*" matches any suffix, otherwise path.Match rules apply
+func matchPath(pattern, p string) bool {
+	if pattern == "" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[") {
+		return strings.HasPrefix(p, prefix)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
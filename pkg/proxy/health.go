@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OriginHealth is the current view of the proxy on its origin, telling why traffic may be served stale
+type OriginHealth struct {
+	Healthy             bool      `json:"healthy"`                    // Whether the last origin request or health check succeeded
+	ConsecutiveFailures uint64    `json:"consecutive_failures"`       // Origin requests and health checks failed in a row
+	LastCheck           time.Time `json:"last_check"`                 // Time of the last health check, zero if none ran
+	LastCheckError      string    `json:"last_check_error,omitempty"` // Error of the last health check, empty if it passed
+	LastError           time.Time `json:"last_error"`                 // Time of the last origin error, zero if none
+	LastAnswer          time.Time `json:"last_answer"`                // Time of the last successful origin response, zero if none
+	StaleServed         uint64    `json:"stale_served"`               // Expired copies served because the origin failed
+}
+
+// OriginHealth returns the health of the origin as seen through origin requests and health checks
+func (p *Proxy) OriginHealth() OriginHealth {
+	failures := p.stats.originFailures.Load()
+	checkError, _ := p.stats.lastCheckError.Load().(string)
+	return OriginHealth{
+		Healthy:             failures == 0,
+		ConsecutiveFailures: failures,
+		LastCheck:           unixTime(p.stats.lastCheck.Load()),
+		LastCheckError:      checkError,
+		LastError:           unixTime(p.stats.lastOriginError.Load()),
+		LastAnswer:          unixTime(p.stats.lastOriginAnswer.Load()),
+		StaleServed:         p.stats.stale.Load(),
+	}
+}
+
+// recordOriginCheck records the outcome of a health check, which counts towards the consecutive failures
+func (p *Proxy) recordOriginCheck(err error) {
+	p.stats.lastCheck.Store(time.Now().UnixNano())
+	if err != nil {
+		p.stats.lastCheckError.Store(err.Error())
+		p.stats.originFailures.Add(1)
+		return
+	}
+	p.stats.lastCheckError.Store("")
+	p.stats.originFailures.Store(0)
+}
+
+// CheckOrigin reports whether the proxy can answer requests: the origin responds, with any status,
+// or the proxy does not need it because it serves from the cache only or may serve stale copies
+func (p *Proxy) CheckOrigin(ctx context.Context) error {
+	if p.offline || p.recordReplay == ModeReplay {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.origin.String(), nil)
+	if err != nil {
+		return err
+	}
+	if err := p.authenticate(req); err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	p.recordOriginCheck(err)
+	if err != nil {
+		if p.staleOnErrorWindow > 0 {
+			return nil
+		}
+		return fmt.Errorf("origin unreachable: %w", err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HedgeAuto makes the hedging delay follow the 95th percentile of recent origin latencies
+const HedgeAuto = -1
+
+// Adaptive hedging parameters
+const (
+	latencySamples       = 256                    // Number of recent origin latencies kept
+	minLatencySamples    = 20                     // Samples needed before the percentile is trusted
+	defaultHedgeDelay    = 100 * time.Millisecond // Delay used while there are too few samples
+	hedgeDelayPercentile = 0.95                   // Percentile of latencies used as the delay
+)
+
+// latencyTracker keeps a ring of recent origin latencies
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// add records a latency sample, replacing the oldest one once the ring is full
+func (t *latencyTracker) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencySamples
+}
+
+// percentile returns the latency at the given quantile, or false if there are too few samples
+func (t *latencyTracker) percentile(q float64) (time.Duration, bool) {
+	t.mu.Lock()
+	sorted := slices.Clone(t.samples)
+	t.mu.Unlock()
+
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	return sorted[int(float64(len(sorted)-1)*q)], true
+}
+
+// SetHedgeDelay sets how long to wait for the origin before sending a second identical GET or HEAD request;
+// zero disables hedging and HedgeAuto follows the 95th percentile of recent origin latencies
+func (p *Proxy) SetHedgeDelay(delay time.Duration) {
+	p.hedgeDelay = delay
+}
+
+// currentHedgeDelay returns the delay after which a hedged request is sent
+func (p *Proxy) currentHedgeDelay() time.Duration {
+	if p.hedgeDelay != HedgeAuto {
+		return p.hedgeDelay
+	}
+	if delay, ok := p.latencies.percentile(hedgeDelayPercentile); ok {
+		return delay
+	}
+	return defaultHedgeDelay
+}
+
+// hedgeResult is the outcome of one attempt of a hedged request
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+}
+
+// sendHedged sends the request and, if no response arrives within the hedge delay, a second identical one,
+// returning whichever answers first and canceling the other
+func (p *Proxy) sendHedged(r *http.Request) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func() {
+		ctx, cancel := context.WithCancel(r.Context())
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := p.sendToOrigin(r.Clone(ctx))
+			results <- hedgeResult{attempt, resp, err}
+		}()
+	}
+
+	launch()
+	received := 0
+	timer := time.NewTimer(p.currentHedgeDelay())
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			p.logger.Printf("Origin slow for URL %s, sending hedged request", r.URL.String())
+			launch()
+
+		case res := <-results:
+			received++
+			if res.err != nil {
+				cancels[res.attempt]()
+				lastErr = res.err
+				// Hedging only cuts latency, an error before the hedge is sent is reported as is
+				if received == len(cancels) {
+					return nil, lastErr
+				}
+				continue
+			}
+
+			// The other attempt, if still running, is canceled and its response discarded
+			if received < len(cancels) {
+				for i, cancel := range cancels {
+					if i != res.attempt {
+						cancel()
+					}
+				}
+				go func() {
+					if loser := <-results; loser.err == nil {
+						_ = loser.resp.Body.Close()
+					}
+				}()
+			}
+			res.resp.Body = &cancelOnClose{res.resp.Body, cancels[res.attempt]}
+			return res.resp, nil
+		}
+	}
+}
+
+// cancelOnClose cancels the request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and releases the request context
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
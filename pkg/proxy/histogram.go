@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram bucket upper bounds
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}                     // Seconds
+	sizeBuckets    = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20} // Bytes
+)
+
+// maxMetricSeries limits the number of route and status class pairs tracked,
+// so clients requesting random paths cannot grow the metrics without bound
+const maxMetricSeries = 256
+
+// otherRoute is the route of requests beyond the series limit
+const otherRoute = "other"
+
+// histogram counts observations into buckets with fixed upper bounds, the last bucket counting the rest
+type histogram struct {
+	bounds []float64       // Bucket upper bounds, ascending
+	counts []atomic.Uint64 // Observations per bucket, one more than bounds
+	sum    atomic.Uint64   // Sum of the observations, in float64 bits
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// observe counts the value into its bucket
+func (h *histogram) observe(value float64) {
+	i, _ := slices.BinarySearch(h.bounds, value)
+	h.counts[i].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
+
+// Histogram is a snapshot of a histogram, with cumulative counts as in Prometheus
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"` // Cumulative counts by upper bound, ascending
+	Count   uint64   `json:"count"`   // Number of observations
+	Sum     float64  `json:"sum"`     // Sum of the observations
+}
+
+// Bucket counts the observations up to an upper bound
+type Bucket struct {
+	UpperBound float64 `json:"le"`    // Inclusive upper bound
+	Count      uint64  `json:"count"` // Observations less than or equal to the bound
+}
+
+// snapshot returns the current counts of the histogram
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{Buckets: make([]Bucket, len(h.bounds)), Sum: math.Float64frombits(h.sum.Load())}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+		if i < len(h.bounds) {
+			s.Buckets[i] = Bucket{UpperBound: h.bounds[i], Count: s.Count}
+		}
+	}
+	return s
+}
+
+// OriginMetrics holds the origin latency and response size histograms of a route and status class
+type OriginMetrics struct {
+	Route       string    `json:"route"`        // Route of the requests, see Proxy.OriginMetrics
+	StatusClass string    `json:"status_class"` // "2xx" to "5xx", or "error" when no response was received
+	Latency     Histogram `json:"latency"`      // Seconds until the origin response headers arrived
+	Size        Histogram `json:"size"`         // Bytes of the origin response bodies
+}
+
+// originSeries are the histograms of a route and status class
+type originSeries struct {
+	latency *histogram
+	size    *histogram
+}
+
+// originMetrics tracks origin histograms by route and status class
+type originMetrics struct {
+	mu     sync.RWMutex
+	series map[[2]string]*originSeries
+}
+
+// get returns the series of the route and status class, creating it if needed
+func (m *originMetrics) get(route, class string) *originSeries {
+	key := [2]string{route, class}
+	m.mu.RLock()
+	s, ok := m.series[key]
+	m.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok = m.series[key]; ok {
+		return s
+	}
+	if m.series == nil {
+		m.series = make(map[[2]string]*originSeries)
+	}
+	if len(m.series) >= maxMetricSeries && route != otherRoute {
+		key[0] = otherRoute
+		if s, ok = m.series[key]; ok {
+			return s
+		}
+	}
+	s = &originSeries{latency: newHistogram(latencyBuckets), size: newHistogram(sizeBuckets)}
+	m.series[key] = s
+	return s
+}
+
+// observeOriginLatency records how long the origin took to answer the request
+func (p *Proxy) observeOriginLatency(r *http.Request, resp *http.Response, err error, latency time.Duration) {
+	class := "error"
+	if err == nil {
+		class = statusClass(resp.StatusCode)
+	}
+	p.originMetrics.get(p.metricsRoute(r), class).latency.observe(latency.Seconds())
+}
+
+// observeOriginSize records the size of the origin response body
+func (p *Proxy) observeOriginSize(r *http.Request, status int, size int) {
+	p.originMetrics.get(p.metricsRoute(r), statusClass(status)).size.observe(float64(size))
+}
+
+// OriginMetrics returns the origin latency and response size histograms by route and status class,
+// the route being labeled as in RouteStats
+func (p *Proxy) OriginMetrics() []OriginMetrics {
+	p.originMetrics.mu.RLock()
+	metrics := make([]OriginMetrics, 0, len(p.originMetrics.series))
+	for key, s := range p.originMetrics.series {
+		metrics = append(metrics, OriginMetrics{
+			Route:       key[0],
+			StatusClass: key[1],
+			Latency:     s.latency.snapshot(),
+			Size:        s.size.snapshot(),
+		})
+	}
+	p.originMetrics.mu.RUnlock()
+
+	slices.SortFunc(metrics, func(a, b OriginMetrics) int {
+		return cmp.Or(cmp.Compare(a.Route, b.Route), cmp.Compare(a.StatusClass, b.StatusClass))
+	})
+	return metrics
+}
+
+// statusClass returns the class of an HTTP status, such as "2xx"
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
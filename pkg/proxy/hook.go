@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHookTimeout is the time limit for one hook exchange when none is configured
+const defaultHookTimeout = 100 * time.Millisecond
+
+// defaultHookWorkers is the number of hook script processes started when none is configured
+const defaultHookWorkers = 4
+
+// Hook phases
+const (
+	HookPhaseRequest  = "request"  // Sent before a request is handled
+	HookPhaseResponse = "response" // Sent for every origin response before it is cached
+)
+
+// HookConfig describes the hook script the proxy starts and consults for requests and responses
+type HookConfig struct {
+	Command []string `json:"command"` // Program and arguments of the hook script
+	Timeout Duration `json:"timeout"` // Time limit for one exchange, 100ms by default
+	Workers int      `json:"workers"` // Number of script processes the exchanges are spread over, 4 by default
+}
+
+// Validate checks that the hook has a command
+func (h *HookConfig) Validate() error {
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return errors.New("hook: command is required")
+	}
+	if h.Timeout < 0 {
+		return errors.New("hook: timeout must not be negative")
+	}
+	if h.Workers < 0 {
+		return errors.New("hook: workers must not be negative")
+	}
+	return nil
+}
+
+// hookMessage is written to the hook script as a single JSON line
+type hookMessage struct {
+	ID      uint64      `json:"id"`               // Matches the reply to the message
+	Phase   string      `json:"phase"`            // HookPhaseRequest or HookPhaseResponse
+	Method  string      `json:"method"`           // Request method
+	URL     string      `json:"url"`              // Request URL
+	Status  int         `json:"status,omitempty"` // Origin response status, response phase only
+	Headers http.Header `json:"headers"`          // Request or response headers
+}
+
+// hookReply is read from the hook script as a single JSON line
+type hookReply struct {
+	ID      uint64      `json:"id"`      // ID of the message answered
+	Headers http.Header `json:"headers"` // Replacement headers, null keeps them unchanged
+	Cache   *bool       `json:"cache"`   // false keeps the request away from the cache or the response out of it
+}
+
+// Hook is a long-running script exchanging line-delimited JSON with the proxy over its stdin and stdout,
+// so operators can inspect and modify requests and responses, and veto caching, without recompiling.
+// Several processes of the script run side by side and each one is sent further messages without waiting
+// for its replies, which are matched by ID, so a slow exchange does not hold up the others.
+// A script that fails or is too slow is ignored and the request proceeds unchanged.
+type Hook struct {
+	timeout time.Duration
+	workers []*hookWorker
+	nextID  atomic.Uint64
+}
+
+// hookWorker is one process of the hook script with the exchanges waiting for its replies
+type hookWorker struct {
+	stdin   io.WriteCloser
+	lines   chan []byte // Messages to write, taken only while the script reads its input
+	mu      sync.Mutex  // Guards pending and exited
+	pending map[uint64]chan hookReply
+	exited  bool
+}
+
+// StartHook starts the processes of the hook script
+func StartHook(cfg HookConfig) (*Hook, error) {
+	h := &Hook{timeout: time.Duration(cfg.Timeout)}
+	if h.timeout == 0 {
+		h.timeout = defaultHookTimeout
+	}
+	workers := cfg.Workers
+	if workers == 0 {
+		workers = defaultHookWorkers
+	}
+	for range workers {
+		w, err := startHookWorker(cfg.Command)
+		if err != nil {
+			for _, started := range h.workers {
+				_ = started.stdin.Close()
+			}
+			return nil, err
+		}
+		h.workers = append(h.workers, w)
+	}
+	return h, nil
+}
+
+// startHookWorker starts one process of the hook script
+func startHookWorker(command []string) (*hookWorker, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start hook: %w", err)
+	}
+
+	w := &hookWorker{stdin: stdin, lines: make(chan []byte), pending: make(map[uint64]chan hookReply)}
+	go w.writeMessages()
+	go w.readReplies(stdout)
+	go func() {
+		err := cmd.Wait()
+		log.Printf("Hook exited: %v", err)
+	}()
+	return w, nil
+}
+
+// writeMessages writes the messages to the script one line at a time; a script that stops reading blocks
+// only this goroutine, the exchanges give up handing it messages when their time is up
+func (w *hookWorker) writeMessages() {
+	for line := range w.lines {
+		if _, err := w.stdin.Write(line); err != nil {
+			log.Printf("Hook: %s", err)
+		}
+	}
+}
+
+// readReplies hands the replies of the script to the exchanges waiting for them until its output is closed
+func (w *hookWorker) readReplies(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var reply hookReply
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			log.Printf("Hook: invalid reply: %s", err)
+			continue
+		}
+		// Late replies to timed out messages find nobody waiting and are skipped
+		w.mu.Lock()
+		ch, ok := w.pending[reply.ID]
+		delete(w.pending, reply.ID)
+		w.mu.Unlock()
+		if ok {
+			ch <- reply
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.exited = true
+	for id, ch := range w.pending {
+		close(ch)
+		delete(w.pending, id)
+	}
+}
+
+// call sends the message to one of the script processes and waits for its reply;
+// it reports false if the script failed or timed out
+func (h *Hook) call(msg *hookMessage) (hookReply, bool) {
+	msg.ID = h.nextID.Add(1)
+	w := h.workers[msg.ID%uint64(len(h.workers))]
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return hookReply{}, false
+	}
+
+	// The reply channel is buffered, so the reader never waits for an exchange that gave up
+	replies := make(chan hookReply, 1)
+	w.mu.Lock()
+	if w.exited {
+		w.mu.Unlock()
+		return hookReply{}, false
+	}
+	w.pending[msg.ID] = replies
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.pending, msg.ID)
+		w.mu.Unlock()
+	}()
+
+	// The time limit covers handing the message over as well, the script may have stopped reading
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	select {
+	case w.lines <- append(line, '\n'):
+	case <-timer.C:
+		log.Printf("Hook: script did not take %s %s within %s", msg.Method, msg.URL, h.timeout)
+		return hookReply{}, false
+	}
+	select {
+	case reply, ok := <-replies:
+		return reply, ok
+	case <-timer.C:
+		log.Printf("Hook: no reply for %s %s within %s", msg.Method, msg.URL, h.timeout)
+		return hookReply{}, false
+	}
+}
+
+// SetHook sets the script consulted for every request and origin response, nil disables it
+func (p *Proxy) SetHook(h *Hook) {
+	p.hook = h
+}
+
+// runRequestHook lets the hook rewrite the request headers; it reports false if the hook keeps the request away from the cache
+func (p *Proxy) runRequestHook(r *http.Request) bool {
+	if p.hook == nil {
+		return true
+	}
+	reply, ok := p.hook.call(&hookMessage{Phase: HookPhaseRequest, Method: r.Method, URL: r.URL.String(), Headers: r.Header})
+	if !ok {
+		return true
+	}
+	if reply.Headers != nil {
+		r.Header = reply.Headers
+	}
+	return reply.Cache == nil || *reply.Cache
+}
+
+// runResponseHook lets the hook rewrite the origin response headers, e.g. to set the TTL header;
+// it reports false if the hook keeps the response out of the cache
+func (p *Proxy) runResponseHook(r *http.Request, resp *http.Response) bool {
+	if p.hook == nil {
+		return true
+	}
+	reply, ok := p.hook.call(&hookMessage{
+		Phase:   HookPhaseResponse,
+		Method:  r.Method,
+		URL:     r.URL.String(),
+		Status:  resp.StatusCode,
+		Headers: resp.Header,
+	})
+	if !ok {
+		return true
+	}
+	if reply.Headers != nil {
+		resp.Header = reply.Headers
+	}
+	return reply.Cache == nil || *reply.Cache
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopByHopHeaders are meaningful only for a single transport-level connection (RFC 7230, section 6.1)
+// and must not be forwarded by proxies or stored in caches
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders deletes the standard hop-by-hop headers and every header named in Connection
+func removeHopByHopHeaders(headers http.Header) {
+	// Headers listed in Connection are hop-by-hop for this connection only
+	for _, value := range headers.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				headers.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		headers.Del(name)
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SetLanguageVariants sets the primary languages, e.g. "en" or "de", that get their own cache variant;
+// the language of a request is the allowed one the client prefers, requests matching none share the default variant
+func (p *Proxy) SetLanguageVariants(languages []string) {
+	p.languages = nil
+	for _, lang := range languages {
+		p.languages = append(p.languages, strings.ToLower(lang))
+	}
+}
+
+// WithLanguageVariants sets the primary languages that get their own cache variant
+func WithLanguageVariants(languages []string) Option {
+	return func(p *Proxy) { p.SetLanguageVariants(languages) }
+}
+
+// requestLanguage returns the allowed primary language the client prefers, or an empty string if none
+func (p *Proxy) requestLanguage(r *http.Request) string {
+	if len(p.languages) == 0 {
+		return ""
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !slices.Contains(p.languages, primary) {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		// The first of equally weighted languages wins, as listed by the client
+		if q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// setOriginLanguage asks the origin for exactly the language of the cache variant of the request
+func (p *Proxy) setOriginLanguage(headers http.Header, r *http.Request) {
+	if len(p.languages) == 0 {
+		return
+	}
+	if lang := p.requestLanguage(r); lang != "" {
+		headers.Set("Accept-Language", lang)
+	} else {
+		headers.Del("Accept-Language")
+	}
+}
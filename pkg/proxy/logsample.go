@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// hitLogSampler decides which cache hits are logged; misses and errors are always logged
+type hitLogSampler struct {
+	every atomic.Int64  // Log one hit in this many, values below 2 log every hit
+	hits  atomic.Uint64 // Hits seen so far
+}
+
+// sample reports whether the current hit is logged
+func (s *hitLogSampler) sample() bool {
+	every := s.every.Load()
+	return every < 2 || s.hits.Add(1)%uint64(every) == 0
+}
+
+// SetHitLogSampling logs only one cache hit in every n, cutting the logging cost at high request rates;
+// n of 1 or less logs every hit. It is safe to call while the proxy serves requests.
+func (p *Proxy) SetHitLogSampling(n int) {
+	p.hitLog.every.Store(int64(n))
+}
+
+// HitLogSampling returns how many cache hits are counted per logged one
+func (p *Proxy) HitLogSampling() int {
+	return max(int(p.hitLog.every.Load()), 1)
+}
+
+// WithHitLogSampling logs only one cache hit in every n
+func WithHitLogSampling(n int) Option {
+	return func(p *Proxy) { p.SetHitLogSampling(n) }
+}
+
+// logCacheResult counts, publishes and logs how the request was answered, sampling hits in the log
+func (p *Proxy) logCacheResult(result string, r *http.Request) {
+	p.countResult(result, r)
+	p.publishResult(result, r)
+	if strings.HasPrefix(result, "HIT") && !p.hitLog.sample() {
+		return
+	}
+	p.logger.Printf("Cache %s for URL: %s", result, r.URL.String())
+}
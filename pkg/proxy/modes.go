@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// SetOffline sets whether the proxy answers exclusively from the cache without ever contacting the origin
+func (p *Proxy) SetOffline(is bool) {
+	p.offline = is
+}
+
+// SetReadOnlyCache sets whether existing cache entries are served without ever storing new ones
+func (p *Proxy) SetReadOnlyCache(is bool) {
+	p.readOnlyCache = is
+}
+
+// SetNoCache sets whether the proxy runs as a plain reverse proxy without any cache reads or writes.
+// It is safe to call while the proxy serves requests, e.g. to stop serving suspected stale content.
+func (p *Proxy) SetNoCache(is bool) {
+	p.noCache.Store(is)
+}
+
+// NoCache reports whether the proxy runs as a plain reverse proxy
+func (p *Proxy) NoCache() bool {
+	return p.noCache.Load()
+}
+
+// serveOffline answers the request from the cache regardless of expiration, or with 404 if it is not cached
+func (p *Proxy) serveOffline(w http.ResponseWriter, r *http.Request) {
+	var entry *cache.Entry
+	var body cache.Body
+	ok := false
+	if !isNotSafeMethod(r.Method) {
+		entry, body, ok = p.lookup(r.Context(), p.getRequestCacheKey(r))
+	}
+
+	if !ok {
+		w.Header().Set("X-Cache", "MISS")
+		p.writeError(w, r, http.StatusNotFound, "Not found in cache (offline mode)")
+		p.logCacheResult("MISS (offline)", r)
+		return
+	}
+
+	w.Header().Set("X-Cache", "HIT")
+	p.responseFromCache(w, r, entry, body)
+	p.logCacheResult("HIT (offline)", r)
+}
+
+// Record/replay modes
+const (
+	ModeRecord = "record" // Forward every request and store every response as a fixture
+	ModeReplay = "replay" // Serve stored fixtures only, never contacting the origin
+)
+
+// SetRecordReplay sets the record/replay mode: ModeRecord, ModeReplay or empty for normal caching
+func (p *Proxy) SetRecordReplay(mode string) {
+	p.recordReplay = mode
+}
+
+// recordingKey generates a fixture key from the request method, URL and body, restoring the body for forwarding
+func (p *Proxy) recordingKey(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	bodyHash := sha256.Sum256(body)
+	rawKey := strings.Join([]string{r.Method, r.URL.String(), hex.EncodeToString(bodyHash[:])}, "|")
+
+	hash := md5.Sum([]byte(rawKey))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// serveRecording forwards the request to the origin and stores the response, whatever its method or status
+func (p *Proxy) serveRecording(w http.ResponseWriter, r *http.Request) {
+	key, err := p.recordingKey(r)
+	if err != nil {
+		p.writeError(w, r, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	w.Header().Set("X-Cache", "RECORD")
+	p.proxyRequest(w, r, true, key)
+	p.logger.Printf("Recorded %s %s", r.Method, r.URL.String())
+}
+
+// serveReplay answers the request with its stored fixture, or with 404 if it was never recorded
+func (p *Proxy) serveReplay(w http.ResponseWriter, r *http.Request) {
+	key, err := p.recordingKey(r)
+	if err != nil {
+		p.writeError(w, r, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	entry, body, ok := p.lookup(r.Context(), key)
+	if !ok {
+		w.Header().Set("X-Cache", "MISS")
+		p.writeError(w, r, http.StatusNotFound, "Request was not recorded (replay mode)")
+		p.logger.Printf("Replay MISS for %s %s", r.Method, r.URL.String())
+		return
+	}
+
+	w.Header().Set("X-Cache", "REPLAY")
+	p.responseFromCache(w, r, entry, body)
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SetFoldTrailingSlash sets whether paths with and without a trailing slash share a cache entry
+func (p *Proxy) SetFoldTrailingSlash(fold bool) {
+	p.foldTrailingSlash = fold
+}
+
+// WithFoldTrailingSlash sets whether paths with and without a trailing slash share a cache entry
+func WithFoldTrailingSlash(fold bool) Option {
+	return func(p *Proxy) { p.SetFoldTrailingSlash(fold) }
+}
+
+// normalizedURL returns the URL in a canonical form for cache keys, so equivalent URLs share an entry:
+// lowercase scheme and host, dot-segments resolved, unreserved characters decoded and the remaining escapes
+// in uppercase, and optionally without a trailing slash or the ignored query parameters, the extra ones included.
+// URLs already in that form are returned unchanged.
+func (p *Proxy) normalizedURL(u *url.URL, extraIgnored ...string) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+
+	escaped := removeDotSegments(normalizePercentEncoding(u.EscapedPath()))
+	if p.foldTrailingSlash && len(escaped) > 1 {
+		if trimmed := strings.TrimRight(escaped, "/"); trimmed != "" {
+			escaped = trimmed
+		} else {
+			escaped = "/"
+		}
+	}
+	if unescaped, err := url.PathUnescape(escaped); err == nil {
+		n.Path, n.RawPath = unescaped, escaped
+	}
+	n.RawQuery = normalizePercentEncoding(p.keyQuery(n.RawQuery, extraIgnored))
+	return n.String()
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters (RFC 3986, section 2.3)
+// and uppercases the hex digits of the other escapes
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// removeDotSegments resolves "." and ".." path segments (RFC 3986, section 5.2.4)
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			// The empty first segment of an absolute path is never removed
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+		// A trailing dot-segment leaves a trailing slash
+		if i == len(segments)-1 {
+			out = append(out, "")
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// SetIgnoredQueryParams sets the query parameters left out of cache keys but still forwarded to the origin,
+// e.g. "utm_source"; a trailing "*" matches every parameter with that prefix
+func (p *Proxy) SetIgnoredQueryParams(names []string) {
+	p.ignoredQueryParams = names
+}
+
+// WithIgnoredQueryParams sets the query parameters left out of cache keys, see SetIgnoredQueryParams
+func WithIgnoredQueryParams(names []string) Option {
+	return func(p *Proxy) { p.SetIgnoredQueryParams(names) }
+}
+
+// keyQuery removes the ignored parameters, the extra ones included, from the raw query, keeping the order of the others
+func (p *Proxy) keyQuery(rawQuery string, extraIgnored []string) string {
+	if len(p.ignoredQueryParams) == 0 && len(extraIgnored) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !p.queryParamIgnored(name) && !queryParamMatches(name, extraIgnored) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// queryParamIgnored reports whether the query parameter is left out of cache keys
+func (p *Proxy) queryParamIgnored(name string) bool {
+	return queryParamMatches(name, p.ignoredQueryParams)
+}
+
+// queryParamMatches reports whether the query parameter is one of the names, a trailing "*" matching a prefix
+func queryParamMatches(name string, names []string) bool {
+	for _, ignored := range names {
+		if prefix, ok := strings.CutSuffix(ignored, "*"); ok && strings.HasPrefix(name, prefix) || name == ignored {
+			return true
+		}
+	}
+	return false
+}
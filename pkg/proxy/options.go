@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures a Proxy created by New. Options are applied in order, so a later option
+// overrides an earlier one. The Set methods remain for changing the configuration at runtime.
+type Option func(*Proxy)
+
+// WithTransport sets the transport used to reach the origin. It replaces the transport of a file://
+// origin, and must come before WithDNSCacheTTL, WithOriginResolve and WithOriginDNSServers, which dial through it.
+func WithTransport(t http.RoundTripper) Option {
+	return func(p *Proxy) { p.client.Transport = t }
+}
+
+// WithLogger sets the logger the proxy writes its messages to, log.Default() if not set
+func WithLogger(l *log.Logger) Option {
+	return func(p *Proxy) { p.logger = l }
+}
+
+// WithUniqueByUser makes cache keys unique per user based on User-Agent and cookies
+func WithUniqueByUser() Option {
+	return func(p *Proxy) { p.SetUniqueByUser(true) }
+}
+
+// WithHostHeader sets the Host header sent to the origin, see SetHostHeader
+func WithHostHeader(host string) Option {
+	return func(p *Proxy) { p.SetHostHeader(host) }
+}
+
+// WithKeyFunc sets the function deriving raw cache keys, see SetKeyFunc
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(p *Proxy) { p.SetKeyFunc(fn) }
+}
+
+// WithCacheNamespace sets the value mixed into every cache key, see SetCacheNamespace
+func WithCacheNamespace(namespace string) Option {
+	return func(p *Proxy) { p.SetCacheNamespace(namespace) }
+}
+
+// WithTrustedProxies sets the networks whose forwarding headers are trusted
+func WithTrustedProxies(networks []*net.IPNet) Option {
+	return func(p *Proxy) { p.SetTrustedProxies(networks) }
+}
+
+// WithNoCacheClients sets the clients allowed to bypass the cache with no-cache
+func WithNoCacheClients(networks []*net.IPNet) Option {
+	return func(p *Proxy) { p.SetNoCacheClients(networks) }
+}
+
+// WithRewriteBodyHost makes the proxy replace the origin host in HTML and JSON bodies
+func WithRewriteBodyHost() Option {
+	return func(p *Proxy) { p.SetRewriteBodyHost(true) }
+}
+
+// WithCacheSetCookie makes the proxy cache responses with Set-Cookie regardless of the cookie names
+func WithCacheSetCookie() Option {
+	return func(p *Proxy) { p.SetCacheSetCookie(true) }
+}
+
+// WithCacheableCookies sets the cookie names that do not prevent a response from being cached
+func WithCacheableCookies(names []string) Option {
+	return func(p *Proxy) { p.SetCacheableCookies(names) }
+}
+
+// WithDefaultTTL sets the TTL of responses without a TTL of their own
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(p *Proxy) { p.SetDefaultTTL(ttl) }
+}
+
+// WithServeStaleOnError sets how long after expiration a cached copy may be served when the origin fails
+func WithServeStaleOnError(window time.Duration) Option {
+	return func(p *Proxy) { p.SetServeStaleOnError(window) }
+}
+
+// WithSoftTTL sets the age after which cached responses are refreshed in the background
+func WithSoftTTL(ttl time.Duration) Option {
+	return func(p *Proxy) { p.SetSoftTTL(ttl) }
+}
+
+// WithEarlyRefresh sets the eagerness of probabilistic refresh shortly before expiry
+func WithEarlyRefresh(beta float64) Option {
+	return func(p *Proxy) { p.SetEarlyRefresh(beta) }
+}
+
+// WithTTLHeader sets the origin response header overriding the TTL of the response
+func WithTTLHeader(name string) Option {
+	return func(p *Proxy) { p.SetTTLHeader(name) }
+}
+
+// WithNegativeCaching sets the TTL of cached 404, 410 and optionally 5xx responses
+func WithNegativeCaching(ttl time.Duration, include5xx bool) Option {
+	return func(p *Proxy) { p.SetNegativeCaching(ttl, include5xx) }
+}
+
+// WithContentTypeRules sets the rules deciding caching by response Content-Type
+func WithContentTypeRules(rules []ContentTypeRule) Option {
+	return func(p *Proxy) { p.SetContentTypeRules(rules) }
+}
+
+// WithPinned sets the path patterns whose cached responses never expire
+func WithPinned(patterns []string) Option {
+	return func(p *Proxy) { p.SetPinned(patterns) }
+}
+
+// WithOffline makes the proxy answer only from the cache
+func WithOffline() Option {
+	return func(p *Proxy) { p.SetOffline(true) }
+}
+
+// WithReadOnlyCache makes the proxy serve existing cache entries without storing new ones
+func WithReadOnlyCache() Option {
+	return func(p *Proxy) { p.SetReadOnlyCache(true) }
+}
+
+// WithRecordReplay sets the record/replay mode
+func WithRecordReplay(mode string) Option {
+	return func(p *Proxy) { p.SetRecordReplay(mode) }
+}
+
+// WithNoCache makes the proxy forward every request without touching the cache
+func WithNoCache() Option {
+	return func(p *Proxy) { p.SetNoCache(true) }
+}
+
+// WithFallbackOrigin sets the origin used when the primary one fails
+func WithFallbackOrigin(origin *url.URL) Option {
+	return func(p *Proxy) { p.SetFallbackOrigin(origin) }
+}
+
+// WithOriginTimeout sets the time limit for origin requests
+func WithOriginTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) { p.SetOriginTimeout(timeout) }
+}
+
+// WithDNSCacheTTL sets how long resolved origin addresses are reused
+func WithDNSCacheTTL(ttl time.Duration) Option {
+	return func(p *Proxy) { p.SetDNSCacheTTL(ttl) }
+}
+
+// WithOriginResolve sets fixed addresses for origin hosts, see SetOriginResolve
+func WithOriginResolve(overrides map[string]string) Option {
+	return func(p *Proxy) { p.SetOriginResolve(overrides) }
+}
+
+// WithOriginDNSServers sets the DNS servers resolving the origin hosts, see SetOriginDNSServers
+func WithOriginDNSServers(servers []string) Option {
+	return func(p *Proxy) { p.SetOriginDNSServers(servers) }
+}
+
+// WithHedgeDelay sets the delay before a hedged request is sent to a slow origin
+func WithHedgeDelay(delay time.Duration) Option {
+	return func(p *Proxy) { p.SetHedgeDelay(delay) }
+}
+
+// WithShadow sets the secondary origin receiving a copy of percent of the traffic
+func WithShadow(origin *url.URL, percent float64) Option {
+	return func(p *Proxy) { p.SetShadow(origin, percent) }
+}
+
+// WithHeaderRules sets the header rewrite rules for requests and responses
+func WithHeaderRules(rules []HeaderRule) Option {
+	return func(p *Proxy) { p.SetHeaderRules(rules) }
+}
+
+// WithCORSRules sets the CORS rules answered and injected by the proxy
+func WithCORSRules(rules []CORSRule) Option {
+	return func(p *Proxy) { p.SetCORSRules(rules) }
+}
+
+// WithErrorPages sets the templates for errors generated by the proxy
+func WithErrorPages(pages *ErrorPages) Option {
+	return func(p *Proxy) { p.SetErrorPages(pages) }
+}
+
+// WithHook sets the script consulted for requests and responses
+func WithHook(h *Hook) Option {
+	return func(p *Proxy) { p.SetHook(h) }
+}
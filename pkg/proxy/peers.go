@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// peerHeader marks the requests instances of a cluster send each other
+const peerHeader = "X-Cache-Peer"
+
+// peerFill is the value of peerHeader asking the owner of a key to answer from its cache or fill it from the origin
+const peerFill = "fill"
+
+// peerVirtualNodes is the number of points every instance has on the hash ring, spreading the keys evenly
+const peerVirtualNodes = 128
+
+// peerLookupTimeout bounds the resolution of the peer hosts allowed to request fills
+const peerLookupTimeout = 5 * time.Second
+
+// cluster is the set of instances sharing their cache, replaced as a whole when the peers change
+type cluster struct {
+	self    string              // URL of this instance on the ring
+	selfURL *url.URL            // URL of this instance as the peers list it
+	peers   map[string]*url.URL // Other instances by their URL on the ring
+	addrs   map[string]bool     // Addresses of the peers, the only clients whose fill requests are honored
+	points  []uint64            // Hashes of the ring points, sorted
+	owners  []string            // Instance owning each point
+}
+
+// SetPeers makes the proxy one instance of a cluster sharing its cache: every key is owned by one instance
+// picked by consistent hashing, and cache misses on keys owned by a peer are filled from that peer, which
+// fetches from the origin once for the whole cluster. self is the URL of this instance as the peers list it;
+// every instance must list the same URLs. No peers turns the cluster mode off.
+func (p *Proxy) SetPeers(self *url.URL, peers []*url.URL) {
+	if len(peers) == 0 {
+		p.cluster.Store(nil)
+		return
+	}
+
+	c := &cluster{self: peerName(self), selfURL: self, peers: make(map[string]*url.URL, len(peers)), addrs: make(map[string]bool)}
+	members := []string{c.self}
+	for _, peer := range peers {
+		name := peerName(peer)
+		if name == c.self || c.peers[name] != nil {
+			continue
+		}
+		c.peers[name] = peer
+		members = append(members, name)
+		for _, addr := range lookupPeer(peer.Hostname()) {
+			c.addrs[addr] = true
+		}
+	}
+
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(members)*peerVirtualNodes)
+	for _, member := range members {
+		for i := range peerVirtualNodes {
+			points = append(points, point{ringHash(member + "#" + strconv.Itoa(i)), member})
+		}
+	}
+	slices.SortFunc(points, func(a, b point) int {
+		return cmpUint64(a.hash, b.hash)
+	})
+	for _, pt := range points {
+		c.points = append(c.points, pt.hash)
+		c.owners = append(c.owners, pt.owner)
+	}
+	p.cluster.Store(c)
+}
+
+// WithPeers makes the proxy one instance of a cluster sharing its cache, see SetPeers
+func WithPeers(self *url.URL, peers []*url.URL) Option {
+	return func(p *Proxy) { p.SetPeers(self, peers) }
+}
+
+// peerName returns the URL identifying an instance on the ring
+func peerName(u *url.URL) string {
+	return strings.TrimRight(u.String(), "/")
+}
+
+// lookupPeer returns the addresses of a peer host, which is returned as is if it is an address already
+func lookupPeer(host string) []string {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peerLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// ringHash hashes a key or ring point
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func cmpUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// owner returns the instance owning the key: the one with the first ring point at or after the key hash
+func (c *cluster) owner(key string) string {
+	h := ringHash(key)
+	i := sort.Search(len(c.points), func(i int) bool { return c.points[i] >= h })
+	if i == len(c.points) {
+		i = 0
+	}
+	return c.owners[i]
+}
+
+// peerOwner returns the peer owning the cache key, or nil if this instance owns it or there is no cluster
+func (p *Proxy) peerOwner(cacheKey string) *url.URL {
+	c := p.cluster.Load()
+	if c == nil || cacheKey == "" {
+		return nil
+	}
+	return c.peers[c.owner(cacheKey)]
+}
+
+// KeyOwner returns the URL of the instance of the cluster owning the cache key of the request, as the peers
+// list it, so every request of a warm run can be sent to its owner; nil outside a cluster
+func (p *Proxy) KeyOwner(r *http.Request) *url.URL {
+	c := p.cluster.Load()
+	if c == nil {
+		return nil
+	}
+	if peer := c.peers[c.owner(p.getRequestCacheKey(r))]; peer != nil {
+		return peer
+	}
+	return c.selfURL
+}
+
+// isPeerFill reports whether the request is a fill request of a peer; the marker is dropped from requests
+// of any other client, so it cannot be used to reach the cache without the response processing
+func (p *Proxy) isPeerFill(r *http.Request) bool {
+	if r.Header.Get(peerHeader) == "" {
+		return false
+	}
+	if c := p.cluster.Load(); c != nil && r.Header.Get(peerHeader) == peerFill && c.addrs[remoteIP(r.RemoteAddr)] {
+		return true
+	}
+	r.Header.Del(peerHeader)
+	return false
+}
+
+// errPeerFillUnsupported is returned for requests that are never filled from a peer
+var errPeerFillUnsupported = errors.New("request not eligible for a peer fill")
+
+// fillFromPeer asks the peer owning the key for the response, as it is cached or fetched by the peer.
+// Only GET requests being cached are filled from peers; on failure the caller falls back to the origin.
+func (p *Proxy) fillFromPeer(r *http.Request, cacheKey string) (*http.Response, error) {
+	peer := p.peerOwner(cacheKey)
+	if peer == nil || r.Method != http.MethodGet {
+		return nil, errPeerFillUnsupported
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, p.peerURL(peer, r.URL).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The peer keys the request on the same headers and host, the conditions of the client are answered here
+	req.Header = r.Header.Clone()
+	removeHopByHopHeaders(req.Header)
+	removeClientConditions(req.Header)
+	req.Header.Set(peerHeader, peerFill)
+	req.Host = r.Host
+
+	resp, err := p.peerClient().Do(req)
+	if err != nil {
+		if r.Context().Err() == nil {
+			p.logger.Printf("Error filling from peer %s: %s for URL %s", peerName(peer), err, r.URL.String())
+		}
+		return nil, err
+	}
+	resp.Header.Del(peerHeader)
+	return resp, nil
+}
+
+// peerURL returns the URL of the request on a peer, which is mounted under the same path prefix
+func (p *Proxy) peerURL(peer, u *url.URL) *url.URL {
+	path, rawPath := u.Path, u.RawPath
+	if p.pathPrefix != "" {
+		path = p.pathPrefix + strings.TrimPrefix(path, p.pathPrefixReplacement)
+		rawPath = ""
+	}
+	return originURL(peer, &url.URL{Path: path, RawPath: rawPath, RawQuery: u.RawQuery})
+}
+
+// peerClient returns the client for the requests to peers: it never follows redirects nor negotiates
+// compression of its own, so the peers see the encoding the client asked for
+func (p *Proxy) peerClient() *http.Client {
+	p.peerClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DisableCompression = true
+		p.peerHTTPClient = &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	})
+	return p.peerHTTPClient
+}
+
+// servePeerFill answers a fill request of a peer with the entry as it is cached, fetching and caching it
+// first if needed. The entry is sent without the changes made for clients, which the peer applies itself.
+func (p *Proxy) servePeerFill(w http.ResponseWriter, r *http.Request) {
+	cacheKey := p.getRequestCacheKey(r)
+	entry, body, ok := p.lookup(r.Context(), cacheKey)
+	if ok && p.isExpired(entry) {
+		closeBody(body)
+		ok = false
+	}
+	if ok {
+		defer closeBody(body)
+		for name, values := range entry.Header {
+			w.Header()[name] = slices.Clone(values)
+		}
+		w.Header().Set(peerHeader, "HIT")
+		content, size := io.Reader(body), int64(-1)
+		if body != nil {
+			size = body.Size()
+		} else {
+			content, size = bytes.NewReader(entry.Body), int64(len(entry.Body))
+		}
+		status := entry.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		setContentLength(w.Header(), status, size)
+		w.WriteHeader(status)
+		if bodyAllowedForStatus(status) {
+			_, _ = io.Copy(w, content)
+		}
+		p.logger.Printf("Cache HIT for peer fill of URL: %s", r.URL.String())
+		return
+	}
+
+	resp, err := p.getResponseFromOrigin(r)
+	if err != nil {
+		p.writeError(w, r, originErrorStatus(err), "Failed to fetch data from origin")
+		return
+	}
+	defer resp.Body.Close()
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	large, err := p.readBody(resp, buf)
+	if err != nil {
+		p.writeError(w, r, originErrorStatus(err), "Failed to read response body")
+		return
+	}
+
+	removeHopByHopHeaders(resp.Header)
+	removeFramingHeaders(resp.Header)
+	p.relocate(resp.Header, resp.StatusCode)
+	if p.runResponseHook(r, resp) {
+		if !large {
+			p.storeResponse(r, cacheKey, resp, buf.Bytes())
+		} else if p.isCacheableResponse(resp) {
+			p.cacheInBackground(r, cacheKey)
+		}
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	w.Header().Set(peerHeader, "MISS")
+	// A response above the stream threshold is passed to the peer as it arrives
+	if large {
+		setContentLength(w.Header(), resp.StatusCode, resp.ContentLength)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(buf.Bytes())
+		n, _ := io.Copy(w, resp.Body)
+		p.observeOriginSize(r, resp.StatusCode, buf.Len()+int(n))
+		p.logger.Printf("Cache MISS for peer fill of URL, streamed through: %s", r.URL.String())
+		return
+	}
+	p.observeOriginSize(r, resp.StatusCode, buf.Len())
+	setContentLength(w.Header(), resp.StatusCode, int64(buf.Len()))
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(buf.Bytes())
+	p.logger.Printf("Cache MISS for peer fill of URL: %s", r.URL.String())
+}
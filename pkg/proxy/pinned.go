@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+)
+
+// ValidatePinned checks that every pinned path pattern is well-formed
+func ValidatePinned(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, "/"); err != nil || pattern == "" {
+			return fmt.Errorf("pinned: invalid path pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// SetPinned sets the path patterns whose cached responses never expire
+func (p *Proxy) SetPinned(patterns []string) {
+	p.pinned = patterns
+}
+
+// isPinned checks whether the request path matches one of the pinned patterns
+func (p *Proxy) isPinned(r *http.Request) bool {
+	for _, pattern := range p.pinned {
+		if matchPath(pattern, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// OriginPool tunes the connections to the origin; zero values keep the defaults of the Go HTTP transport
+type OriginPool struct {
+	MaxIdleConns       int           // Idle connections kept open, per host as well, since the proxy reaches a single origin
+	MaxConnsPerHost    int           // Connections per host, idle, active and dialing alike
+	IdleTimeout        time.Duration // How long an idle connection is kept open
+	DisableCompression bool          // Whether to stop asking for gzip on the wire for clients accepting no coding
+}
+
+// SetOriginPool tunes the connections to the origin
+func (p *Proxy) SetOriginPool(pool OriginPool) {
+	t := p.originTransport()
+	if pool.MaxIdleConns > 0 {
+		t.MaxIdleConns = pool.MaxIdleConns
+		t.MaxIdleConnsPerHost = pool.MaxIdleConns
+	}
+	if pool.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = pool.MaxConnsPerHost
+	}
+	if pool.IdleTimeout > 0 {
+		t.IdleConnTimeout = pool.IdleTimeout
+	}
+	t.DisableCompression = pool.DisableCompression
+}
+
+// WithOriginPool tunes the connections to the origin, see SetOriginPool
+func WithOriginPool(pool OriginPool) Option {
+	return func(p *Proxy) { p.SetOriginPool(pool) }
+}
+
+// originTransport returns the transport of the origin client, installing a copy of the default one
+// if the client has none of its own or a transport of another type
+func (p *Proxy) originTransport() *http.Transport {
+	transport, ok := p.client.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		p.client.Transport = transport
+	}
+	return transport
+}
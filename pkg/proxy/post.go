@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+)
+
+// defaultPostBodySize is the largest request body hashed for a POST cache rule without its own limit
+const defaultPostBodySize = 64 << 10
+
+// PostCacheRule opts the POST requests of matching paths, such as search APIs, into the cache,
+// keyed by the URL and a hash of the request body
+type PostCacheRule struct {
+	Path        string `json:"path"`          // Path pattern, e.g. "/api/search"; empty matches every path
+	MaxBodySize int64  `json:"max_body_size"` // Largest request body in bytes hashed for the key, 0 for 64 KiB
+}
+
+// Validate checks that the rule has a well-formed path pattern and a non-negative body size limit
+func (c *PostCacheRule) Validate() error {
+	if _, err := path.Match(c.Path, "/"); err != nil {
+		return fmt.Errorf("post cache rule: invalid path pattern '%s'", c.Path)
+	}
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("post cache rule: negative max_body_size for path '%s'", c.Path)
+	}
+	return nil
+}
+
+// SetPostCacheRules sets the paths whose POST responses are cached
+func (p *Proxy) SetPostCacheRules(rules []PostCacheRule) {
+	p.postCacheRules = rules
+}
+
+// WithPostCacheRules sets the paths whose POST responses are cached
+func WithPostCacheRules(rules []PostCacheRule) Option {
+	return func(p *Proxy) { p.SetPostCacheRules(rules) }
+}
+
+// postCacheKey generates the cache key of a POST request matching a rule from its regular key and a hash
+// of its body. Requests matching no rule, or with a body over the limit of the rule, are not cached.
+// The body is restored for forwarding either way.
+func (p *Proxy) postCacheKey(r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		return "", false
+	}
+	rule := p.findPostCacheRule(r)
+	if rule == nil {
+		return "", false
+	}
+	limit := rule.MaxBodySize
+	if limit == 0 {
+		limit = defaultPostBodySize
+	}
+
+	// One byte over the limit tells that the body is too large, without reading the rest of it
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > limit {
+		return "", false
+	}
+
+	hash := sha256.Sum256(body)
+	return p.hashKey(p.getRequestCacheKey(r) + "|body=" + hex.EncodeToString(hash[:])), true
+}
+
+// findPostCacheRule returns the first POST cache rule matching the request path, or nil
+func (p *Proxy) findPostCacheRule(r *http.Request) *PostCacheRule {
+	for i := range p.postCacheRules {
+		if matchPath(p.postCacheRules[i].Path, r.URL.Path) {
+			return &p.postCacheRules[i]
+		}
+	}
+	return nil
+}
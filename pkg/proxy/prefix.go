@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SetPathPrefix sets the path prefix the proxy is mounted under by an outer router: it is replaced with
+// the replacement, usually empty, in request paths before they are keyed and forwarded, and requests
+// outside the prefix are answered 404. An empty prefix serves every path as is.
+func (p *Proxy) SetPathPrefix(prefix, replacement string) {
+	p.pathPrefix = strings.TrimRight(prefix, "/")
+	p.pathPrefixReplacement = strings.TrimRight(replacement, "/")
+}
+
+// WithPathPrefix sets the path prefix the proxy is mounted under, see SetPathPrefix
+func WithPathPrefix(prefix, replacement string) Option {
+	return func(p *Proxy) { p.SetPathPrefix(prefix, replacement) }
+}
+
+// mapPathPrefix returns the request with the path prefix replaced, or false if its path is outside the prefix.
+// The request is copied, as http.StripPrefix does, so the caller's one is left unchanged.
+func (p *Proxy) mapPathPrefix(r *http.Request) (*http.Request, bool) {
+	if p.pathPrefix == "" {
+		return r, true
+	}
+	rest, ok := cutPathPrefix(r.URL.Path, p.pathPrefix)
+	if !ok {
+		return r, false
+	}
+
+	u := *r.URL
+	u.Path = p.pathPrefixReplacement + rest
+	u.RawPath = ""
+	if r.URL.RawPath != "" {
+		// The prefix is matched unescaped, so it is cut from the raw path with its escaped form
+		if rawRest, ok := cutPathPrefix(r.URL.RawPath, (&url.URL{Path: p.pathPrefix}).EscapedPath()); ok {
+			u.RawPath = (&url.URL{Path: p.pathPrefixReplacement}).EscapedPath() + rawRest
+		}
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	mapped := new(http.Request)
+	*mapped = *r
+	mapped.URL = &u
+	return mapped, true
+}
+
+// cutPathPrefix returns the path without the prefix, matching whole segments only, so "/app" cuts "/app/x"
+// to "/x" but leaves "/apple" alone
+func cutPathPrefix(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+// publicPrefix returns the prefix clients see in front of the paths of the origin, for the links of rewritten bodies;
+// a remapped prefix has no single counterpart on the origin, so links are then left without one
+func (p *Proxy) publicPrefix() string {
+	if p.pathPrefixReplacement != "" {
+		return ""
+	}
+	return p.pathPrefix
+}
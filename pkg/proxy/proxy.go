@@ -0,0 +1,681 @@
+// Package proxy implements the caching reverse proxy, usable on its own or embedded in other services.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// Cache stores the responses of the proxy as whole entries; caches that also implement
+// cache.Streamer have their bodies streamed to clients instead of loaded into memory
+type Cache = cache.Cache
+
+type Proxy struct {
+	client                *http.Client      // HTTP client used to reach the origin
+	cache                 Cache             // The cache implementation used by the proxy
+	origin                *url.URL          // The origin server to which requests are forwarded
+	uniqueByUser          bool              // Determines whether to create unique cache keys per user
+	languages             []string          // Primary languages with their own cache variant
+	geo                   GeoLocator        // Locator of clients, nil when GeoIP is not used
+	geoVary               bool              // Whether the client country selects a cache variant
+	varyRules             []VaryRule        // Request headers added to the cache key per path
+	postCacheRules        []PostCacheRule   // Paths whose POST responses are cached
+	trustedProxies        []*net.IPNet      // Networks whose forwarding headers are honored
+	hostHeader            string            // Host header sent to the origin: empty for the origin host, HostHeaderPreserve or a fixed value
+	headerRules           []HeaderRule      // Header rewrite rules for requests and responses
+	rewriteBodyHost       bool              // Replace the origin host in HTML and JSON bodies with the client-facing host
+	corsRules             []CORSRule        // CORS rules answered and injected by the proxy
+	errorPages            *ErrorPages       // Templates for errors generated by the proxy
+	cacheSetCookie        bool              // Cache responses with Set-Cookie regardless of the cookie names
+	cacheableCookies      []string          // Cookie names that do not prevent a response from being cached
+	negativeTTL           time.Duration     // TTL of cached 404 and 410 responses, zero disables caching them
+	negativeCache5xx      bool              // Cache 5xx responses with the negative TTL
+	contentTypeRules      []ContentTypeRule // Rules deciding caching by response Content-Type
+	noCacheClients        []*net.IPNet      // Clients allowed to force a refetch with no-cache
+	defaultTTL            time.Duration     // TTL of responses without a TTL of their own, zero meaning no expiration
+	staleOnErrorWindow    time.Duration     // How long after expiration a cached copy may be served when the origin fails
+	softTTL               time.Duration     // Age after which cached responses are refreshed in the background
+	earlyRefreshBeta      float64           // Eagerness of probabilistic refresh before expiry, zero disables it
+	revalidating          sync.Map          // Cache keys with a background refresh in progress
+	ttlHeader             string            // Origin response header overriding the TTL of the response
+	pinned                []string          // Path patterns whose cached responses never expire
+	offline               bool              // Answer only from the cache, never contacting the origin
+	readOnlyCache         bool              // Serve existing cache entries but never store new ones
+	recordReplay          string            // Record/replay mode, empty for normal caching
+	noCache               atomic.Bool       // Forward every request without touching the cache, switchable at runtime
+	shadowOrigin          *url.URL          // Secondary origin receiving a copy of a share of requests
+	shadowPercent         float64           // Percentage of requests mirrored to the shadow origin
+	fallbackOrigin        *url.URL          // Secondary origin used when the primary one fails
+	hedgeDelay            time.Duration     // Delay before a hedged request is sent, zero disables hedging
+	latencies             latencyTracker    // Recent origin latencies for adaptive hedging
+	originMetrics         originMetrics     // Origin latency and response size histograms
+	routes                []Route           // Named routes labeling the metrics
+	routeStats            routeStats        // Answer counters by route
+	tenantByHost          bool              // Whether cache entries are kept apart by request host
+	tenantStats           routeStats        // Answer counters by tenant
+	tenantRules           []TenantRule      // Settings overridden per tenant
+	tenantLimiters        []*tenantLimiter  // Rate limits of the tenant rules, nil for rules without one
+	urlHits               urlHits           // Cache hits by URL
+	events                eventStream       // Subscribers to the cache activity
+	resolver              *resolver         // Origin address resolution, nil when the default one is used
+	keyFunc               KeyFunc           // Custom derivation of raw cache keys, nil for the built-in one
+	namespace             string            // Value mixed into every cache key
+	foldTrailingSlash     bool              // Whether paths with and without a trailing slash share a cache entry
+	pathPrefix            string            // Prefix the proxy is mounted under, replaced in request paths
+	pathPrefixReplacement string            // Path replacing the prefix, usually empty
+	originAuth            http.Header       // Credential headers added to the origin requests
+	sigV4                 *SigV4            // AWS SigV4 signing of the origin requests, nil if disabled
+	redirectTTL           time.Duration     // TTL of cached permanent redirects, zero to leave them uncached
+	maxRetryAfter         time.Duration     // Longest Retry-After of the origin honored, zero to ignore it
+	backoffs              backoffs          // Routes the origin asked to retry later
+	ignoredQueryParams    []string          // Query parameters left out of cache keys
+	hook                  *Hook             // Script consulted for requests and responses, nil when none is set
+	logger                *log.Logger       // Logger for the messages of the proxy
+	serverTimeouts        ServerTimeouts    // Timeouts of the server started by Start
+	server                *http.Server      // Server started by Start, nil when not running
+	serverMu              sync.Mutex        // Guards server
+	pendingWrites         sync.WaitGroup    // Cache writes still in progress
+	hitLog                hitLogSampler     // Sampling of the cache hits logged
+	stats                 stats             // Counters reported by Stats
+
+	cluster        atomic.Pointer[cluster] // Instances sharing the cache, nil outside a cluster
+	peerHTTPClient *http.Client            // Client for the requests to peers, created on first use
+	peerClientOnce sync.Once
+
+	replicas []*replica // Standby instances the stored entries are pushed to
+
+	streamThreshold int64    // Body size above which responses stream through uncached, zero for no limit
+	streamCache     bool     // Whether responses above the threshold are cached from a second origin fetch
+	streamFills     sync.Map // Keys being cached from a second origin fetch
+}
+
+// HostHeaderPreserve makes the proxy forward the client's original Host header to the origin
+const HostHeaderPreserve = "preserve"
+
+// New creates a new Proxy instance with the specified cache and origin server URL, configured by the options
+func New(cache Cache, origin *url.URL, opts ...Option) *Proxy {
+	p := &Proxy{client: newOriginClient(origin), cache: cache, origin: origin, logger: log.Default()}
+	p.SetFollowRedirects(DefaultFollowRedirects)
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// newOriginClient creates the HTTP client for the origin; a file:// origin is served from the local directory
+func newOriginClient(origin *url.URL) *http.Client {
+	if origin.Scheme != "file" {
+		return &http.Client{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir(origin.Path)))
+	return &http.Client{Transport: transport}
+}
+
+// SetUniqueByUser sets whether cache keys should be unique per user based on User-Agent and cookies
+func (p *Proxy) SetUniqueByUser(is bool) {
+	p.uniqueByUser = is
+}
+
+// SetHostHeader sets the Host header sent to the origin: empty keeps the origin host,
+// HostHeaderPreserve forwards the client's Host header and any other value is used as is
+func (p *Proxy) SetHostHeader(host string) {
+	p.hostHeader = host
+}
+
+// ServeHTTP implements http.Handler, so the proxy can be mounted on any mux, wrapped with middleware
+// or served next to other proxies in the same process
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, ok := p.mapPathPrefix(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p.stripCredentialHeaders(r.Header)
+	p.handleRequest(w, p.trackRequest(r))
+}
+
+// Handler returns the proxy as an http.Handler that can be served on any listener
+func (p *Proxy) Handler() http.Handler {
+	return p
+}
+
+// handleRequest processes incoming HTTP requests
+func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Peers of a cluster ask the owner of a key for the cached entry, bypassing the client-facing handling
+	if p.isPeerFill(r) {
+		p.servePeerFill(w, r)
+		return
+	}
+
+	// Answer CORS preflights for configured routes without contacting the origin
+	if p.handlePreflight(w, r) {
+		return
+	}
+
+	// Hold a tenant to its own rate limit, so one site cannot starve the others
+	if p.rejectOverLimit(w, r) {
+		return
+	}
+
+	// Let the hook script rewrite the request and decide whether it may use the cache
+	cacheAllowed := p.runRequestHook(r)
+
+	// Mirror a share of the traffic to the shadow origin, without waiting for it
+	p.shadowRequest(r)
+
+	// Record/replay modes turn the proxy into a test fixture with its own keys
+	switch p.recordReplay {
+	case ModeRecord:
+		p.serveRecording(w, r)
+		return
+	case ModeReplay:
+		p.serveReplay(w, r)
+		return
+	}
+
+	// In offline mode the origin is never contacted
+	if p.offline {
+		p.serveOffline(w, r)
+		return
+	}
+
+	if p.noCache.Load() || !cacheAllowed || p.isTenantBypass(r) {
+		// In pass-through mode the cache is neither read nor written
+		w.Header().Set("X-Cache", "BYPASS")
+		p.proxyRequest(w, r, false, "")
+		return
+	}
+
+	// Generate a cache key based on the request
+	var cacheKey string
+	if isNotSafeMethod(r.Method) {
+		// Non-safe methods bypass the cache, except POST requests of routes opted into it
+		key, ok := p.postCacheKey(r)
+		if !ok {
+			w.Header().Set("X-Cache", "MISS")
+			p.proxyRequest(w, r, false, "")
+			return
+		}
+		cacheKey = key
+	} else {
+		cacheKey = p.getRequestCacheKey(r)
+	}
+
+	var headerXCacheValue string
+
+	// Allowed clients sending no-cache skip the cached copy and refresh it from the origin
+	if p.isClientBypass(r) {
+		headerXCacheValue = "BYPASS"
+		w.Header().Set("X-Cache", headerXCacheValue)
+		p.proxyRequest(w, r, r.Method != http.MethodHead, cacheKey)
+		p.logCacheResult(headerXCacheValue, r)
+		return
+	}
+
+	entry, body, isCached := p.lookup(r.Context(), cacheKey)
+	if isCached && p.isExpired(entry) {
+		// An expired entry with validators is refreshed with a conditional request rather than fetched anew,
+		// unless a peer owns it and is asked for its copy instead
+		if cr, ok := conditionalRequest(r, entry); ok && !p.readOnlyCache && r.Method != http.MethodPost && p.peerOwner(cacheKey) == nil {
+			p.refreshExpired(w, r, cr, cacheKey, entry, body)
+			return
+		}
+		closeBody(body)
+		isCached = false
+	}
+
+	if !isCached {
+		// If the request is not in cache, forward it and cache the response.
+		// A HEAD response has no body, so caching it would poison the entry shared with GET.
+		headerXCacheValue = "MISS"
+		w.Header().Set("X-Cache", headerXCacheValue)
+		p.proxyRequest(w, r, r.Method != http.MethodHead, cacheKey)
+	} else {
+		// If the request is in cache, serve the cached response
+		headerXCacheValue = "HIT"
+		w.Header().Set("X-Cache", headerXCacheValue)
+		p.responseFromCache(w, r, entry, body)
+
+		// Past the soft TTL, or by chance shortly before expiry, the copy is still served but refreshed for the next clients.
+		// Refreshes are sent without the request body, so cached POST responses are left to expire.
+		// Entries owned by a peer are refreshed by the peer and fetched from it again once they expire.
+		if !p.readOnlyCache && r.Method != http.MethodPost && p.peerOwner(cacheKey) == nil && (p.needsRefresh(entry) || p.shouldRefreshEarly(entry)) {
+			p.revalidateInBackground(r, cacheKey, entry)
+		}
+	}
+
+	p.logCacheResult(headerXCacheValue, r)
+}
+
+// KeyFunc derives the raw cache key of a request; requests with equal keys share a cached response
+type KeyFunc func(r *http.Request) string
+
+// SetKeyFunc replaces the built-in cache key derivation from the URL, method and optionally User-Agent and cookies,
+// e.g. to include a tenant ID; nil restores the built-in one. The returned key is hashed before use.
+func (p *Proxy) SetKeyFunc(fn KeyFunc) {
+	p.keyFunc = fn
+}
+
+// SetCacheNamespace sets a value mixed into every cache key, so changing it invalidates all cached entries
+// at once; the entries stored under the previous value are left to the cache cleanup
+func (p *Proxy) SetCacheNamespace(namespace string) {
+	p.namespace = namespace
+}
+
+// CacheKey returns the key the response to the request is cached under, following the key options of the proxy
+func (p *Proxy) CacheKey(r *http.Request) string {
+	return p.getRequestCacheKey(r)
+}
+
+// getRequestCacheKey generates a cache key based on the request URL, method, and optionally User-Agent and cookies,
+// or with the custom key function if one is set
+func (p *Proxy) getRequestCacheKey(r *http.Request) string {
+	if p.keyFunc != nil {
+		return p.hashKey(p.tenantKey(r, p.keyFunc(r)))
+	}
+
+	// Assemble the cache key from URL, method, headers (User-Agent and Cookie)
+	var keyParts []string
+
+	// Add the normalized URL to the key parts
+	keyParts = append(keyParts, p.normalizedURL(r.URL, p.tenantIgnoredQueryParams(r)...))
+
+	// GET and HEAD share an entry, so a HEAD can be answered from a cached GET; other methods get their own
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		keyParts = append(keyParts, r.Method)
+	}
+
+	// Responses encoded differently are separate variants, identity keeping the plain key
+	if coding := normalizedEncoding(r); coding != "" {
+		keyParts = append(keyParts, "encoding="+coding)
+	}
+	if lang := p.requestLanguage(r); lang != "" {
+		keyParts = append(keyParts, "language="+lang)
+	}
+	if country := p.geoCountry(r); country != "" {
+		keyParts = append(keyParts, "country="+country)
+	}
+	keyParts = append(keyParts, p.varyKeyParts(r)...)
+
+	if p.uniqueByUser {
+		// If unique per user, include User-Agent in the key
+		userAgent := r.Header.Get("User-Agent")
+		if userAgent != "" {
+			keyParts = append(keyParts, userAgent)
+		}
+
+		// Include cookies in the key if present
+		if cookies := r.Header.Get("Cookie"); cookies != "" {
+			keyParts = append(keyParts, cookies)
+		}
+	}
+
+	// Join all parts to form the raw key
+	return p.hashKey(p.tenantKey(r, strings.Join(keyParts, "|")))
+}
+
+// hashKey prefixes the raw key with the cache namespace, then hashes it using MD5 into a hexadecimal string
+func (p *Proxy) hashKey(rawKey string) string {
+	if p.namespace != "" {
+		rawKey = p.namespace + "|" + rawKey
+	}
+	hash := md5.Sum([]byte(rawKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// lookup returns the cached entry for the key, with a reader over its body if the cache streams bodies
+func (p *Proxy) lookup(ctx context.Context, key string) (*cache.Entry, cache.Body, bool) {
+	if streamer, ok := p.cache.(cache.Streamer); ok {
+		return streamer.GetStream(ctx, key)
+	}
+	entry, ok := p.cache.Get(ctx, key)
+	return entry, nil, ok
+}
+
+// closeBody closes a streamed cached body, if there is one
+func closeBody(body cache.Body) {
+	if body != nil {
+		_ = body.Close()
+	}
+}
+
+// responseFromCache serves the cached entry, taking the body from the reader if there is one and closing it
+func (p *Proxy) responseFromCache(w http.ResponseWriter, r *http.Request, entry *cache.Entry, body cache.Body) {
+	defer closeBody(body)
+
+	// Set cached headers in the response, cloned so the cached entry is never modified
+	for name, values := range entry.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	p.setResponseHeaders(w.Header(), r)
+
+	status := entry.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	// A body stored as is, or one above the stream threshold, is streamed to the client unchanged
+	// instead of being read into memory
+	if !p.rewritesBody(w.Header()) || body != nil && p.streamThreshold > 0 && body.Size() > p.streamThreshold {
+		p.serveCachedBody(w, r, entry, body, status)
+		return
+	}
+
+	data := entry.Body
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			p.logger.Printf("Error reading cached body: %s", err)
+			p.writeError(w, r, http.StatusInternalServerError, "Failed to read cached response")
+			return
+		}
+	}
+	data = p.rewriteBody(w.Header(), data, r)
+	setContentLength(w.Header(), status, int64(len(data)))
+	w.WriteHeader(status)
+
+	// Write cached data to the response, a HEAD gets the headers of the cached GET only
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+}
+
+// proxyRequest forwards the request to the origin server, handles caching if required, and writes the response
+func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, caching bool, cacheKey string) {
+	// In a cluster the peer owning the key is asked first, the origin answers if it fails
+	if caching {
+		if resp, err := p.fillFromPeer(r, cacheKey); err == nil {
+			p.relayResponse(w, r, resp, nil, caching, cacheKey)
+			return
+		}
+	}
+
+	// Get response from the origin server, in full when it is cached
+	originReq := r
+	if caching {
+		originReq = withoutClientConditions(r)
+	}
+	resp, err := p.getResponseFromOrigin(originReq)
+	p.relayResponse(w, r, resp, err, caching, cacheKey)
+}
+
+// relayResponse writes the origin response, or the outcome of the failed origin request, to the client
+// and caches the response if required
+func (p *Proxy) relayResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, err error, caching bool, cacheKey string) {
+	if p.clientGone(r) {
+		// Nobody is waiting for the response anymore, and a partial one must not be cached
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return
+	}
+	if err != nil {
+		if !p.serveStaleOnError(w, r, cacheKey) {
+			p.writeError(w, r, originErrorStatus(err), "Failed to fetch data from origin")
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	// A server error or rate limit counts as an origin failure when a stale copy can be served instead
+	if isOriginFailure(resp.StatusCode) && !p.isCacheableStatus(resp.StatusCode) && p.serveStaleOnError(w, r, cacheKey) {
+		return
+	}
+
+	// Read the response body into a pooled buffer, released once the response is written;
+	// of a response above the stream threshold only the beginning is read, the rest streams through
+	buf := getBuffer()
+	defer putBuffer(buf)
+	large, err := p.readBody(resp, buf)
+	if err != nil {
+		if p.clientGone(r) {
+			return
+		}
+		p.logger.Printf("Error reading response body: %s", err)
+		if !p.serveStaleOnError(w, r, cacheKey) {
+			p.writeError(w, r, originErrorStatus(err), "Failed to read response body")
+		}
+		return
+	}
+	if large {
+		p.streamResponse(w, r, resp, buf.Bytes(), caching, cacheKey)
+		return
+	}
+
+	respBody := buf.Bytes()
+	p.observeOriginSize(r, resp.StatusCode, len(respBody))
+
+	// Hop-by-hop and framing headers describe the origin connection and must be neither cached nor forwarded
+	removeHopByHopHeaders(resp.Header)
+	removeFramingHeaders(resp.Header)
+	p.relocate(resp.Header, resp.StatusCode)
+
+	// The hook script may rewrite the response headers and keep the response out of the cache
+	if !p.runResponseHook(r, resp) {
+		caching = false
+	}
+
+	if caching {
+		p.storeResponse(r, cacheKey, resp, respBody)
+	}
+
+	// Set response headers and status, keeping every value of repeated headers such as Set-Cookie
+	for name, values := range resp.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	if p.ttlHeader != "" {
+		w.Header().Del(p.ttlHeader)
+	}
+	p.setResponseHeaders(w.Header(), r)
+	respBody = p.rewriteBody(w.Header(), respBody, r)
+
+	// The range and conditions of the client were not sent to the origin, they are answered on the full response
+	if r.Method == http.MethodGet && resp.StatusCode == http.StatusOK && hasClientConditions(r.Header) {
+		modTime, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+		w.Header().Del("Content-Length")
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(respBody))
+		return
+	}
+	if r.Method == http.MethodHead {
+		// A HEAD response has no body, its length is the one announced by the origin
+		setContentLength(w.Header(), resp.StatusCode, resp.ContentLength)
+	} else {
+		setContentLength(w.Header(), resp.StatusCode, int64(len(respBody)))
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// notStored reports whether the cache declined the entry for a reason it handles itself:
+// a full disk, or another host writing the same key
+func notStored(err error) bool {
+	return errors.Is(err, cache.ErrFull) || errors.Is(err, cache.ErrLocked)
+}
+
+// storeResponse caches the response to the client request asynchronously if the response is cacheable
+func (p *Proxy) storeResponse(r *http.Request, cacheKey string, resp *http.Response, body []byte) {
+	// While recording every response is stored, since fixtures must reproduce the origin exactly
+	if p.readOnlyCache || p.recordReplay != ModeRecord && !p.isCacheableResponse(resp) {
+		return
+	}
+	entry := p.newEntry(r, resp)
+	// The body may live in a pooled buffer, so the cache gets its own copy
+	entry.Body = bytes.Clone(body)
+
+	// The entry is stored after the response is sent, so it must not depend on the request context
+	p.pendingWrites.Add(1)
+	p.stats.pendingWrites.Add(1)
+	go func() {
+		defer p.pendingWrites.Done()
+		defer p.stats.pendingWrites.Add(-1)
+		// A full cache reports the condition itself, the responses pass through meanwhile
+		if err := p.cache.Set(context.WithoutCancel(r.Context()), cacheKey, entry); err != nil {
+			if !notStored(err) {
+				p.logger.Printf("Error caching response for URL %s: %s", r.URL.String(), err)
+			}
+			return
+		}
+		p.Publish(Event{Type: EventStore, Method: r.Method, URL: r.URL.String(), Size: len(entry.Body)})
+		p.replicate(cacheKey, r.URL.String(), entry)
+	}()
+}
+
+// newEntry returns the cache entry of the response to the request, without its body
+func (p *Proxy) newEntry(r *http.Request, resp *http.Response) *cache.Entry {
+	// The TTL override is meant for the proxy only and is not stored
+	headers := resp.Header.Clone()
+	if p.ttlHeader != "" {
+		headers.Del(p.ttlHeader)
+	}
+
+	// Pinned entries and recorded fixtures never expire
+	pinned := p.isPinned(r) || p.recordReplay == ModeRecord
+	entry := &cache.Entry{
+		Status:    resp.StatusCode,
+		Header:    headers,
+		RefreshAt: p.refreshTime(),
+		Pinned:    pinned,
+		URL:       p.normalizedURL(r.URL, p.tenantIgnoredQueryParams(r)...),
+		Tenant:    p.requestTenant(r),
+	}
+	if !pinned {
+		entry.ExpiresAt = p.expiryTime(r, p.responseTTL(resp))
+	}
+	return entry
+}
+
+// clientGone reports whether the client disconnected, which cancels the origin request made for it
+func (p *Proxy) clientGone(r *http.Request) bool {
+	if r.Context().Err() == nil {
+		return false
+	}
+	p.logger.Printf("Client disconnected, origin request canceled for URL: %s", r.URL.String())
+	return true
+}
+
+// setResponseHeaders applies the headers the proxy adds on top of the origin ones, for hits and misses alike
+func (p *Proxy) setResponseHeaders(headers http.Header, r *http.Request) {
+	p.setCORSHeaders(headers, r)
+	p.rewriteHeaders(DirectionResponse, headers, r)
+}
+
+// getResponseFromOrigin sends a request to the origin server and returns the response
+func (p *Proxy) getResponseFromOrigin(r *http.Request) (resp *http.Response, err error) {
+	// The origin is left alone while it asked the route of the request to retry later
+	if resp, ok := p.backedOffResponse(r); ok {
+		return resp, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			p.recordRetryAfter(r, resp)
+		}
+		latency := time.Since(start)
+		p.latencies.add(latency)
+		p.observeOriginLatency(r, resp, err, latency)
+		p.countOriginResponse(resp, err)
+	}()
+
+	// Only idempotent reads can be safely sent twice
+	if p.hedgeDelay != 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return p.sendHedged(r)
+	}
+	return p.sendToOrigin(r)
+}
+
+// sendToOrigin sends the request to the origin, retrying on the fallback origin if one is configured
+func (p *Proxy) sendToOrigin(r *http.Request) (*http.Response, error) {
+	if p.fallbackOrigin != nil {
+		return p.sendWithFallback(r)
+	}
+	return p.sendRequest(p.origin, r)
+}
+
+// sendRequest forwards the client request to the given server and returns the response
+func (p *Proxy) sendRequest(origin *url.URL, r *http.Request) (*http.Response, error) {
+	// Create a new request with the same method, URL, and headers as the original request;
+	// it shares the client request context, so it is canceled when the client disconnects
+	newReq, err := http.NewRequestWithContext(r.Context(), r.Method, originURL(origin, r.URL).String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the framing of the client body, which may have been buffered and replaced for keying
+	newReq.ContentLength = r.ContentLength
+	newReq.Header = r.Header.Clone()
+	removeHopByHopHeaders(newReq.Header)
+	setOriginEncoding(newReq.Header, r)
+	p.setOriginLanguage(newReq.Header, r)
+	p.setGeoHeaders(newReq.Header, r)
+	p.setForwardedHeaders(newReq, r)
+
+	// Override the Host header if configured, by default the origin sees its own hostname
+	switch p.hostHeader {
+	case "":
+	case HostHeaderPreserve:
+		newReq.Host = r.Host
+	default:
+		newReq.Host = p.hostHeader
+	}
+
+	// Apply request header rewrite rules
+	p.rewriteHeaders(DirectionRequest, newReq.Header, r)
+
+	// Authenticate to the origin and its fallback, the shadow origin is not trusted with the credentials
+	if origin != p.shadowOrigin {
+		if err := p.authenticate(newReq); err != nil {
+			return nil, err
+		}
+	}
+
+	// Send the request with the origin client
+	resp, err := p.client.Do(newReq)
+	if err != nil {
+		if r.Context().Err() == nil {
+			p.logger.Printf("Error reading response body: %s for URL %s", err, r.URL.String())
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// originURL returns the URL of the request on the origin: the request path appended to the path prefix of the origin,
+// keeping the escaping of both, with the request query. The path of a file:// origin is the root of the file transport.
+func originURL(origin, u *url.URL) *url.URL {
+	newURL := *origin
+	newURL.RawQuery = u.RawQuery
+	if origin.Scheme == "file" || origin.Path == "" {
+		newURL.Path, newURL.RawPath = u.Path, u.RawPath
+		return &newURL
+	}
+
+	newURL.Path = origin.Path + u.Path
+	// The raw path is only used if it encodes the path, otherwise the path is escaped anew
+	newURL.RawPath = origin.EscapedPath() + u.EscapedPath()
+	return &newURL
+}
+
+// isNotSafeMethod checks if the HTTP method is not one of the safe methods (GET, HEAD, OPTIONS)
+func isNotSafeMethod(method string) bool {
+	method = strings.ToUpper(method)
+	safeMethods := []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	return !slices.Contains(safeMethods, method)
+}
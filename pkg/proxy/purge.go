@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// ErrPurgeUnsupported is returned by Purge when the cache cannot remove entries by URL
+var ErrPurgeUnsupported = errors.New("the cache does not support purging by URL")
+
+// Purge removes the cached entries of a URL in all its variants, or of every URL matching a pattern
+// containing "*" (a trailing "*" matching any suffix), and returns how many were removed.
+// URLs are given as requested from the proxy, the path with the query. A tenant limits the purge
+// to its own entries, empty purges the URL of every tenant. Entries stored without their URL are never matched.
+func (p *Proxy) Purge(tenant, target string) (int, error) {
+	purger, ok := p.cache.(cache.Purger)
+	if !ok {
+		return 0, ErrPurgeUnsupported
+	}
+
+	matchURL := func(u string) bool { return matchPath(target, u) }
+	if !strings.Contains(target, "*") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return 0, fmt.Errorf("invalid URL '%s': %w", target, err)
+		}
+		// Keys are built from the request URI, so a full URL is purged by its path and query
+		normalized := p.normalizedURL(&url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery})
+		matchURL = func(u string) bool { return u == normalized }
+	}
+	tenant = normalizeTenant(tenant)
+	return purger.Purge(func(entry *cache.Entry) bool {
+		return entry.URL != "" && matchURL(entry.URL) && (tenant == "" || entry.Tenant == tenant)
+	}), nil
+}
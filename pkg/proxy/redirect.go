@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultFollowRedirects is the number of origin redirects followed unless configured, as many as the Go HTTP client follows
+const DefaultFollowRedirects = 10
+
+// SetFollowRedirects sets how many redirects of the origin the proxy follows itself, returning and caching
+// the final response under the URL requested. A redirect beyond the last hop is passed to the client
+// untouched, so zero passes every redirect through; passed redirects are not cached.
+func (p *Proxy) SetFollowRedirects(hops int) {
+	p.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// via holds the requests sent so far, the first one included
+		if len(via) > hops {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// WithFollowRedirects sets how many redirects of the origin the proxy follows, see SetFollowRedirects
+func WithFollowRedirects(hops int) Option {
+	return func(p *Proxy) { p.SetFollowRedirects(hops) }
+}
+
+// isRedirectStatus reports whether the status redirects the client to another URL
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// SetRedirectTTL sets how long permanent redirects (301 and 308) passed through from the origin are cached,
+// zero leaving them uncached like other redirects. A Location pointing to the origin is replayed as a path
+// through the proxy, so clients are not sent around it.
+func (p *Proxy) SetRedirectTTL(ttl time.Duration) {
+	p.redirectTTL = ttl
+}
+
+// WithRedirectTTL sets how long permanent redirects are cached, see SetRedirectTTL
+func WithRedirectTTL(ttl time.Duration) Option {
+	return func(p *Proxy) { p.SetRedirectTTL(ttl) }
+}
+
+// cachesRedirect reports whether the redirect status is cached, only permanent redirects ever are
+func (p *Proxy) cachesRedirect(status int) bool {
+	return p.redirectTTL > 0 && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect)
+}
+
+// relocate rewrites the Location of a cached redirect pointing to the origin into a path through the proxy,
+// under the prefix the proxy is mounted at; other locations are left as they are
+func (p *Proxy) relocate(header http.Header, status int) {
+	if !p.cachesRedirect(status) || p.origin.Scheme == "file" {
+		return
+	}
+	loc, err := url.Parse(header.Get("Location"))
+	if err != nil || !loc.IsAbs() || loc.Scheme != p.origin.Scheme || !strings.EqualFold(loc.Host, p.origin.Host) {
+		return
+	}
+	rest, ok := cutPathPrefix(loc.Path, p.origin.Path)
+	if !ok {
+		return
+	}
+
+	loc.Scheme, loc.Host, loc.User = "", "", nil
+	loc.Path, loc.RawPath = p.publicPrefix()+rest, ""
+	if loc.Path == "" {
+		loc.Path = "/"
+	}
+	header.Set("Location", loc.String())
+}
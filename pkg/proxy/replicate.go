@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// replicaQueueSize is the number of stored entries waiting to be pushed to a replica before newer ones are dropped
+const replicaQueueSize = 1024
+
+// replicaPush is a stored entry waiting to be pushed to a replica
+type replicaPush struct {
+	key   string
+	url   string
+	entry *cache.Entry
+}
+
+// replica is a standby instance receiving the entries stored by this one
+type replica struct {
+	target   cache.Cache      // Cache of the replica, e.g. its admin API reached over the sidecar protocol
+	queue    chan replicaPush // Entries waiting to be pushed
+	dropping atomic.Bool      // Whether entries are being dropped for lack of room, so the condition is logged once
+}
+
+// SetReplicas makes the proxy a primary pushing every response it stores to the replicas, so a warm standby
+// can take over without a cold cache. Entries are pushed in the background, in the order they were stored;
+// while a replica is slow or unreachable its newest entries are dropped rather than slowing down the proxy.
+// Purges and evictions are not replicated, replicas expire their entries on their own.
+// It must be called at most once.
+func (p *Proxy) SetReplicas(replicas []cache.Cache) {
+	for _, target := range replicas {
+		r := &replica{target: target, queue: make(chan replicaPush, replicaQueueSize)}
+		p.replicas = append(p.replicas, r)
+		go p.runReplica(r)
+	}
+}
+
+// WithReplicas makes the proxy a primary pushing every response it stores to the replicas, see SetReplicas
+func WithReplicas(replicas []cache.Cache) Option {
+	return func(p *Proxy) { p.SetReplicas(replicas) }
+}
+
+// replicate queues the entry just stored under the key for every replica
+func (p *Proxy) replicate(key, url string, entry *cache.Entry) {
+	for _, r := range p.replicas {
+		select {
+		case r.queue <- replicaPush{key: key, url: url, entry: entry}:
+		default:
+			if !r.dropping.Swap(true) {
+				p.logger.Printf("Replica queue full, stored entries are not replicated until it drains, first one for URL %s", url)
+			}
+		}
+	}
+}
+
+// runReplica pushes the queued entries to the replica
+func (p *Proxy) runReplica(r *replica) {
+	for push := range r.queue {
+		if err := r.target.Set(context.Background(), push.key, push.entry); err != nil {
+			p.logger.Printf("Error replicating entry for URL %s: %s", push.url, err)
+			continue
+		}
+		if r.dropping.Swap(false) {
+			p.logger.Printf("Replica caught up, stored entries are replicated again")
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsServerTimeout limits a lookup on a single DNS server before the next one is tried
+const dnsServerTimeout = 3 * time.Second
+
+// resolver dials the origin with cached DNS lookups and fixed addresses for selected hosts
+type resolver struct {
+	ttl       time.Duration       // How long resolved addresses are reused, zero disables caching
+	overrides map[string]string   // Fixed addresses by "host:port"
+	servers   []*net.Resolver     // DNS servers tried in order, the host resolver if none
+	mu        sync.Mutex          // Guards entries
+	entries   map[string]dnsEntry // Resolved addresses by host
+	dialer    net.Dialer
+}
+
+// dnsEntry is a cached DNS lookup result
+type dnsEntry struct {
+	addrs     []string
+	expiresAt time.Time
+}
+
+// SetDNSCacheTTL sets how long resolved origin addresses are reused; zero disables caching
+func (p *Proxy) SetDNSCacheTTL(ttl time.Duration) {
+	p.originResolver().ttl = ttl
+}
+
+// SetOriginResolve pins origin "host:port" pairs to fixed addresses, bypassing DNS for them
+func (p *Proxy) SetOriginResolve(overrides map[string]string) {
+	p.originResolver().overrides = overrides
+}
+
+// SetOriginDNSServers sets the DNS servers, as "ip" or "ip:port", resolving the origin hosts instead of the host
+// resolver, e.g. for split-horizon setups. They are tried in order, failing over to the next one when a server
+// does not answer; a host reported missing is not looked up further.
+func (p *Proxy) SetOriginDNSServers(servers []string) {
+	r := p.originResolver()
+	r.servers = nil
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.servers = append(r.servers, &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		})
+	}
+}
+
+// originResolver returns the resolver used to dial the origin, installing it in the client transport on first use
+func (p *Proxy) originResolver() *resolver {
+	if p.resolver != nil {
+		return p.resolver
+	}
+	p.resolver = &resolver{entries: make(map[string]dnsEntry)}
+	p.originTransport().DialContext = p.resolver.dial
+	return p.resolver
+}
+
+// dial connects to the address, resolving its host through the overrides and the DNS cache
+func (r *resolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if ip, ok := r.overrides[address]; ok {
+		_, port, _ := net.SplitHostPort(address)
+		return r.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+	if r.ttl <= 0 && len(r.servers) == 0 {
+		return r.dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every address is tried in turn, like the default dialer does
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := r.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// lookup resolves the host, reusing a cached result until it expires; an expired result
+// is still used when the lookup fails, so a flaky resolver does not take the origin down
+func (r *resolver) lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mu.Lock()
+	cached, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.lookupHost(ctx, host)
+	if err != nil {
+		if ok {
+			log.Printf("DNS lookup of %s failed, reusing expired addresses: %s", host, err)
+			return cached.addrs, nil
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[host] = dnsEntry{addrs: addrs, expiresAt: time.Now().Add(r.ttl)}
+	return addrs, nil
+}
+
+// lookupHost resolves the host on the configured DNS servers in turn, or on the host resolver
+func (r *resolver) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if len(r.servers) == 0 {
+		return net.DefaultResolver.LookupHost(ctx, host)
+	}
+
+	var errs []error
+	for _, server := range r.servers {
+		serverCtx, cancel := context.WithTimeout(ctx, dnsServerTimeout)
+		addrs, err := server.LookupHost(serverCtx, host)
+		cancel()
+		if err == nil {
+			return addrs, nil
+		}
+		// A missing host is an answer, only failing servers are skipped
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound || ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRetryAfter caps how long the origin may ask the proxy to hold off by default
+const DefaultMaxRetryAfter = 5 * time.Minute
+
+// maxBackoffBody is the largest error body kept to be replayed while a route backs off
+const maxBackoffBody = 64 << 10
+
+// backoff is a route the origin asked to retry later, with the error response replayed in the meantime
+type backoff struct {
+	until  time.Time
+	status int
+	header http.Header
+	body   []byte
+}
+
+// backoffs holds the routes backing off, by route label
+type backoffs struct {
+	mu     sync.Mutex
+	routes map[string]*backoff
+}
+
+// SetMaxRetryAfter sets how long the proxy honors a Retry-After of a 429 or 503 origin response at most:
+// until then requests of the same route are not sent to the origin, but answered with a stale copy
+// if one may be served, or with the error response. Zero ignores Retry-After.
+func (p *Proxy) SetMaxRetryAfter(limit time.Duration) {
+	p.maxRetryAfter = limit
+}
+
+// WithMaxRetryAfter sets how long a Retry-After of the origin is honored at most, see SetMaxRetryAfter
+func WithMaxRetryAfter(limit time.Duration) Option {
+	return func(p *Proxy) { p.SetMaxRetryAfter(limit) }
+}
+
+// backedOffResponse returns a replay of the error response if the origin asked to hold off the route of the request
+func (p *Proxy) backedOffResponse(r *http.Request) (*http.Response, bool) {
+	if p.maxRetryAfter <= 0 {
+		return nil, false
+	}
+	route := p.metricsRoute(r)
+	p.backoffs.mu.Lock()
+	b, ok := p.backoffs.routes[route]
+	if ok && !time.Now().Before(b.until) {
+		delete(p.backoffs.routes, route)
+		ok = false
+	}
+	p.backoffs.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	header := b.header.Clone()
+	header.Set("Retry-After", strconv.Itoa(int(time.Until(b.until).Seconds()+1)))
+	return &http.Response{
+		Status:        strconv.Itoa(b.status) + " " + http.StatusText(b.status),
+		StatusCode:    b.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(b.body)),
+		ContentLength: int64(len(b.body)),
+		Request:       r,
+	}, true
+}
+
+// recordRetryAfter remembers the route of the request as backing off if the origin answered 429 or 503
+// with a Retry-After, keeping the error body to be replayed
+func (p *Proxy) recordRetryAfter(r *http.Request, resp *http.Response) {
+	if p.maxRetryAfter <= 0 || resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if !ok {
+		return
+	}
+	delay = min(delay, p.maxRetryAfter)
+
+	// The body read ahead is put back in front of the rest, so the response is relayed whole
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBackoffBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || len(body) > maxBackoffBody {
+		return
+	}
+
+	route := p.metricsRoute(r)
+	p.backoffs.mu.Lock()
+	if p.backoffs.routes == nil {
+		p.backoffs.routes = make(map[string]*backoff)
+	}
+	p.backoffs.routes[route] = &backoff{until: time.Now().Add(delay), status: resp.StatusCode, header: resp.Header.Clone(), body: body}
+	p.backoffs.mu.Unlock()
+	p.logger.Printf("Origin asked to retry after %s, holding off route %s", delay, route)
+}
+
+// parseRetryAfter parses a Retry-After value given in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds > 0
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		delay := time.Until(at)
+		return delay, delay > 0
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// SetSoftTTL sets the age after which cached responses are still served but refreshed in the background;
+// zero disables background revalidation
+func (p *Proxy) SetSoftTTL(ttl time.Duration) {
+	p.softTTL = ttl
+}
+
+// defaultRecomputeTime is the assumed origin fetch time while too few latencies are known
+const defaultRecomputeTime = 100 * time.Millisecond
+
+// SetEarlyRefresh sets the eagerness of probabilistic refresh before expiry: requests shortly before
+// an entry expires have a growing chance to refresh it in the background, so clients do not all miss at once.
+// Values above 1 refresh earlier, zero disables it.
+func (p *Proxy) SetEarlyRefresh(beta float64) {
+	p.earlyRefreshBeta = beta
+}
+
+// refreshTime returns when a response cached now should be refreshed in the background, zero without a soft TTL
+func (p *Proxy) refreshTime() time.Time {
+	if p.softTTL <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.softTTL)
+}
+
+// needsRefresh checks whether the cached entry has passed its soft TTL
+func (p *Proxy) needsRefresh(entry *cache.Entry) bool {
+	return p.softTTL > 0 && !entry.RefreshAt.IsZero() && time.Now().After(entry.RefreshAt)
+}
+
+// shouldRefreshEarly decides whether the entry is refreshed ahead of its expiry following the XFetch
+// algorithm: the chance grows as the expiry nears and with the time the origin takes to answer
+func (p *Proxy) shouldRefreshEarly(entry *cache.Entry) bool {
+	if p.earlyRefreshBeta <= 0 || entry.Pinned || entry.ExpiresAt.IsZero() {
+		return false
+	}
+
+	delta, ok := p.latencies.percentile(0.5)
+	if !ok {
+		delta = defaultRecomputeTime
+	}
+	// 1-rand.Float64() lies in (0, 1], keeping the logarithm finite
+	gap := time.Duration(float64(delta) * p.earlyRefreshBeta * -math.Log(1-rand.Float64()))
+	return time.Now().Add(gap).After(entry.ExpiresAt)
+}
+
+// revalidateInBackground refreshes the cached entry from the origin without blocking the client;
+// concurrent calls for the same key start a single refresh. An entry with validators is refreshed
+// with a conditional request and only renewed if the origin reports it unchanged.
+func (p *Proxy) revalidateInBackground(r *http.Request, cacheKey string, entry *cache.Entry) {
+	if _, running := p.revalidating.LoadOrStore(cacheKey, struct{}{}); running {
+		return
+	}
+
+	// The refresh outlives the client request, so it must not inherit its context or body
+	req := r.Clone(context.Background())
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	// The conditions of the client concern its own copy, not the cached one
+	removeClientConditions(req.Header)
+	if cr, ok := conditionalRequest(req, entry); ok {
+		req = cr
+	}
+
+	go func() {
+		defer p.revalidating.Delete(cacheKey)
+
+		resp, err := p.getResponseFromOrigin(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotModified {
+			p.renew(req, cacheKey, entry, resp)
+			p.logger.Printf("Cache REVALIDATED for URL: %s", req.URL.String())
+			return
+		}
+
+		buf := getBuffer()
+		defer putBuffer(buf)
+		large, err := p.readBody(resp, buf)
+		if err != nil {
+			p.logger.Printf("Error refreshing %s: %s", req.URL.String(), err)
+			return
+		}
+		if !large {
+			p.observeOriginSize(req, resp.StatusCode, buf.Len())
+		}
+
+		// Keep the current copy if the origin is failing, it remains usable until its hard TTL
+		if isOriginFailure(resp.StatusCode) && !p.isCacheableStatus(resp.StatusCode) {
+			return
+		}
+
+		removeHopByHopHeaders(resp.Header)
+		removeFramingHeaders(resp.Header)
+		p.relocate(resp.Header, resp.StatusCode)
+		if !p.runResponseHook(req, resp) {
+			return
+		}
+		// A body above the stream threshold is written straight to the cache, the beginning already read first;
+		// if the cache cannot store it so, the current copy is kept until it expires
+		if large {
+			if p.storeStream(req, cacheKey, resp, io.MultiReader(bytes.NewReader(buf.Bytes()), resp.Body)) {
+				p.logger.Printf("Cache REFRESHED for URL: %s", req.URL.String())
+			}
+			return
+		}
+		p.storeResponse(req, cacheKey, resp, buf.Bytes())
+		p.logger.Printf("Cache REFRESHED for URL: %s", req.URL.String())
+	}()
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SetRewriteBodyHost sets whether origin host occurrences in HTML and JSON bodies are replaced with the client-facing host
+func (p *Proxy) SetRewriteBodyHost(is bool) {
+	p.rewriteBodyHost = is
+}
+
+// rewritesBody reports whether a body with the given headers has its links rewritten
+func (p *Proxy) rewritesBody(headers http.Header) bool {
+	if !p.rewriteBodyHost || !isRewritableContentType(headers.Get("Content-Type")) {
+		return false
+	}
+	encoding := headers.Get("Content-Encoding")
+	return encoding == "" || encoding == "identity"
+}
+
+// rewriteBody replaces absolute links to the origin in the response body with links to the proxy host;
+// bodies that are encoded or not HTML/JSON are returned unchanged
+func (p *Proxy) rewriteBody(headers http.Header, body []byte, r *http.Request) []byte {
+	if !p.rewritesBody(headers) {
+		return body
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	// Links lead back through the prefix the proxy is mounted under
+	host := r.Host + p.publicPrefix()
+	escapedHost := strings.ReplaceAll(host, "/", `\/`)
+
+	// Replace full URLs first so their scheme follows the client connection, then protocol-relative ones.
+	// JSON encoders may escape slashes, so the escaped forms are replaced too. Links under the path prefix
+	// of the origin come first, the prefix being left out of the proxy paths.
+	prefix := p.origin.EscapedPath() + "/"
+	escapedPrefix := strings.ReplaceAll(prefix, "/", `\/`)
+	var pairs []string
+	if p.origin.Path != "" && p.origin.Scheme != "file" {
+		pairs = append(pairs,
+			p.origin.Scheme+"://"+p.origin.Host+prefix, proto+"://"+host+"/",
+			p.origin.Scheme+`:\/\/`+p.origin.Host+escapedPrefix, proto+`:\/\/`+escapedHost+`\/`,
+			"//"+p.origin.Host+prefix, "//"+host+"/",
+			`\/\/`+p.origin.Host+escapedPrefix, `\/\/`+escapedHost+`\/`,
+		)
+	}
+	pairs = append(pairs,
+		p.origin.Scheme+"://"+p.origin.Host, proto+"://"+host,
+		p.origin.Scheme+`:\/\/`+p.origin.Host, proto+`:\/\/`+escapedHost,
+		"//"+p.origin.Host, "//"+host,
+		`\/\/`+p.origin.Host, `\/\/`+escapedHost,
+	)
+	return []byte(strings.NewReplacer(pairs...).Replace(string(body)))
+}
+
+// isRewritableContentType checks if the content type is HTML or JSON
+func isRewritableContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml" ||
+		mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Route names the requests of a path pattern in the metrics, e.g. "api" for "/api/*"
+type Route struct {
+	Name string `json:"name"` // Label of the route in the metrics
+	Path string `json:"path"` // Path pattern; empty matches every path
+}
+
+// Validate checks that the route has a name and a well-formed path pattern
+func (rt *Route) Validate() error {
+	if rt.Name == "" {
+		return fmt.Errorf("route: no name for path '%s'", rt.Path)
+	}
+	if _, err := path.Match(rt.Path, "/"); err != nil {
+		return fmt.Errorf("route: invalid path pattern '%s'", rt.Path)
+	}
+	return nil
+}
+
+// SetRoutes sets the named routes labeling the metrics; the first route matching a request applies
+func (p *Proxy) SetRoutes(routes []Route) {
+	p.routes = routes
+}
+
+// WithRoutes sets the named routes labeling the metrics, see SetRoutes
+func WithRoutes(routes []Route) Option {
+	return func(p *Proxy) { p.SetRoutes(routes) }
+}
+
+// metricsRoute returns the route label of the request metrics: the name of the first matching route,
+// or the first segment of the request path, e.g. "/api" for "/api/users/1"
+func (p *Proxy) metricsRoute(r *http.Request) string {
+	for i := range p.routes {
+		if matchPath(p.routes[i].Path, r.URL.Path) {
+			return p.routes[i].Name
+		}
+	}
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return "/" + segment
+}
+
+// RouteStats counts how the requests of a route were answered
+type RouteStats struct {
+	Route    string `json:"route"`    // Route label, see Proxy.RouteStats
+	Hits     uint64 `json:"hits"`     // Requests answered from the cache
+	Misses   uint64 `json:"misses"`   // Requests forwarded to the origin for lack of a usable cached copy
+	Bypasses uint64 `json:"bypasses"` // Requests forwarded without looking at the cache
+}
+
+// HitRatio returns the share of cache lookups of the route answered from the cache, between 0 and 1
+func (s RouteStats) HitRatio() float64 {
+	return Stats{Hits: s.Hits, Misses: s.Misses}.HitRatio()
+}
+
+// routeCounters counts the answers of a route
+type routeCounters struct {
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	bypasses atomic.Uint64
+}
+
+// routeStats tracks answer counters by route
+type routeStats struct {
+	mu     sync.RWMutex
+	routes map[string]*routeCounters
+}
+
+// get returns the counters of the route, creating them if needed
+func (s *routeStats) get(route string) *routeCounters {
+	s.mu.RLock()
+	c, ok := s.routes[route]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routes == nil {
+		s.routes = make(map[string]*routeCounters)
+	}
+	if len(s.routes) >= maxMetricSeries {
+		route = otherRoute
+	}
+	if c, ok = s.routes[route]; !ok {
+		c = &routeCounters{}
+		s.routes[route] = c
+	}
+	return c
+}
+
+// RouteStats returns the answer counters by route. The route of a request is the name of the first
+// matching route set with SetRoutes, or the first segment of its path.
+func (p *Proxy) RouteStats() []RouteStats {
+	p.routeStats.mu.RLock()
+	stats := make([]RouteStats, 0, len(p.routeStats.routes))
+	for route, c := range p.routeStats.routes {
+		stats = append(stats, RouteStats{
+			Route:    route,
+			Hits:     c.hits.Load(),
+			Misses:   c.misses.Load(),
+			Bypasses: c.bypasses.Load(),
+		})
+	}
+	p.routeStats.mu.RUnlock()
+
+	slices.SortFunc(stats, func(a, b RouteStats) int {
+		return cmp.Compare(a.Route, b.Route)
+	})
+	return stats
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// serveCachedBody writes the cached body as is, streaming it from the cache when it comes with a reader,
+// so large hits do not grow the heap. Successful responses go through http.ServeContent, letting the server
+// use sendfile and answer Range and conditional requests.
+func (p *Proxy) serveCachedBody(w http.ResponseWriter, r *http.Request, entry *cache.Entry, body cache.Body, status int) {
+	var content io.ReadSeeker
+	var size int64
+	if body != nil {
+		content, size = body, body.Size()
+	} else {
+		content, size = bytes.NewReader(entry.Body), int64(len(entry.Body))
+	}
+
+	if status != http.StatusOK {
+		setContentLength(w.Header(), status, size)
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead && bodyAllowedForStatus(status) {
+			_, _ = io.Copy(w, content)
+		}
+		return
+	}
+
+	// Conditional requests are judged by the origin's Last-Modified, or by the time the entry was stored
+	modTime, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		modTime = entry.StoredAt
+	}
+
+	// ServeContent sets its own Content-Length and answers HEAD requests without a body
+	w.Header().Del("Content-Length")
+	http.ServeContent(w, r, "", modTime, content)
+}
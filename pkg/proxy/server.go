@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServerTimeouts are the timeouts of the server started by Start, zero meaning no limit
+type ServerTimeouts struct {
+	Read  time.Duration // Time limit for reading a whole request, body included
+	Write time.Duration // Time limit for writing a response
+	Idle  time.Duration // How long keep-alive connections wait for the next request
+}
+
+// SetServerTimeouts sets the timeouts of the server started by Start; a running server keeps its own
+func (p *Proxy) SetServerTimeouts(timeouts ServerTimeouts) {
+	p.serverTimeouts = timeouts
+}
+
+// WithServerTimeouts sets the timeouts of the server started by Start
+func WithServerTimeouts(timeouts ServerTimeouts) Option {
+	return func(p *Proxy) { p.SetServerTimeouts(timeouts) }
+}
+
+// Start serves the proxy on the specified host and port, without touching http.DefaultServeMux.
+// It blocks until the server fails, returning the error, or is stopped by Shutdown, returning nil.
+func (p *Proxy) Start(host string, port int) error {
+	srv := &http.Server{
+		Addr:         net.JoinHostPort(host, strconv.Itoa(port)),
+		Handler:      p,
+		ReadTimeout:  p.serverTimeouts.Read,
+		WriteTimeout: p.serverTimeouts.Write,
+		IdleTimeout:  p.serverTimeouts.Idle,
+	}
+	p.serverMu.Lock()
+	p.server = srv
+	p.serverMu.Unlock()
+
+	p.logger.Printf("Starting caching proxy server on %s, forwarding requests to %s\n", srv.Addr, p.origin.String())
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the server started by Start without interrupting active requests, waiting for them
+// and the cache writes they started until the context is done
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.serverMu.Lock()
+	srv := p.server
+	p.server = nil
+	p.serverMu.Unlock()
+
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return p.FlushCacheWrites(ctx)
+}
+
+// FlushCacheWrites waits until the responses already handled are stored in the cache,
+// or until the context is done, returning its error
+func (p *Proxy) FlushCacheWrites(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.pendingWrites.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+)
+
+// SetShadow sets the secondary origin that receives a copy of the given percentage of requests;
+// its responses are discarded and never affect clients
+func (p *Proxy) SetShadow(origin *url.URL, percent float64) {
+	p.shadowOrigin = origin
+	p.shadowPercent = percent
+}
+
+// shadowRequest asynchronously mirrors the request to the shadow origin if it is selected for shadowing
+func (p *Proxy) shadowRequest(r *http.Request) {
+	if p.shadowOrigin == nil || rand.Float64()*100 >= p.shadowPercent {
+		return
+	}
+
+	// The body can be read only once, so it is buffered for both the origin and the shadow
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// The copy outlives the client request, so it must not inherit its context
+	req := r.Clone(context.Background())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	go func() {
+		resp, err := p.sendRequest(p.shadowOrigin, req)
+		if err != nil {
+			p.logger.Printf("Shadow request failed for URL %s: %s", req.URL.String(), err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// stats counts what the proxy did since it was created
+type stats struct {
+	hits             atomic.Uint64 // Requests answered from the cache
+	misses           atomic.Uint64 // Requests forwarded to the origin for lack of a usable cached copy
+	bypasses         atomic.Uint64 // Requests forwarded to the origin without looking at the cache
+	pendingWrites    atomic.Int64  // Cache writes in progress
+	originRequests   atomic.Uint64 // Requests sent to the origin, hedged and fallback attempts counted once
+	originErrors     atomic.Uint64 // Origin requests that failed or got a server error
+	lastOriginError  atomic.Int64  // Time of the last origin error in Unix nanoseconds, zero if none
+	lastOriginAnswer atomic.Int64  // Time of the last successful origin response in Unix nanoseconds, zero if none
+	originFailures   atomic.Uint64 // Origin requests and health checks failed in a row
+	stale            atomic.Uint64 // Expired copies served because the origin failed
+	lastCheck        atomic.Int64  // Time of the last origin health check in Unix nanoseconds, zero if none
+	lastCheckError   atomic.Value  // Error of the last origin health check, empty if it passed
+}
+
+// Stats is a snapshot of the proxy counters
+type Stats struct {
+	Hits             uint64    `json:"hits"`               // Requests answered from the cache
+	Misses           uint64    `json:"misses"`             // Requests forwarded to the origin for lack of a usable cached copy
+	Bypasses         uint64    `json:"bypasses"`           // Requests forwarded without looking at the cache
+	Stale            uint64    `json:"stale"`              // Expired copies served because the origin failed
+	PendingWrites    int64     `json:"pending_writes"`     // Cache writes in progress
+	OriginRequests   uint64    `json:"origin_requests"`    // Requests sent to the origin
+	OriginErrors     uint64    `json:"origin_errors"`      // Origin requests that failed or got a server error
+	LastOriginError  time.Time `json:"last_origin_error"`  // Time of the last origin error, zero if none
+	LastOriginAnswer time.Time `json:"last_origin_answer"` // Time of the last successful origin response, zero if none
+}
+
+// HitRatio returns the share of cache lookups answered from the cache, between 0 and 1
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns a snapshot of the proxy counters
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Hits:             p.stats.hits.Load(),
+		Misses:           p.stats.misses.Load(),
+		Bypasses:         p.stats.bypasses.Load(),
+		Stale:            p.stats.stale.Load(),
+		PendingWrites:    p.stats.pendingWrites.Load(),
+		OriginRequests:   p.stats.originRequests.Load(),
+		OriginErrors:     p.stats.originErrors.Load(),
+		LastOriginError:  unixTime(p.stats.lastOriginError.Load()),
+		LastOriginAnswer: unixTime(p.stats.lastOriginAnswer.Load()),
+	}
+}
+
+// countResult counts how a request was answered, globally and for its route
+func (p *Proxy) countResult(result string, r *http.Request) {
+	counters := []*routeCounters{p.routeStats.get(p.metricsRoute(r))}
+	if tenant := p.requestTenant(r); tenant != "" {
+		counters = append(counters, p.tenantStats.get(tenant))
+	}
+	switch {
+	case strings.HasPrefix(result, "HIT"):
+		p.stats.hits.Add(1)
+		for _, c := range counters {
+			c.hits.Add(1)
+		}
+		p.urlHits.add(r.URL.RequestURI())
+	case strings.HasPrefix(result, "MISS"):
+		p.stats.misses.Add(1)
+		for _, c := range counters {
+			c.misses.Add(1)
+		}
+	case result == "BYPASS":
+		p.stats.bypasses.Add(1)
+		for _, c := range counters {
+			c.bypasses.Add(1)
+		}
+	}
+}
+
+// countOriginResponse counts an origin request with its outcome
+func (p *Proxy) countOriginResponse(resp *http.Response, err error) {
+	p.stats.originRequests.Add(1)
+	if err != nil || resp.StatusCode >= 500 {
+		p.stats.originErrors.Add(1)
+		p.stats.originFailures.Add(1)
+		p.stats.lastOriginError.Store(time.Now().UnixNano())
+		return
+	}
+	p.stats.originFailures.Store(0)
+	p.stats.lastOriginAnswer.Store(time.Now().UnixNano())
+}
+
+// unixTime converts Unix nanoseconds to a time, zero staying the zero time
+func unixTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/ig-rudenko/caching-proxy/pkg/cache"
+)
+
+// SetStreamThreshold sets the body size in bytes above which origin responses are streamed to the client as
+// they arrive instead of being read into memory, so a huge download cannot exhaust it; zero means no limit.
+// Such responses are not cached, unless cacheInBackground is set and the cache stores streamed bodies
+// (cache.StreamSetter): the response is then fetched a second time in the background and written straight
+// to the cache. Bodies of streamed responses are never rewritten.
+func (p *Proxy) SetStreamThreshold(size int64, cacheInBackground bool) {
+	p.streamThreshold = size
+	p.streamCache = cacheInBackground
+}
+
+// WithStreamThreshold sets the body size above which responses are streamed through, see SetStreamThreshold
+func WithStreamThreshold(size int64, cacheInBackground bool) Option {
+	return func(p *Proxy) { p.SetStreamThreshold(size, cacheInBackground) }
+}
+
+// readBody reads the response body into the buffer, reporting whether the response is above the stream
+// threshold, in which case at most its beginning is read and the rest is left to stream through
+func (p *Proxy) readBody(resp *http.Response, buf *bytes.Buffer) (bool, error) {
+	if p.streamThreshold <= 0 {
+		_, err := buf.ReadFrom(resp.Body)
+		return false, err
+	}
+	if resp.ContentLength > p.streamThreshold {
+		return true, nil
+	}
+	// Without a length the body is read until it turns out to be too large
+	_, err := buf.ReadFrom(io.LimitReader(resp.Body, p.streamThreshold+1))
+	return int64(buf.Len()) > p.streamThreshold, err
+}
+
+// streamResponse passes a response above the stream threshold to the client as it arrives, the beginning
+// already read first, and caches it in the background if required
+func (p *Proxy) streamResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, head []byte, caching bool, cacheKey string) {
+	removeHopByHopHeaders(resp.Header)
+	removeFramingHeaders(resp.Header)
+	p.relocate(resp.Header, resp.StatusCode)
+	if !p.runResponseHook(r, resp) {
+		caching = false
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	if p.ttlHeader != "" {
+		w.Header().Del(p.ttlHeader)
+	}
+	p.setResponseHeaders(w.Header(), r)
+	setContentLength(w.Header(), resp.StatusCode, resp.ContentLength)
+	w.WriteHeader(resp.StatusCode)
+
+	var size int64
+	if r.Method != http.MethodHead && bodyAllowedForStatus(resp.StatusCode) {
+		n, err := w.Write(head)
+		size = int64(n)
+		if err == nil {
+			var copied int64
+			copied, err = io.Copy(w, resp.Body)
+			size += copied
+		}
+		if err != nil && !p.clientGone(r) {
+			p.logger.Printf("Error streaming response body for URL %s: %s", r.URL.String(), err)
+		}
+	}
+	p.observeOriginSize(r, resp.StatusCode, int(size))
+	p.logger.Printf("Response of %d bytes streamed through without caching for URL: %s", size, r.URL.String())
+
+	if caching && r.Method == http.MethodGet && p.isCacheableResponse(resp) {
+		p.cacheInBackground(r, cacheKey)
+	}
+}
+
+// canStoreStreams checks whether responses above the stream threshold are cached, written straight to the cache
+func (p *Proxy) canStoreStreams() bool {
+	_, ok := p.cache.(cache.StreamSetter)
+	return ok && p.streamCache && !p.readOnlyCache
+}
+
+// cacheInBackground fetches the response to the request once more and writes its body straight to the cache,
+// unless the key is already being cached this way. Only responses with a known length are cached.
+func (p *Proxy) cacheInBackground(r *http.Request, cacheKey string) {
+	if !p.canStoreStreams() {
+		return
+	}
+	if _, busy := p.streamFills.LoadOrStore(cacheKey, struct{}{}); busy {
+		return
+	}
+
+	// The fetch outlives the client request and asks for the full response
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	removeClientConditions(req.Header)
+	go func() {
+		defer p.streamFills.Delete(cacheKey)
+		resp, err := p.getResponseFromOrigin(req)
+		if err != nil {
+			p.logger.Printf("Error fetching URL %s to cache it in the background: %s", req.URL.String(), err)
+			return
+		}
+		defer resp.Body.Close()
+
+		removeHopByHopHeaders(resp.Header)
+		removeFramingHeaders(resp.Header)
+		p.relocate(resp.Header, resp.StatusCode)
+		if !p.runResponseHook(req, resp) {
+			return
+		}
+		if p.storeStream(req, cacheKey, resp, resp.Body) {
+			p.logger.Printf("Response of %d bytes cached in the background for URL: %s", resp.ContentLength, req.URL.String())
+		}
+	}()
+}
+
+// storeStream writes a cacheable response of known length to the cache, its body copied from the reader,
+// reporting whether it was stored. Such entries are not replicated: the replication queue holds the bodies
+// in memory, which these are too large for, so replicas fetch them from the origin themselves.
+func (p *Proxy) storeStream(r *http.Request, cacheKey string, resp *http.Response, body io.Reader) bool {
+	setter, ok := p.cache.(cache.StreamSetter)
+	if !ok || !p.canStoreStreams() || resp.ContentLength < 0 || !p.isCacheableResponse(resp) {
+		return false
+	}
+	if err := setter.SetStream(r.Context(), cacheKey, p.newEntry(r, resp), body, resp.ContentLength); err != nil {
+		if !notStored(err) {
+			p.logger.Printf("Error caching streamed response for URL %s: %s", r.URL.String(), err)
+		}
+		return false
+	}
+	p.Publish(Event{Type: EventStore, Method: r.Method, URL: r.URL.String(), Size: int(resp.ContentLength)})
+	return true
+}
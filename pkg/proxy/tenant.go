@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// SetTenantByHost sets whether every request host is a separate tenant: its responses are cached
+// under keys of its own, purged on their own and counted apart in TenantStats
+func (p *Proxy) SetTenantByHost(enabled bool) {
+	p.tenantByHost = enabled
+}
+
+// WithTenantByHost sets whether every request host is a separate tenant, see SetTenantByHost
+func WithTenantByHost(enabled bool) Option {
+	return func(p *Proxy) { p.SetTenantByHost(enabled) }
+}
+
+// requestTenant returns the tenant of the request, empty if tenants are not kept apart
+func (p *Proxy) requestTenant(r *http.Request) string {
+	if !p.tenantByHost {
+		return ""
+	}
+	return normalizeTenant(r.Host)
+}
+
+// normalizeTenant turns a host into its tenant name: lowercased, without port and trailing dot
+func normalizeTenant(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// tenantKey prefixes the raw cache key with the tenant of the request, if any
+func (p *Proxy) tenantKey(r *http.Request, rawKey string) string {
+	if tenant := p.requestTenant(r); tenant != "" {
+		return "tenant=" + tenant + "|" + rawKey
+	}
+	return rawKey
+}
+
+// TenantStats counts how the requests of a tenant were answered
+type TenantStats struct {
+	Tenant   string `json:"tenant"`   // Tenant name, the request host
+	Hits     uint64 `json:"hits"`     // Requests answered from the cache
+	Misses   uint64 `json:"misses"`   // Requests forwarded to the origin for lack of a usable cached copy
+	Bypasses uint64 `json:"bypasses"` // Requests forwarded without looking at the cache
+}
+
+// HitRatio returns the share of cache lookups of the tenant answered from the cache, between 0 and 1
+func (s TenantStats) HitRatio() float64 {
+	return Stats{Hits: s.Hits, Misses: s.Misses}.HitRatio()
+}
+
+// TenantStats returns the answer counters by tenant, empty unless tenants are kept apart by host
+func (p *Proxy) TenantStats() []TenantStats {
+	p.tenantStats.mu.RLock()
+	stats := make([]TenantStats, 0, len(p.tenantStats.routes))
+	for tenant, c := range p.tenantStats.routes {
+		stats = append(stats, TenantStats{
+			Tenant:   tenant,
+			Hits:     c.hits.Load(),
+			Misses:   c.misses.Load(),
+			Bypasses: c.bypasses.Load(),
+		})
+	}
+	p.tenantStats.mu.RUnlock()
+
+	slices.SortFunc(stats, func(a, b TenantStats) int {
+		return cmp.Compare(a.Tenant, b.Tenant)
+	})
+	return stats
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantRule overrides the global settings for the requests of a tenant, picked by host and path;
+// settings left out keep their global values
+type TenantRule struct {
+	Host              string   `json:"host"`                // Host pattern, e.g. "*.example.com"; empty matches every host
+	Path              string   `json:"path"`                // Path pattern, e.g. "/api/*"; empty matches every path
+	TTL               Duration `json:"ttl"`                 // TTL of responses without a TTL of their own, replacing the default TTL
+	IgnoreQueryParams []string `json:"ignore_query_params"` // Query parameters left out of the cache key, besides the global ones
+	VaryHeaders       []string `json:"vary_headers"`        // Request headers whose values select the cache variant
+	RateLimit         float64  `json:"rate_limit"`          // Requests per second the tenant may send, zero for no limit
+	Bypass            []string `json:"bypass"`              // Path patterns forwarded without looking at the cache
+}
+
+// Validate checks that the rule has well-formed patterns and no negative limits
+func (t *TenantRule) Validate() error {
+	if _, err := path.Match(t.Host, "example.com"); err != nil {
+		return fmt.Errorf("tenant rule: invalid host pattern '%s'", t.Host)
+	}
+	if _, err := path.Match(t.Path, "/"); err != nil {
+		return fmt.Errorf("tenant rule: invalid path pattern '%s'", t.Path)
+	}
+	if t.TTL < 0 {
+		return fmt.Errorf("tenant rule %s%s: ttl must not be negative", t.Host, t.Path)
+	}
+	if t.RateLimit < 0 {
+		return fmt.Errorf("tenant rule %s%s: rate_limit must not be negative", t.Host, t.Path)
+	}
+	for _, pattern := range t.Bypass {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("tenant rule %s%s: invalid bypass pattern '%s'", t.Host, t.Path, pattern)
+		}
+	}
+	return nil
+}
+
+// SetTenantRules sets the rules overriding the global settings per tenant; the first rule matching
+// the host and path of a request applies. Host patterns match regardless of case, and the rate limit
+// of a rule applies to each host it matches separately.
+func (p *Proxy) SetTenantRules(rules []TenantRule) {
+	p.tenantRules = slices.Clone(rules)
+	p.tenantLimiters = make([]*tenantLimiter, len(rules))
+	for i := range p.tenantRules {
+		// Request hosts are lowercased before matching, so the patterns are as well
+		p.tenantRules[i].Host = strings.ToLower(p.tenantRules[i].Host)
+		if rules[i].RateLimit > 0 {
+			p.tenantLimiters[i] = newTenantLimiter(rules[i].RateLimit)
+		}
+	}
+}
+
+// WithTenantRules sets the rules overriding the global settings per tenant, see SetTenantRules
+func WithTenantRules(rules []TenantRule) Option {
+	return func(p *Proxy) { p.SetTenantRules(rules) }
+}
+
+// tenantRule returns the index of the first rule matching the request, or -1
+func (p *Proxy) tenantRule(r *http.Request) int {
+	if len(p.tenantRules) == 0 {
+		return -1
+	}
+	host := normalizeTenant(r.Host)
+	for i := range p.tenantRules {
+		rule := &p.tenantRules[i]
+		if ok, _ := path.Match(rule.Host, host); (rule.Host == "" || ok) && matchPath(rule.Path, r.URL.Path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tenantDefaultTTL returns the TTL of responses without a TTL of their own for the request
+func (p *Proxy) tenantDefaultTTL(r *http.Request) time.Duration {
+	if i := p.tenantRule(r); i >= 0 && p.tenantRules[i].TTL > 0 {
+		return time.Duration(p.tenantRules[i].TTL)
+	}
+	return p.defaultTTL
+}
+
+// tenantIgnoredQueryParams returns the query parameters the tenant of the request leaves out of the cache key
+func (p *Proxy) tenantIgnoredQueryParams(r *http.Request) []string {
+	if i := p.tenantRule(r); i >= 0 {
+		return p.tenantRules[i].IgnoreQueryParams
+	}
+	return nil
+}
+
+// tenantVaryHeaders returns the request headers the tenant of the request adds to the cache key
+func (p *Proxy) tenantVaryHeaders(r *http.Request) []string {
+	if i := p.tenantRule(r); i >= 0 {
+		return p.tenantRules[i].VaryHeaders
+	}
+	return nil
+}
+
+// isTenantBypass reports whether the tenant of the request forwards its path without looking at the cache
+func (p *Proxy) isTenantBypass(r *http.Request) bool {
+	i := p.tenantRule(r)
+	if i < 0 {
+		return false
+	}
+	for _, pattern := range p.tenantRules[i].Bypass {
+		if matchPath(pattern, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectOverLimit answers 429 if the tenant of the request sent more than its rate limit allows,
+// reporting whether the request was rejected
+func (p *Proxy) rejectOverLimit(w http.ResponseWriter, r *http.Request) bool {
+	i := p.tenantRule(r)
+	if i < 0 || p.tenantLimiters[i] == nil {
+		return false
+	}
+	wait, ok := p.tenantLimiters[i].take(normalizeTenant(r.Host), time.Now())
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	p.writeError(w, r, http.StatusTooManyRequests, "Rate limit of the site exceeded")
+	return true
+}
+
+// maxTenantBuckets is the number of tenants a rule tracks before the buckets of idle tenants are dropped
+const maxTenantBuckets = 10000
+
+// tenantLimiter applies the rate limit of a rule to every tenant it matches with a bucket of its own
+type tenantLimiter struct {
+	mu      sync.Mutex
+	rate    float64                 // Requests per second each tenant may send
+	buckets map[string]*rateLimiter // Buckets by tenant
+}
+
+// newTenantLimiter creates a limiter granting every tenant the rate per second
+func newTenantLimiter(rate float64) *tenantLimiter {
+	return &tenantLimiter{rate: rate, buckets: make(map[string]*rateLimiter)}
+}
+
+// take consumes a token of the tenant if one is available, otherwise returning how long until the next one is
+func (t *tenantLimiter) take(tenant string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	bucket, ok := t.buckets[tenant]
+	if !ok {
+		// Hosts come from the clients, so the buckets that refilled are dropped rather than piling up
+		if len(t.buckets) >= maxTenantBuckets {
+			for name, b := range t.buckets {
+				if b.full(now) {
+					delete(t.buckets, name)
+				}
+			}
+		}
+		bucket = newRateLimiter(t.rate, now)
+		t.buckets[tenant] = bucket
+	}
+	t.mu.Unlock()
+	return bucket.take(now)
+}
+
+// rateLimiter is a token bucket refilled at the rate per second, holding up to a second of requests
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // Tokens added per second
+	burst  float64   // Most tokens the bucket holds
+	tokens float64   // Tokens currently available
+	last   time.Time // Time the tokens were last refilled
+}
+
+// newRateLimiter creates a bucket for the rate per second, full at the given time
+func newRateLimiter(rate float64, now time.Time) *rateLimiter {
+	burst := max(rate, 1)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take consumes a token if one is available, otherwise returning how long until the next one is
+func (l *rateLimiter) take(now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second)), false
+}
+
+// full reports whether the bucket has refilled completely by now, so dropping it changes nothing
+func (l *rateLimiter) full(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens+now.Sub(l.last).Seconds()*l.rate >= l.burst
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// maxTrackedURLs limits the number of URLs whose hits are counted; URLs first hit once the limit
+// is reached are not counted
+const maxTrackedURLs = 10000
+
+// URLHits counts the cache hits of a URL
+type URLHits struct {
+	URL  string `json:"url"`  // Requested path with the query
+	Hits uint64 `json:"hits"` // Requests answered from the cache
+}
+
+// urlHits tracks cache hit counters by URL
+type urlHits struct {
+	mu   sync.RWMutex
+	urls map[string]*atomic.Uint64
+}
+
+// add counts a cache hit of the URL
+func (s *urlHits) add(u string) {
+	s.mu.RLock()
+	c, ok := s.urls[u]
+	s.mu.RUnlock()
+	if !ok {
+		s.mu.Lock()
+		if s.urls == nil {
+			s.urls = make(map[string]*atomic.Uint64)
+		}
+		if c, ok = s.urls[u]; !ok {
+			if len(s.urls) >= maxTrackedURLs {
+				s.mu.Unlock()
+				return
+			}
+			c = &atomic.Uint64{}
+			s.urls[u] = c
+		}
+		s.mu.Unlock()
+	}
+	c.Add(1)
+}
+
+// TopURLs returns up to n URLs with the most cache hits, the most hit first
+func (p *Proxy) TopURLs(n int) []URLHits {
+	p.urlHits.mu.RLock()
+	top := make([]URLHits, 0, len(p.urlHits.urls))
+	for u, c := range p.urlHits.urls {
+		top = append(top, URLHits{URL: u, Hits: c.Load()})
+	}
+	p.urlHits.mu.RUnlock()
+
+	slices.SortFunc(top, func(a, b URLHits) int {
+		if c := cmp.Compare(b.Hits, a.Hits); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.URL, b.URL)
+	})
+	return top[:min(n, len(top))]
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// VaryRule makes the cache key of requests matching a path pattern depend on extra request headers,
+// regardless of the Vary header of the origin
+type VaryRule struct {
+	Path    string   `json:"path"`    // Path pattern, e.g. "/api/*"; empty matches every path
+	Headers []string `json:"headers"` // Request headers whose values select the cache variant
+}
+
+// Validate checks that the rule names headers and has a well-formed path pattern
+func (v *VaryRule) Validate() error {
+	if len(v.Headers) == 0 {
+		return fmt.Errorf("vary rule: no headers for path '%s'", v.Path)
+	}
+	if _, err := path.Match(v.Path, "/"); err != nil {
+		return fmt.Errorf("vary rule: invalid path pattern '%s'", v.Path)
+	}
+	return nil
+}
+
+// SetVaryRules sets the rules adding request headers to the cache key
+func (p *Proxy) SetVaryRules(rules []VaryRule) {
+	p.varyRules = rules
+}
+
+// WithVaryRules sets the rules adding request headers to the cache key
+func WithVaryRules(rules []VaryRule) Option {
+	return func(p *Proxy) { p.SetVaryRules(rules) }
+}
+
+// varyKeyParts returns the values of the headers of every rule matching the request path, then of the headers
+// of the tenant rule, as cache key parts
+func (p *Proxy) varyKeyParts(r *http.Request) []string {
+	var parts []string
+	for i := range p.varyRules {
+		rule := &p.varyRules[i]
+		if !matchPath(rule.Path, r.URL.Path) {
+			continue
+		}
+		for _, name := range rule.Headers {
+			name = http.CanonicalHeaderKey(name)
+			parts = append(parts, name+"="+strings.Join(r.Header.Values(name), ","))
+		}
+	}
+	for _, name := range p.tenantVaryHeaders(r) {
+		name = http.CanonicalHeaderKey(name)
+		parts = append(parts, name+"="+strings.Join(r.Header.Values(name), ","))
+	}
+	return parts
+}